@@ -18,8 +18,10 @@ package commands
 
 import (
 	"github.com/nalej/log-download-manager/internal/pkg/server"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"time"
 )
 
 var config = server.Config{}
@@ -33,7 +35,9 @@ var runCmd = &cobra.Command{
 		log.Info().Msg("Launching API!")
 		config.Debug = debugLevel
 		server := server.NewService(config)
-		server.Run()
+		if err := server.Run(); err != nil {
+			log.Fatal().Str("err", err.Error()).Msg("the service failed")
+		}
 	},
 }
 
@@ -47,6 +51,66 @@ func init() {
 	runCmd.PersistentFlags().StringVar(&config.AuthHeader, "authHeader", "", "Authorization Header")
 	runCmd.PersistentFlags().StringVar(&config.AuthSecret, "authSecret", "", "Authorization secret")
 	runCmd.PersistentFlags().StringVar(&config.ManagementPublicHost, "managementPublicHost", "", "Management publish host")
+	runCmd.PersistentFlags().StringVar(&config.OperationStore, "operationStore", "journal",
+		"Storage of the download operations (memory, journal in the download directory or sqlite)")
+	runCmd.PersistentFlags().StringVar(&config.SQLitePath, "sqlitePath", "",
+		"Path of the operations database when using the sqlite store (default <downloadPath>/operations.db)")
+	runCmd.PersistentFlags().StringVar(&config.SchedulesPath, "schedulesPath", "",
+		"Path of the file with the scheduled exports (default <downloadPath>/schedules.json)")
+	runCmd.PersistentFlags().DurationVar(&config.ReadyWindow, "readyWindow", utils.ExpirationTime,
+		"Default time a zip file is ready to download")
+	runCmd.PersistentFlags().DurationVar(&config.MetadataRetention, "metadataRetention", utils.AliveTime,
+		"Default time an operation is stored once it cannot be downloaded")
+	runCmd.PersistentFlags().StringVar(&config.RetentionPolicyFile, "retentionPolicyFile", "",
+		"JSON file with the retention policies of the organizations")
+	runCmd.PersistentFlags().IntVar(&config.GenerationWorkers, "generationWorkers", 4,
+		"Number of log files generated or exported at the same time")
+	runCmd.PersistentFlags().IntVar(&config.GenerationShards, "generationShards", 1,
+		"Number of parts of the window of a log file fetched in parallel")
+	runCmd.PersistentFlags().IntVar(&config.MaxQueueDepth, "maxQueueDepth", 100,
+		"Maximum number of download operations waiting to be generated")
+	runCmd.PersistentFlags().StringVar(&config.SchedulingWeightsFile, "schedulingWeightsFile", "",
+		"JSON file with the scheduling weights of the organizations")
+	runCmd.PersistentFlags().BoolVar(&config.FairByUser, "fairByUser", false,
+		"Share the generation workers between the users of each organization")
+	runCmd.PersistentFlags().IntVar(&config.OrganizationQuota.MaxConcurrent, "orgMaxConcurrent", 0,
+		"Maximum number of queued or generating download operations of an organization (0 is unlimited)")
+	runCmd.PersistentFlags().IntVar(&config.OrganizationQuota.MaxReady, "orgMaxReady", 0,
+		"Maximum number of files ready to download of an organization (0 is unlimited)")
+	runCmd.PersistentFlags().Int64Var(&config.OrganizationQuota.MaxBytes, "orgMaxBytes", 0,
+		"Maximum size in bytes of the stored files of an organization (0 is unlimited)")
+	runCmd.PersistentFlags().IntVar(&config.UserQuota.MaxConcurrent, "userMaxConcurrent", 0,
+		"Maximum number of queued or generating download operations of a user (0 is unlimited)")
+	runCmd.PersistentFlags().IntVar(&config.UserQuota.MaxReady, "userMaxReady", 0,
+		"Maximum number of files ready to download of a user (0 is unlimited)")
+	runCmd.PersistentFlags().Int64Var(&config.UserQuota.MaxBytes, "userMaxBytes", 0,
+		"Maximum size in bytes of the stored files of a user (0 is unlimited)")
+	runCmd.PersistentFlags().Uint64Var(&config.DiskLimits.LowWater, "diskLowWater", 512*1024*1024,
+		"Free space in bytes of the download path below which new download operations are refused")
+	runCmd.PersistentFlags().Uint64Var(&config.DiskLimits.Critical, "diskCritical", 128*1024*1024,
+		"Free space in bytes of the download path below which the largest generation is stopped")
+	runCmd.PersistentFlags().DurationVar(&config.DiskLimits.Interval, "diskCheckInterval", 5*time.Second,
+		"Time between two checks of the free space of the download path")
+	runCmd.PersistentFlags().IntVar(&config.SearchRetry.MaxAttempts, "searchMaxAttempts", 5,
+		"Maximum number of calls to retrieve a page of log entries")
+	runCmd.PersistentFlags().DurationVar(&config.SearchRetry.InitialBackoff, "searchInitialBackoff", time.Second,
+		"Wait before retrying a failed search")
+	runCmd.PersistentFlags().DurationVar(&config.SearchRetry.MaxBackoff, "searchMaxBackoff", 30*time.Second,
+		"Maximum wait between two attempts of a search")
+	runCmd.PersistentFlags().Float64Var(&config.SearchRetry.Multiplier, "searchBackoffMultiplier", 2,
+		"Factor applied to the wait after each failed search")
+	runCmd.PersistentFlags().Float64Var(&config.SearchRetry.Jitter, "searchBackoffJitter", 0.2,
+		"Fraction (0 to 1) of the wait that is randomized")
+	runCmd.PersistentFlags().StringSliceVar(&config.SearchRetry.RetryableCodes, "searchRetryableCodes", utils.DefaultRetryableCodes,
+		"gRPC codes of the failed searches that are retried")
+	runCmd.PersistentFlags().Int64Var(&config.ExportLimits.MaxEntries, "maxExportEntries", 0,
+		"Maximum number of log entries of an export (0 is unlimited)")
+	runCmd.PersistentFlags().Int64Var(&config.ExportLimits.MaxBytes, "maxExportBytes", 0,
+		"Maximum size in bytes of the uncompressed file of an export, the values of the Parquet files before compressing their pages (0 is unlimited)")
+	runCmd.PersistentFlags().DurationVar(&config.ExportLimits.MaxWindow, "maxExportWindow", 0,
+		"Maximum window of an export request, longer ones are rejected (0 is unlimited)")
+	runCmd.PersistentFlags().DurationVar(&config.ReuseGrace, "reuseGrace", 5*time.Minute,
+		"Time the entries of a window may take to be ingested, the artifacts generated before its end plus this time are not reused")
 
 	rootCmd.AddCommand(runCmd)
 }
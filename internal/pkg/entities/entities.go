@@ -50,6 +50,21 @@ func NewSearchRequest(request *grpc_log_download_manager_go.DownloadLogRequest)
 	}
 }
 
+// NewTargetSearchRequest creates the Search request of one of the targets of a download request
+func NewTargetSearchRequest(request *grpc_log_download_manager_go.DownloadLogRequest, target int) *grpc_application_manager_go.SearchRequest {
+	searchRequest := NewSearchRequest(request)
+	if target < len(request.Targets) {
+		selected := request.Targets[target]
+		searchRequest.AppDescriptorId = selected.AppDescriptorId
+		searchRequest.AppInstanceId = selected.AppInstanceId
+		searchRequest.ServiceGroupId = selected.ServiceGroupId
+		searchRequest.ServiceGroupInstanceId = selected.ServiceGroupInstanceId
+		searchRequest.ServiceId = selected.ServiceId
+		searchRequest.ServiceInstanceId = selected.ServiceInstanceId
+	}
+	return searchRequest
+}
+
 func NewDownloadLogResponse(request *grpc_log_download_manager_go.DownloadRequestId, opeInfo *utils.DownloadOperation) *grpc_log_download_manager_go.DownloadLogResponse {
 	return &grpc_log_download_manager_go.DownloadLogResponse{
 		OrganizationId: request.OrganizationId,
@@ -60,6 +75,13 @@ func NewDownloadLogResponse(request *grpc_log_download_manager_go.DownloadReques
 		Expiration:     opeInfo.Expiration,
 		Info:           opeInfo.Info,
 		Url:            opeInfo.Url,
+		Retention:      opeInfo.Retention,
+		EntriesWritten: opeInfo.Progress.Entries,
+		BytesWritten:   opeInfo.Progress.Bytes,
+		PagesFetched:   int32(opeInfo.Progress.Pages),
+		Progress:       opeInfo.Progress.Covered,
+		SearchRetries:  int32(opeInfo.Progress.Retries),
+		TargetEntries:  utils.TargetsToGRPC(opeInfo.Progress.Targets),
 	}
 
 }
@@ -20,15 +20,87 @@ import (
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-log-download-manager-go"
 	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"time"
 )
 
 const emptyOrganizationId = "organization_id cannot be empty"
 const emptyRequestId = "request_id cannot be empty"
+const invalidPriority = "priority is not valid"
+const negativeLimits = "max_entries, max_bytes and max_window cannot be negative"
+const negativePartLimits = "part_max_entries and part_max_bytes cannot be negative"
+const invalidFormat = "format is not valid"
+const invalidArchive = "archive is not valid"
+const unknownColumn = "column is not a field of the log entries"
+const columnsWithoutCSV = "columns can only be selected in the CSV format"
+const windowTooLong = "the window exceeds the maximum allowed"
+const emptyScheduleId = "schedule_id cannot be empty"
+const emptyCron = "cron cannot be empty"
+const emptyTemplate = "request cannot be empty"
+const invalidScheduleWindow = "window is not valid"
+const invalidPeriod = "period must be positive for LAST_PERIOD windows"
+const targetsAndTarget = "targets cannot be combined with the descriptor, instance, service group or service of the request"
+const emptyTarget = "a target must select at least an application descriptor or instance"
 
 func ValidDownloadLogRequest(request *grpc_log_download_manager_go.DownloadLogRequest) derrors.Error {
 	if request.OrganizationId == "" {
 		return derrors.NewInvalidArgumentError(emptyOrganizationId)
 	}
+	if _, exists := grpc_log_download_manager_go.DownloadPriority_name[int32(request.Priority)]; !exists {
+		return derrors.NewInvalidArgumentError(invalidPriority).WithParams(request.Priority)
+	}
+	if request.MaxEntries < 0 || request.MaxBytes < 0 || request.MaxWindow < 0 {
+		return derrors.NewInvalidArgumentError(negativeLimits)
+	}
+	if request.PartMaxEntries < 0 || request.PartMaxBytes < 0 {
+		return derrors.NewInvalidArgumentError(negativePartLimits)
+	}
+	if _, exists := grpc_log_download_manager_go.OutputFormat_name[int32(request.Format)]; !exists {
+		return derrors.NewInvalidArgumentError(invalidFormat).WithParams(request.Format)
+	}
+	if !utils.ValidArchiveFormat(request.Archive) {
+		return derrors.NewInvalidArgumentError(invalidArchive).WithParams(request.Archive)
+	}
+	if len(request.Columns) > 0 && request.Format != grpc_log_download_manager_go.OutputFormat_CSV {
+		return derrors.NewInvalidArgumentError(columnsWithoutCSV).WithParams(request.Format)
+	}
+	for _, column := range request.Columns {
+		if !utils.ValidCSVColumn(column) {
+			return derrors.NewInvalidArgumentError(unknownColumn).WithParams(column)
+		}
+	}
+	return validTargets(request)
+}
+
+// validTargets checks that the targets of a request (if any) replace its own target
+func validTargets(request *grpc_log_download_manager_go.DownloadLogRequest) derrors.Error {
+	if len(request.Targets) == 0 {
+		return nil
+	}
+	if request.AppDescriptorId != "" || request.AppInstanceId != "" || request.ServiceGroupId != "" ||
+		request.ServiceGroupInstanceId != "" || request.ServiceId != "" || request.ServiceInstanceId != "" {
+		return derrors.NewInvalidArgumentError(targetsAndTarget)
+	}
+	for i, target := range request.Targets {
+		if target == nil || (target.AppDescriptorId == "" && target.AppInstanceId == "") {
+			return derrors.NewInvalidArgumentError(emptyTarget).WithParams(i)
+		}
+	}
+	return nil
+}
+
+// ValidDownloadLogWindow checks that the window of a request does not exceed the maximum (0 means unlimited)
+func ValidDownloadLogWindow(request *grpc_log_download_manager_go.DownloadLogRequest, maxWindow time.Duration) derrors.Error {
+	if maxWindow <= 0 {
+		return nil
+	}
+	to := request.To
+	if to == 0 {
+		to = time.Now().UnixNano()
+	}
+	if to-request.From > int64(maxWindow) {
+		return derrors.NewInvalidArgumentError(windowTooLong).WithParams(maxWindow.String())
+	}
 	return nil
 }
 
@@ -48,4 +120,36 @@ func ValidOrganizationId (organizationID *grpc_organization_go.OrganizationId) d
 		return derrors.NewInvalidArgumentError(emptyOrganizationId)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func ValidCreateScheduleRequest(request *grpc_log_download_manager_go.CreateScheduleRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if request.Cron == "" {
+		return derrors.NewInvalidArgumentError(emptyCron)
+	}
+	if request.Request == nil {
+		return derrors.NewInvalidArgumentError(emptyTemplate)
+	}
+	if _, exists := grpc_log_download_manager_go.ScheduleWindow_name[int32(request.Window)]; !exists {
+		return derrors.NewInvalidArgumentError(invalidScheduleWindow).WithParams(request.Window)
+	}
+	if request.Window == grpc_log_download_manager_go.ScheduleWindow_LAST_PERIOD && request.Period <= 0 {
+		return derrors.NewInvalidArgumentError(invalidPeriod)
+	}
+	// the template is validated as a request of the organization
+	template := *request.Request
+	template.OrganizationId = request.OrganizationId
+	return ValidDownloadLogRequest(&template)
+}
+
+func ValidScheduleId(request *grpc_log_download_manager_go.ScheduleId) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if request.ScheduleId == "" {
+		return derrors.NewInvalidArgumentError(emptyScheduleId)
+	}
+	return nil
+}
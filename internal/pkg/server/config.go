@@ -18,9 +18,12 @@ package server
 
 import (
 	"github.com/nalej/derrors"
+	"github.com/nalej/log-download-manager/internal/pkg/server/log-manager"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
 	"github.com/nalej/log-download-manager/version"
 	"github.com/rs/zerolog/log"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -40,6 +43,73 @@ type Config struct {
 	AuthHeader string
 	// ManagementPublicHost contains the public host of the management cluster
 	ManagementPublicHost string
+	// OperationStore with the type of storage of the download operations (memory, journal or sqlite)
+	OperationStore string
+	// SQLitePath with the path of the operations database when the sqlite store is used
+	SQLitePath string
+	// SchedulesPath with the path of the file with the scheduled exports
+	SchedulesPath string
+	// ReadyWindow with the default time a zip file is ready to download
+	ReadyWindow time.Duration
+	// MetadataRetention with the default time an operation is stored once it cannot be downloaded
+	MetadataRetention time.Duration
+	// RetentionPolicyFile with the path of the file with the retention policies of the organizations
+	RetentionPolicyFile string
+	// GenerationWorkers with the number of log files generated or exported at the same time
+	GenerationWorkers int
+	// GenerationShards with the number of parts of the window of a log file fetched in parallel
+	GenerationShards int
+	// MaxQueueDepth with the maximum number of operations waiting for a generation worker
+	MaxQueueDepth int
+	// SchedulingWeightsFile with the path of the file with the scheduling weights of the organizations
+	SchedulingWeightsFile string
+	// FairByUser shares the generation workers between the users of an organization
+	FairByUser bool
+	// OrganizationQuota with the limits of the download operations of each organization
+	OrganizationQuota utils.Quota
+	// UserQuota with the limits of the download operations of each user
+	UserQuota utils.Quota
+	// DiskLimits with the free space required in the download path
+	DiskLimits utils.DiskLimits
+	// SearchRetry with the retry policy of the Search calls to the Applications manager
+	SearchRetry utils.RetryPolicy
+	// ExportLimits with the maximum entries, bytes and window of the exports, the requests can only lower them
+	ExportLimits utils.ExportLimits
+	// ReuseGrace with the time the entries of a window may take to be ingested, the artifacts generated before the
+	// end of their window plus this time are not reused
+	ReuseGrace time.Duration
+}
+
+// GetRetentionPolicies loads the default retention policy and the overrides of the organizations
+func (conf *Config) GetRetentionPolicies() (*utils.RetentionPolicies, derrors.Error) {
+	return utils.LoadRetentionPolicies(utils.RetentionPolicy{
+		ReadyWindow:       conf.ReadyWindow,
+		MetadataRetention: conf.MetadataRetention,
+	}, conf.RetentionPolicyFile)
+}
+
+// GetManagerOptions returns the configuration of the log manager
+func (conf *Config) GetManagerOptions(weights *utils.SchedulingWeights, schedules utils.ScheduleStore) log_manager.Options {
+	return log_manager.Options{
+		DownloadDirectory: conf.DownloadPath,
+		Workers:           conf.GenerationWorkers,
+		Shards:            conf.GenerationShards,
+		MaxQueueDepth:     conf.MaxQueueDepth,
+		Weights:           weights,
+		FairByUser:        conf.FairByUser,
+		OrganizationQuota: conf.OrganizationQuota,
+		UserQuota:         conf.UserQuota,
+		DiskLimits:        conf.DiskLimits,
+		RetryPolicy:       conf.SearchRetry,
+		Limits:            conf.ExportLimits,
+		ReuseGrace:        conf.ReuseGrace,
+		Schedules:         schedules,
+	}
+}
+
+// GetSchedulingWeights loads the scheduling weights of the organizations
+func (conf *Config) GetSchedulingWeights() (*utils.SchedulingWeights, derrors.Error) {
+	return utils.LoadSchedulingWeights(conf.SchedulingWeightsFile)
 }
 
 func (conf *Config) Validate() derrors.Error {
@@ -60,6 +130,46 @@ func (conf *Config) Validate() derrors.Error {
 		return derrors.NewInvalidArgumentError("DownloadDir must be set")
 	}
 
+	if conf.OperationStore != utils.MemoryStore && conf.OperationStore != utils.JournalStore && conf.OperationStore != utils.SQLiteStore {
+		return derrors.NewInvalidArgumentError("operationStore must be memory, journal or sqlite").WithParams(conf.OperationStore)
+	}
+
+	if conf.ReadyWindow <= 0 || conf.MetadataRetention <= 0 {
+		return derrors.NewInvalidArgumentError("readyWindow and metadataRetention must be positive")
+	}
+
+	if conf.GenerationWorkers <= 0 || conf.MaxQueueDepth <= 0 {
+		return derrors.NewInvalidArgumentError("generationWorkers and maxQueueDepth must be positive")
+	}
+
+	if conf.GenerationShards <= 0 {
+		return derrors.NewInvalidArgumentError("generationShards must be positive").WithParams(conf.GenerationShards)
+	}
+
+	if err := conf.OrganizationQuota.Validate(); err != nil {
+		return err
+	}
+
+	if err := conf.UserQuota.Validate(); err != nil {
+		return err
+	}
+
+	if err := conf.DiskLimits.Validate(); err != nil {
+		return err
+	}
+
+	if err := conf.SearchRetry.Validate(); err != nil {
+		return err
+	}
+
+	if err := conf.ExportLimits.Validate(); err != nil {
+		return err
+	}
+
+	if conf.ReuseGrace < 0 {
+		return derrors.NewInvalidArgumentError("reuseGrace cannot be negative").WithParams(conf.ReuseGrace)
+	}
+
 	if conf.AuthHeader == "" || conf.AuthSecret == "" {
 		return derrors.NewInvalidArgumentError("Authorization header and secret must be set")
 	}
@@ -74,6 +184,24 @@ func (conf *Config) Print() {
 	log.Info().Str("URL", conf.ApplicationsManagerAddress).Msg("Applications Manager")
 	log.Info().Str("Host", conf.ManagementPublicHost).Msg("Public Host")
 	log.Info().Str("DownloadPath", conf.DownloadPath).Msg("download Path")
+	log.Info().Str("type", conf.OperationStore).Str("SQLitePath", conf.SQLitePath).Msg("Operation store")
+	log.Info().Str("SchedulesPath", conf.SchedulesPath).Msg("Schedules")
+	log.Info().Str("readyWindow", conf.ReadyWindow.String()).Str("metadataRetention", conf.MetadataRetention.String()).
+		Str("policyFile", conf.RetentionPolicyFile).Msg("Retention")
+	log.Info().Int("workers", conf.GenerationWorkers).Int("shards", conf.GenerationShards).Int("maxQueueDepth", conf.MaxQueueDepth).
+		Str("weightsFile", conf.SchedulingWeightsFile).Bool("fairByUser", conf.FairByUser).Msg("Generation")
+	log.Info().Int("maxConcurrent", conf.OrganizationQuota.MaxConcurrent).Int("maxReady", conf.OrganizationQuota.MaxReady).
+		Int64("maxBytes", conf.OrganizationQuota.MaxBytes).Msg("Organization quota")
+	log.Info().Int("maxConcurrent", conf.UserQuota.MaxConcurrent).Int("maxReady", conf.UserQuota.MaxReady).
+		Int64("maxBytes", conf.UserQuota.MaxBytes).Msg("User quota")
+	log.Info().Uint64("lowWater", conf.DiskLimits.LowWater).Uint64("critical", conf.DiskLimits.Critical).
+		Str("interval", conf.DiskLimits.Interval.String()).Msg("Disk limits")
+	log.Info().Int("maxAttempts", conf.SearchRetry.MaxAttempts).Str("initialBackoff", conf.SearchRetry.InitialBackoff.String()).
+		Str("maxBackoff", conf.SearchRetry.MaxBackoff.String()).Float64("multiplier", conf.SearchRetry.Multiplier).
+		Float64("jitter", conf.SearchRetry.Jitter).Strs("codes", conf.SearchRetry.RetryableCodes).Msg("Search retry")
+	log.Info().Int64("maxEntries", conf.ExportLimits.MaxEntries).Int64("maxBytes", conf.ExportLimits.MaxBytes).
+		Str("maxWindow", conf.ExportLimits.MaxWindow.String()).Msg("Export limits")
+	log.Info().Str("grace", conf.ReuseGrace.String()).Msg("Artifact reuse")
 	log.Info().Str("header", conf.AuthHeader).Str("secret", strings.Repeat("*", len(conf.AuthSecret))).Msg("Authorization")
 
 }
@@ -34,3 +34,7 @@ func NewHandler(manager Manager) *Handler {
 func (h *Handler) DownloadFile() http.Handler {
 	return h.Manager.DownloadFile()
 }
+
+func (h *Handler) CancelOperation() http.Handler {
+	return h.Manager.CancelOperation()
+}
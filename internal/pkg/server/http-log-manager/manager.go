@@ -17,8 +17,10 @@
 package http_log_manager
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
 	"github.com/nalej/log-download-manager/internal/pkg/server/interceptor"
 	"github.com/nalej/log-download-manager/internal/pkg/utils"
 	"github.com/rs/zerolog/log"
@@ -28,27 +30,55 @@ import (
 	"time"
 )
 
+// Canceller is the interface of the component that stops the generation of the operations
+type Canceller interface {
+	Cancel(request *grpc_log_download_manager_go.DownloadRequestId, userID string) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error)
+}
+
 // Manager structure with the required clients for http log download operations.
 type Manager struct {
-	opeCache          *utils.DownloadCache
+	opeCache          utils.OperationStore
+	canceller         Canceller
 	interceptor       *interceptor.Interceptor
 	pathPrefix        string
+	cancelPathPrefix  string
 	DownloadDirectory string
 }
 
-func NewManager(opeCache *utils.DownloadCache, secret string, authHeader string, pathPrefix string, dir string) Manager {
+func NewManager(opeCache utils.OperationStore, canceller Canceller, secret string, authHeader string, pathPrefix string, cancelPathPrefix string, dir string) Manager {
 	return Manager{
 		opeCache:          opeCache,
+		canceller:         canceller,
 		interceptor:       interceptor.NewInterceptor(secret, authHeader),
 		pathPrefix:        pathPrefix,
+		cancelPathPrefix:  cancelPathPrefix,
 		DownloadDirectory: dir,
 	}
 }
 
+// httpStatus returns the http status code of an error
+func httpStatus(err derrors.Error) int {
+	switch err.Type() {
+	case derrors.InvalidArgument:
+		return http.StatusBadRequest
+	case derrors.NotFound:
+		return http.StatusNotFound
+	case derrors.PermissionDenied:
+		return http.StatusForbidden
+	case derrors.FailedPrecondition:
+		return http.StatusConflict
+	case derrors.Unauthenticated:
+		return http.StatusUnauthorized
+	}
+	return http.StatusInternalServerError
+}
+
+// SplitPath returns the file and the request id of a download path, the request id is followed by the extension
+// of the archive (that can contain dots, e.g. .tar.gz)
 func (m *Manager) SplitPath(path string) (string, string, derrors.Error) {
 	p := strings.TrimPrefix(path, m.pathPrefix)
-	split := strings.Split(p, ".")
-	if len(split) != 2 {
+	split := strings.SplitN(p, ".", 2)
+	if len(split) != 2 || utils.ArchiveContentType(p) == "" {
 		return "", "", derrors.NewInvalidArgumentError("invalid path").WithParams(path)
 	}
 	return p, split[0], nil
@@ -58,7 +88,7 @@ func (m *Manager) SplitPath(path string) (string, string, derrors.Error) {
 func (m *Manager) ValidToDownload(ope *utils.DownloadOperation) derrors.Error {
 
 	switch ope.State {
-	case utils.Error, utils.Queue, utils.Generating:
+	case utils.Error, utils.Queue, utils.Generating, utils.Cancelled:
 		errMsg := fmt.Sprintf("download operation is not ready. State (%s)", ope.State.ToString())
 		if ope.Info != "" {
 			errMsg = fmt.Sprintf("%s - %s", errMsg, ope.Info)
@@ -118,6 +148,14 @@ func (m *Manager) DownloadFile() http.Handler {
 			return
 		}
 
+		// only the archive of the operation can be downloaded
+		if file != ope.RequestId+ope.ArchiveExtension() {
+			http.Error(w, "file not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", utils.ArchiveContentType(file))
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file))
+
 		r2 := new(http.Request)
 		*r2 = *r
 		r2.URL = new(url.URL)
@@ -129,3 +167,47 @@ func (m *Manager) DownloadFile() http.Handler {
 		err = m.opeCache.Update(requestId, utils.Downloaded, user)
 	})
 }
+
+// CancelOperation stops the generation of the operation whose request id is the last element of the path
+func (m *Manager) CancelOperation() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		vErr := m.interceptor.Validate(r)
+		if vErr != nil {
+			http.Error(w, vErr.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		// the operations are only cancelled by their owners, the token must identify them
+		organizationID, userID := r.Header.Get(interceptor.OrganizationID), r.Header.Get(interceptor.UserID)
+		if organizationID == "" || userID == "" {
+			http.Error(w, "the token does not identify the organization and the user", http.StatusUnauthorized)
+			return
+		}
+
+		requestId := strings.TrimPrefix(r.URL.Path, m.cancelPathPrefix)
+		if requestId == "" || strings.Contains(requestId, "/") {
+			http.Error(w, "invalid path", http.StatusBadRequest)
+			return
+		}
+
+		response, err := m.canceller.Cancel(&grpc_log_download_manager_go.DownloadRequestId{
+			OrganizationId: organizationID,
+			RequestId:      requestId,
+		}, userID)
+		if err != nil {
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if encErr := json.NewEncoder(w).Encode(response); encErr != nil {
+			log.Warn().Str("requestId", requestId).Msg("error writing cancel response")
+		}
+	})
+}
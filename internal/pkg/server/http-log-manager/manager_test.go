@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http_log_manager
+
+import (
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/server/interceptor"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+const (
+	testSecret     = "secret"
+	testAuthHeader = "Authorization"
+	testCancelPath = "/cancel/"
+)
+
+// fakeCanceller records the cancelled requests and answers with the configured error
+type fakeCanceller struct {
+	requests []*grpc_log_download_manager_go.DownloadRequestId
+	users    []string
+	err      derrors.Error
+}
+
+func (c *fakeCanceller) Cancel(request *grpc_log_download_manager_go.DownloadRequestId, userID string) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error) {
+	c.requests = append(c.requests, request)
+	c.users = append(c.users, userID)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &grpc_log_download_manager_go.DownloadLogResponse{
+		OrganizationId: request.OrganizationId,
+		RequestId:      request.RequestId,
+		State:          grpc_log_download_manager_go.DownloadLogState_CANCELLED,
+	}, nil
+}
+
+var _ = ginkgo.Describe("Http log manager", func() {
+
+	var canceller *fakeCanceller
+	var manager Manager
+
+	ginkgo.BeforeEach(func() {
+		canceller = &fakeCanceller{}
+		manager = NewManager(utils.NewDownloadCache("/test/", "nalej.tech"), canceller, testSecret, testAuthHeader,
+			"/download/", testCancelPath, "./")
+	})
+
+	// sign adds to a request a token of the given organization and user
+	sign := func(request *http.Request, organizationID string, userID string) {
+		claim := &interceptor.Claim{PersonalClaim: interceptor.PersonalClaim{
+			UserID:         userID,
+			Primitives:     []string{"ORG"},
+			OrganizationID: organizationID,
+		}}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(testSecret))
+		gomega.Expect(err).To(gomega.Succeed())
+		request.Header.Set(testAuthHeader, token)
+	}
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		manager.CancelOperation().ServeHTTP(recorder, request)
+		return recorder
+	}
+	// cancel sends a cancel request with a token of the organization "org" and the user "user", and returns the response
+	cancel := func(method string, path string, signed bool) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, nil)
+		if signed {
+			sign(request, "org", "user")
+		}
+		return serve(request)
+	}
+
+	ginkgo.It("should cancel the operation of the path", func() {
+		recorder := cancel(http.MethodPost, testCancelPath+"request", true)
+		gomega.Expect(recorder.Code).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(recorder.Header().Get("Content-Type")).Should(gomega.Equal("application/json"))
+
+		response := &grpc_log_download_manager_go.DownloadLogResponse{}
+		gomega.Expect(json.Unmarshal(recorder.Body.Bytes(), response)).To(gomega.Succeed())
+		gomega.Expect(response.RequestId).Should(gomega.Equal("request"))
+		gomega.Expect(response.State).Should(gomega.Equal(grpc_log_download_manager_go.DownloadLogState_CANCELLED))
+
+		gomega.Expect(canceller.requests).Should(gomega.HaveLen(1))
+		gomega.Expect(canceller.requests[0].OrganizationId).Should(gomega.Equal("org"))
+		gomega.Expect(canceller.requests[0].RequestId).Should(gomega.Equal("request"))
+		gomega.Expect(canceller.users).Should(gomega.Equal([]string{"user"}))
+	})
+
+	ginkgo.It("should reject the invalid requests", func() {
+		gomega.Expect(cancel(http.MethodGet, testCancelPath+"request", true).Code).Should(gomega.Equal(http.StatusMethodNotAllowed))
+		gomega.Expect(cancel(http.MethodPost, testCancelPath+"request", false).Code).Should(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(cancel(http.MethodPost, testCancelPath, true).Code).Should(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(cancel(http.MethodPost, testCancelPath+"request/other", true).Code).Should(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(canceller.requests).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should take the organization and the user from the token", func() {
+		request := httptest.NewRequest(http.MethodPost, testCancelPath+"request", nil)
+		request.Header.Set(interceptor.OrganizationID, "other")
+		request.Header.Set(interceptor.UserID, "")
+		sign(request, "org", "user")
+		gomega.Expect(serve(request).Code).Should(gomega.Equal(http.StatusOK))
+		gomega.Expect(canceller.requests).Should(gomega.HaveLen(1))
+		gomega.Expect(canceller.requests[0].OrganizationId).Should(gomega.Equal("org"))
+		gomega.Expect(canceller.users).Should(gomega.Equal([]string{"user"}))
+	})
+
+	ginkgo.It("should reject the tokens without organization or user", func() {
+		for _, identity := range [][]string{{"org", ""}, {"", "user"}} {
+			request := httptest.NewRequest(http.MethodPost, testCancelPath+"request", nil)
+			// the forged headers do not complete the identity of the token
+			request.Header.Set(interceptor.OrganizationID, "org")
+			request.Header.Set(interceptor.UserID, "user")
+			sign(request, identity[0], identity[1])
+			gomega.Expect(serve(request).Code).Should(gomega.Equal(http.StatusUnauthorized))
+		}
+		gomega.Expect(canceller.requests).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should answer the errors of the cancellation with their status", func() {
+		canceller.err = derrors.NewFailedPreconditionError("the operation is not running")
+		gomega.Expect(cancel(http.MethodPost, testCancelPath+"request", true).Code).Should(gomega.Equal(http.StatusConflict))
+		canceller.err = derrors.NewNotFoundError("operation not found")
+		gomega.Expect(cancel(http.MethodPost, testCancelPath+"request", true).Code).Should(gomega.Equal(http.StatusNotFound))
+		canceller.err = derrors.NewPermissionDeniedError("operation not allowed for the organization")
+		gomega.Expect(cancel(http.MethodPost, testCancelPath+"request", true).Code).Should(gomega.Equal(http.StatusForbidden))
+	})
+
+	ginkgo.It("should map the errors to http status codes", func() {
+		gomega.Expect(httpStatus(derrors.NewInvalidArgumentError("invalid"))).Should(gomega.Equal(http.StatusBadRequest))
+		gomega.Expect(httpStatus(derrors.NewNotFoundError("not found"))).Should(gomega.Equal(http.StatusNotFound))
+		gomega.Expect(httpStatus(derrors.NewPermissionDeniedError("denied"))).Should(gomega.Equal(http.StatusForbidden))
+		gomega.Expect(httpStatus(derrors.NewFailedPreconditionError("not running"))).Should(gomega.Equal(http.StatusConflict))
+		gomega.Expect(httpStatus(derrors.NewUnauthenticatedError("no token"))).Should(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(httpStatus(derrors.NewInternalError("internal"))).Should(gomega.Equal(http.StatusInternalServerError))
+	})
+})
@@ -83,8 +83,9 @@ func (i *Interceptor) checkJWT(r *http.Request) derrors.Error {
 
 	userAuth := tk.Claims.(*Claim)
 
-	r.Header.Add(UserID, userAuth.UserID)
-	r.Header.Add(OrganizationID, userAuth.OrganizationID)
+	// the values sent by the client in these headers are replaced
+	r.Header.Set(UserID, userAuth.UserID)
+	r.Header.Set(OrganizationID, userAuth.OrganizationID)
 
 	// check the role permissions
 	found := false
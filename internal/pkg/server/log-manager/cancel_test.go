@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"os"
+	"time"
+)
+
+const cancelTestDir = "./cancelTestDir/"
+
+// blockingLoggingClient signals every Search call and blocks it until its context is done
+type blockingLoggingClient struct {
+	started chan bool
+}
+
+func (c *blockingLoggingClient) Search(ctx context.Context, in *grpc_application_manager_go.SearchRequest, opts ...grpc.CallOption) (*grpc_application_manager_go.LogResponse, error) {
+	c.started <- true
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+var _ = ginkgo.Describe("Cancellation", func() {
+
+	var manager *Manager
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(cancelTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		manager.dispatcher.stop()
+		err := os.RemoveAll(cancelTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	// newCancelManager creates the manager generating the operations with the given number of workers
+	newCancelManager := func(client grpc_application_manager_go.UnifiedLoggingClient, workers int) {
+		manager = &Manager{
+			appManagerClient:  client,
+			opeCache:          utils.NewDownloadCache("/test/", "nalej.tech"),
+			DownloadDirectory: cancelTestDir,
+			running:           newRunningOperations(),
+			retryPolicy:       utils.RetryPolicy{MaxAttempts: 1},
+			stop:              make(chan struct{}),
+		}
+		manager.dispatcher = newDispatcher(newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), false),
+			workers, 10, manager.download)
+	}
+
+	// submit queues a new operation and returns its id
+	submit := func(manager *Manager) *grpc_log_download_manager_go.DownloadRequestId {
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+		}
+		requestId := uuid.New().String()
+		_, err := manager.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, cancelTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(utils.InitializeFile(utils.GetFilePath(cancelTestDir, requestId), false)).To(gomega.Succeed())
+		err = manager.dispatcher.enqueue(&job{
+			ctx:        manager.running.start(requestId),
+			requestId:  requestId,
+			request:    request,
+			checkpoint: utils.NewCheckpoint(request, request.From, request.To, 1, 0),
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		return &grpc_log_download_manager_go.DownloadRequestId{OrganizationId: request.OrganizationId, RequestId: requestId}
+	}
+
+	state := func(manager *Manager, requestId string) func() utils.DownloadLogState {
+		return func() utils.DownloadLogState {
+			ope, err := manager.opeCache.Get(requestId)
+			gomega.Expect(err).To(gomega.Succeed())
+			return ope.State
+		}
+	}
+
+	exists := func(path string) func() bool {
+		return func() bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}
+	}
+
+	ginkgo.It("should cancel a queued operation", func() {
+		newCancelManager(newFakeLoggingClient(2, 1, 2, 3), 0)
+		id := submit(manager)
+		gomega.Expect(manager.dispatcher.positions()).Should(gomega.HaveKey(id.RequestId))
+
+		response, err := manager.Cancel(id, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.State).Should(gomega.Equal(grpc_log_download_manager_go.DownloadLogState_CANCELLED))
+		gomega.Expect(response.Info).Should(gomega.Equal(CancelledMsg))
+		gomega.Expect(response.QueuePosition).Should(gomega.BeZero())
+		gomega.Expect(manager.dispatcher.positions()).Should(gomega.BeEmpty())
+		gomega.Expect(exists(utils.GetFilePath(cancelTestDir, id.RequestId))()).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should cancel an operation being generated", func() {
+		client := &blockingLoggingClient{started: make(chan bool, 10)}
+		newCancelManager(client, 1)
+		id := submit(manager)
+		gomega.Eventually(client.started, 5*time.Second).Should(gomega.Receive())
+		gomega.Expect(state(manager, id.RequestId)()).Should(gomega.Equal(utils.Generating))
+
+		response, err := manager.Cancel(id, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.State).Should(gomega.Equal(grpc_log_download_manager_go.DownloadLogState_CANCELLED))
+
+		// the worker removes the files when the generation stops, without changing the state
+		gomega.Eventually(exists(utils.GetFilePath(cancelTestDir, id.RequestId)), 5*time.Second).Should(gomega.BeFalse())
+		gomega.Expect(utils.GetSegmentPaths(cancelTestDir, id.RequestId)).Should(gomega.BeEmpty())
+		gomega.Consistently(state(manager, id.RequestId), 100*time.Millisecond).Should(gomega.Equal(utils.Cancelled))
+	})
+
+	ginkgo.It("should not cancel a finished operation", func() {
+		newCancelManager(newFakeLoggingClient(2, 1, 2, 3), 1)
+		id := submit(manager)
+		gomega.Eventually(state(manager, id.RequestId), 5*time.Second).Should(gomega.Equal(utils.Ready))
+
+		_, err := manager.Cancel(id, "")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.FailedPrecondition))
+		gomega.Expect(state(manager, id.RequestId)()).Should(gomega.Equal(utils.Ready))
+		gomega.Expect(exists(utils.GetZipFilePath(cancelTestDir, id.RequestId))()).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should not cancel the operations of other organizations", func() {
+		newCancelManager(newFakeLoggingClient(2, 1, 2, 3), 0)
+		id := submit(manager)
+
+		_, err := manager.Cancel(&grpc_log_download_manager_go.DownloadRequestId{OrganizationId: "other", RequestId: id.RequestId}, "")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.PermissionDenied))
+		gomega.Expect(state(manager, id.RequestId)()).Should(gomega.Equal(utils.Queue))
+
+		_, err = manager.Cancel(&grpc_log_download_manager_go.DownloadRequestId{OrganizationId: "org", RequestId: uuid.New().String()}, "")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should keep the generations interrupted by the stop of the manager", func() {
+		client := &blockingLoggingClient{started: make(chan bool, 10)}
+		newCancelManager(client, 1)
+		id := submit(manager)
+		gomega.Eventually(client.started, 5*time.Second).Should(gomega.Receive())
+
+		// the manager waits for the worker, the operation is resumed in the next execution
+		manager.Stop()
+		manager.dispatcher.Lock()
+		busy := manager.dispatcher.busy
+		manager.dispatcher.Unlock()
+		gomega.Expect(busy).Should(gomega.BeZero())
+		gomega.Expect(state(manager, id.RequestId)()).Should(gomega.Equal(utils.Generating))
+		gomega.Expect(exists(utils.GetFilePath(cancelTestDir, id.RequestId))()).Should(gomega.BeTrue())
+		gomega.Expect(manager.running.ids()).Should(gomega.ContainElement(id.RequestId))
+	})
+})
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/nalej/grpc-application-manager-go"
+)
+
+// pageCursor keeps the boundary between the pages of a generation. The next page starts exactly at the
+// timestamp of the last entry written (the search windows are inclusive), so the entries sharing that
+// timestamp are returned again and skipped using the keys of the ones already written.
+type pageCursor struct {
+	ascending bool
+	// boundary is the timestamp (ns) where the next page starts
+	boundary int64
+	// seen contains the keys of the entries written with the boundary timestamp
+	seen map[string]bool
+	// incomplete is the reason why some entries could not be retrieved, empty if none was missed
+	incomplete string
+}
+
+// newPageCursor creates a cursor starting at the boundary with the keys of the entries already written on it
+func newPageCursor(ascending bool, boundary int64, seen []string) *pageCursor {
+	cursor := &pageCursor{
+		ascending: ascending,
+		boundary:  boundary,
+		seen:      make(map[string]bool, len(seen)),
+	}
+	for _, key := range seen {
+		cursor.seen[key] = true
+	}
+	return cursor
+}
+
+// entryKey identifies an entry by its timestamp and its content
+func entryKey(entry *grpc_application_manager_go.LogEntryResponse) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d|%s|%s|%s|%s|%s|%s", entry.Timestamp, entry.AppInstanceId,
+		entry.ServiceGroupInstanceId, entry.ServiceId, entry.ServiceInstanceId, entry.AppDescriptorId, entry.Msg)))
+	return hex.EncodeToString(hash[:])
+}
+
+// behind checks if a timestamp is before the boundary in the order of the generation
+func (c *pageCursor) behind(timestamp int64) bool {
+	if c.ascending {
+		return timestamp < c.boundary
+	}
+	return timestamp > c.boundary
+}
+
+// next receives a page sorted in the order of the generation and returns the entries not written yet,
+// moving the boundary to the last of them
+func (c *pageCursor) next(entries []*grpc_application_manager_go.LogEntryResponse) []*grpc_application_manager_go.LogEntryResponse {
+	fresh := make([]*grpc_application_manager_go.LogEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		if c.behind(entry.Timestamp) {
+			continue
+		}
+		key := entryKey(entry)
+		if entry.Timestamp == c.boundary && c.seen[key] {
+			continue
+		}
+		if entry.Timestamp != c.boundary {
+			c.boundary = entry.Timestamp
+			c.seen = make(map[string]bool, 0)
+		}
+		c.seen[key] = true
+		fresh = append(fresh, entry)
+	}
+	return fresh
+}
+
+// skipBoundary moves the boundary past the current timestamp. It is used when the entries of the boundary
+// timestamp have been retrieved from both ends of the order.
+func (c *pageCursor) skipBoundary() {
+	if c.ascending {
+		c.boundary++
+	} else {
+		c.boundary--
+	}
+	c.seen = make(map[string]bool, 0)
+}
+
+// missed records that some entries of the boundary timestamp could not be retrieved, only the first
+// timestamp is reported
+func (c *pageCursor) missed() {
+	if c.incomplete == "" {
+		c.incomplete = fmt.Sprintf("entries of the timestamp %d not retrieved, it has more entries than fit in two pages", c.boundary)
+	}
+}
+
+// keys returns the keys of the entries written with the boundary timestamp
+func (c *pageCursor) keys() []string {
+	result := make([]string, 0, len(c.seen))
+	for key := range c.seen {
+		result = append(result, key)
+	}
+	return result
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"os"
+	"time"
+)
+
+// DiskFullMsg is the info of the operations stopped because the download directory is running out of space
+const DiskFullMsg = "not enough disk space to generate the file"
+
+// diskWatchdog checks the free space of the download directory
+type diskWatchdog struct {
+	directory string
+	limits    utils.DiskLimits
+	// freeSpace returns the free space of a directory, replaced in the tests
+	freeSpace func(path string) (uint64, derrors.Error)
+}
+
+func newDiskWatchdog(directory string, limits utils.DiskLimits) *diskWatchdog {
+	return &diskWatchdog{
+		directory: directory,
+		limits:    limits,
+		freeSpace: utils.FreeSpace,
+	}
+}
+
+// check returns a ResourceExhausted error if the free space is below the low-water mark
+func (w *diskWatchdog) check() derrors.Error {
+	free, err := w.freeSpace(w.directory)
+	if err != nil {
+		return err
+	}
+	if free < w.limits.LowWater {
+		return derrors.NewResourceExhaustedError("not enough disk space to accept new download operations").
+			WithParams(free, w.limits.LowWater)
+	}
+	return nil
+}
+
+// operationBytes returns the bytes stored by an operation
+func (w *diskWatchdog) operationBytes(requestId string) int64 {
+	var size int64
+	paths := append([]string{utils.GetFilePath(w.directory, requestId)}, utils.GetSegmentPaths(w.directory, requestId)...)
+	paths = append(paths, utils.GetTargetFilePaths(w.directory, requestId)...)
+	paths = append(paths, utils.GetParquetFilePaths(w.directory, requestId)...)
+	for _, extension := range utils.ArchiveExtensions() {
+		paths = append(paths, utils.GetArchiveFilePath(w.directory, requestId, extension))
+	}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// largest returns the operation storing more bytes, an empty id if there are no operations
+func (w *diskWatchdog) largest(requestIds []string) (string, int64) {
+	selected := ""
+	var selectedSize int64 = -1
+	for _, requestId := range requestIds {
+		size := w.operationBytes(requestId)
+		if size > selectedSize {
+			selected, selectedSize = requestId, size
+		}
+	}
+	return selected, selectedSize
+}
+
+// watchDisk stops the largest running operation each time the free space is below the critical mark
+func (m *Manager) watchDisk() {
+	defer m.loops.Done()
+
+	ticker := time.NewTicker(m.disk.limits.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+			m.reviewDisk()
+		}
+	}
+}
+
+func (m *Manager) reviewDisk() {
+	free, err := m.disk.freeSpace(m.disk.directory)
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Msg("error checking the free space")
+		return
+	}
+	if free >= m.disk.limits.Critical {
+		return
+	}
+	requestId, size := m.disk.largest(m.running.ids())
+	if requestId == "" {
+		log.Warn().Uint64("free", free).Msg("download directory running out of space without running operations")
+		return
+	}
+	info := fmt.Sprintf("%s (%d bytes free, %d bytes used by the operation)", DiskFullMsg, free, size)
+	if m.interrupt(requestId, utils.Error, info) {
+		log.Warn().Str("requestId", requestId).Uint64("free", free).Int64("size", size).Msg("operation stopped, not enough disk space")
+	}
+}
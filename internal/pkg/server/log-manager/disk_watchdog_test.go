@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const watchdogTestDir = "./watchdogTestDir/"
+
+var _ = ginkgo.Describe("Disk watchdog", func() {
+
+	var watchdog *diskWatchdog
+	var free uint64
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(watchdogTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+		watchdog = newDiskWatchdog(watchdogTestDir, utils.DiskLimits{LowWater: 1000, Critical: 100, Interval: time.Second})
+		watchdog.freeSpace = func(path string) (uint64, derrors.Error) {
+			return free, nil
+		}
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(watchdogTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should refuse new operations below the low-water mark", func() {
+		free = 1000
+		gomega.Expect(watchdog.check()).To(gomega.Succeed())
+
+		free = 999
+		err := watchdog.check()
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.ResourceExhausted))
+	})
+
+	ginkgo.It("should find the largest operation", func() {
+		err := ioutil.WriteFile(utils.GetFilePath(watchdogTestDir, "small"), make([]byte, 10), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = ioutil.WriteFile(utils.GetFilePath(watchdogTestDir, "large"), make([]byte, 100), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		requestId, size := watchdog.largest([]string{"small", "large", "empty"})
+		gomega.Expect(requestId).Should(gomega.Equal("large"))
+		gomega.Expect(size).Should(gomega.Equal(int64(100)))
+
+		requestId, _ = watchdog.largest([]string{})
+		gomega.Expect(requestId).Should(gomega.BeEmpty())
+	})
+})
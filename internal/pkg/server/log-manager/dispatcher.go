@@ -0,0 +1,191 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// job is a log generation waiting for a worker
+type job struct {
+	ctx       context.Context
+	requestId string
+	userId    string
+	request   *grpc_log_download_manager_go.DownloadLogRequest
+	// checkpoint is the position where the generation starts
+	checkpoint utils.Checkpoint
+	// progress is the progress reached at the checkpoint
+	progress utils.GenerationProgress
+}
+
+// jobQueue is the policy used to decide which job is generated next
+type jobQueue interface {
+	// push adds a job
+	push(j *job)
+	// pop removes and returns the next job, nil if the queue is empty
+	pop() *job
+	// remove deletes a job, returns false if it is not queued
+	remove(requestId string) bool
+	// len returns the number of queued jobs
+	len() int
+	// order returns the request ids of the queued jobs in the order they will be popped
+	order() []string
+}
+
+// dispatcher keeps the queue of pending generations and a fixed number of workers processing them. The
+// exports are not queued, they take a worker while they run.
+type dispatcher struct {
+	sync.Mutex
+	queue    jobQueue
+	maxDepth int
+	workers  int
+	// busy is the number of workers generating a job or taken by an export
+	busy int
+	// available is signaled when a job is pushed, a worker is released or the dispatcher is stopped
+	available *sync.Cond
+	stopped   bool
+	generate  func(j *job)
+	// working waits for the workers once the dispatcher is stopped
+	working sync.WaitGroup
+}
+
+// newDispatcher creates a dispatcher and launches its workers
+func newDispatcher(queue jobQueue, workers int, maxDepth int, generate func(j *job)) *dispatcher {
+	d := &dispatcher{
+		queue:    queue,
+		maxDepth: maxDepth,
+		workers:  workers,
+		generate: generate,
+	}
+	d.available = sync.NewCond(&d.Mutex)
+	d.working.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work(i)
+	}
+	return d
+}
+
+// enqueue adds a job, failing if the queue is full
+func (d *dispatcher) enqueue(j *job) derrors.Error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.queue.len() >= d.maxDepth {
+		return derrors.NewResourceExhaustedError("too many queued download operations, try again later").WithParams(d.maxDepth)
+	}
+	d.queue.push(j)
+	d.available.Signal()
+	return nil
+}
+
+// requeue adds a job interrupted by a restart, the depth limit is not applied because it was already accepted
+func (d *dispatcher) requeue(j *job) {
+	d.Lock()
+	defer d.Unlock()
+
+	d.queue.push(j)
+	d.available.Signal()
+}
+
+// acquire takes a worker for an export, failing if all of them are busy or there are queued jobs
+func (d *dispatcher) acquire() derrors.Error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.busy >= d.workers || d.queue.len() > 0 {
+		return derrors.NewResourceExhaustedError("all the generation workers are busy, try again later").WithParams(d.workers)
+	}
+	d.busy++
+	return nil
+}
+
+// release frees a worker taken by a job or an export
+func (d *dispatcher) release() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.busy--
+	d.available.Signal()
+}
+
+// remove deletes a job that has not been picked by a worker
+func (d *dispatcher) remove(requestId string) bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.queue.remove(requestId)
+}
+
+// positions returns the position (starting at 1) of each queued job
+func (d *dispatcher) positions() map[string]int {
+	d.Lock()
+	defer d.Unlock()
+
+	result := make(map[string]int, 0)
+	for i, requestId := range d.queue.order() {
+		result[requestId] = i + 1
+	}
+	return result
+}
+
+// stop finishes the workers, the ones generating a log finish when the generation ends. The jobs
+// are kept in the queue.
+func (d *dispatcher) stop() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.stopped = true
+	d.available.Broadcast()
+}
+
+// wait blocks until the workers finish, a worker generating a job finishes when the generation ends
+func (d *dispatcher) wait() {
+	d.working.Wait()
+}
+
+// next blocks until there is a job to generate and a worker not taken by an export, it returns nil when
+// the dispatcher is stopped
+func (d *dispatcher) next() *job {
+	d.Lock()
+	defer d.Unlock()
+
+	for (d.queue.len() == 0 || d.busy >= d.workers) && !d.stopped {
+		d.available.Wait()
+	}
+	if d.stopped {
+		return nil
+	}
+	d.busy++
+	return d.queue.pop()
+}
+
+func (d *dispatcher) work(worker int) {
+	defer d.working.Done()
+	for {
+		j := d.next()
+		if j == nil {
+			return
+		}
+		log.Debug().Int("worker", worker).Str("requestId", j.requestId).Msg("generation started")
+		d.generate(j)
+		d.release()
+	}
+}
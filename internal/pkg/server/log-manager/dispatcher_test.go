@@ -0,0 +1,158 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"sync"
+	"time"
+)
+
+const dispatcherTestDir = "./dispatcherTestDir/"
+
+var _ = ginkgo.Describe("Dispatcher", func() {
+
+	newJob := func(requestId string) *job {
+		return &job{
+			requestId: requestId,
+			request:   &grpc_log_download_manager_go.DownloadLogRequest{OrganizationId: "org"},
+		}
+	}
+	newQueue := func() jobQueue {
+		return newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), false)
+	}
+
+	ginkgo.It("should generate the jobs with at most the given number of workers", func() {
+		var mutex sync.Mutex
+		generating, maxGenerating := 0, 0
+		generated := make(chan string, 10)
+		release := make(chan bool)
+		d := newDispatcher(newQueue(), 2, 10, func(j *job) {
+			mutex.Lock()
+			generating++
+			if generating > maxGenerating {
+				maxGenerating = generating
+			}
+			mutex.Unlock()
+			<-release
+			mutex.Lock()
+			generating--
+			mutex.Unlock()
+			generated <- j.requestId
+		})
+		defer d.stop()
+
+		for i := 0; i < 5; i++ {
+			gomega.Expect(d.enqueue(newJob(fmt.Sprintf("id%d", i)))).To(gomega.Succeed())
+		}
+		// two jobs are picked by the workers, the rest wait in the queue
+		gomega.Eventually(func() int { return len(d.positions()) }, 5*time.Second).Should(gomega.Equal(3))
+		gomega.Consistently(func() int { return len(d.positions()) }, 50*time.Millisecond).Should(gomega.Equal(3))
+
+		ids := make([]string, 0)
+		for i := 0; i < 5; i++ {
+			release <- true
+			var requestId string
+			gomega.Eventually(generated, 5*time.Second).Should(gomega.Receive(&requestId))
+			ids = append(ids, requestId)
+		}
+		gomega.Expect(ids).Should(gomega.ConsistOf("id0", "id1", "id2", "id3", "id4"))
+		gomega.Expect(maxGenerating).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should reject the jobs when the queue is full but accept the requeued ones", func() {
+		d := newDispatcher(newQueue(), 0, 2, func(j *job) {})
+		defer d.stop()
+
+		gomega.Expect(d.enqueue(newJob("id1"))).To(gomega.Succeed())
+		gomega.Expect(d.enqueue(newJob("id2"))).To(gomega.Succeed())
+		err := d.enqueue(newJob("id3"))
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.ResourceExhausted))
+
+		// the jobs interrupted by a restart were already accepted
+		d.requeue(newJob("resumed"))
+		gomega.Expect(d.positions()).Should(gomega.Equal(map[string]int{"id1": 1, "id2": 2, "resumed": 3}))
+
+		gomega.Expect(d.remove("id1")).Should(gomega.BeTrue())
+		gomega.Expect(d.remove("id1")).Should(gomega.BeFalse())
+		gomega.Expect(d.positions()).Should(gomega.Equal(map[string]int{"id2": 1, "resumed": 2}))
+		// the requeued jobs count for the new ones
+		gomega.Expect(d.enqueue(newJob("id3"))).NotTo(gomega.Succeed())
+		gomega.Expect(d.remove("resumed")).Should(gomega.BeTrue())
+		gomega.Expect(d.enqueue(newJob("id3"))).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should not generate the jobs while the workers are taken by exports", func() {
+		generated := make(chan string, 10)
+		d := newDispatcher(newQueue(), 1, 10, func(j *job) {
+			generated <- j.requestId
+		})
+		defer d.stop()
+
+		gomega.Expect(d.acquire()).To(gomega.Succeed())
+		gomega.Expect(d.enqueue(newJob("id1"))).To(gomega.Succeed())
+		gomega.Consistently(generated, 50*time.Millisecond).ShouldNot(gomega.Receive())
+		// the queued jobs go before the new exports
+		gomega.Expect(d.acquire()).NotTo(gomega.Succeed())
+
+		d.release()
+		gomega.Eventually(generated, 5*time.Second).Should(gomega.Receive(gomega.Equal("id1")))
+	})
+
+	ginkgo.It("should keep the queued jobs when it is stopped", func() {
+		generated := make(chan string, 10)
+		d := newDispatcher(newQueue(), 1, 10, func(j *job) {
+			generated <- j.requestId
+		})
+		d.stop()
+		gomega.Expect(d.enqueue(newJob("id1"))).To(gomega.Succeed())
+		gomega.Consistently(generated, 50*time.Millisecond).ShouldNot(gomega.Receive())
+		gomega.Expect(d.positions()).Should(gomega.HaveKey("id1"))
+	})
+
+	ginkgo.It("should stop the background loops of the manager", func() {
+		gomega.Expect(os.MkdirAll(dispatcherTestDir, os.ModePerm)).To(gomega.Succeed())
+		defer os.RemoveAll(dispatcherTestDir)
+		schedules, err := utils.NewFileScheduleStore(utils.GetSchedulesPath(dispatcherTestDir))
+		gomega.Expect(err).To(gomega.Succeed())
+
+		manager := NewManager(newFakeLoggingClient(2), utils.NewDownloadCache("/test/", "nalej.tech"), Options{
+			DownloadDirectory: dispatcherTestDir,
+			Workers:           2,
+			Shards:            1,
+			MaxQueueDepth:     10,
+			Weights:           utils.NewSchedulingWeights(utils.DefaultSchedulingWeight),
+			DiskLimits:        utils.DiskLimits{Interval: time.Millisecond},
+			RetryPolicy:       utils.RetryPolicy{MaxAttempts: 1},
+			Schedules:         schedules,
+		})
+		stopped := make(chan bool)
+		go func() {
+			manager.Stop()
+			manager.Stop()
+			stopped <- true
+		}()
+		gomega.Eventually(stopped, 5*time.Second).Should(gomega.Receive())
+	})
+})
@@ -0,0 +1,464 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/entities"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const downloadTestDir = "./downloadTestDir/"
+
+// fakeLoggingClient returns pages of at most pageSize entries of an in-memory log. The windows are
+// inclusive and, as the Applications manager does, the first entries are returned when NFirst is set
+// and the last ones otherwise. The shards of a generation may call it concurrently.
+type fakeLoggingClient struct {
+	sync.Mutex
+	entries  []*grpc_application_manager_go.LogEntryResponse
+	pageSize int
+	calls    int
+}
+
+func newFakeLoggingClient(pageSize int, timestamps ...int64) *fakeLoggingClient {
+	client := &fakeLoggingClient{pageSize: pageSize}
+	for i, timestamp := range timestamps {
+		client.entries = append(client.entries, &grpc_application_manager_go.LogEntryResponse{
+			Timestamp: timestamp,
+			Msg:       fmt.Sprintf("entry %d", i),
+		})
+	}
+	sort.SliceStable(client.entries, func(i, j int) bool {
+		return client.entries[i].Timestamp < client.entries[j].Timestamp
+	})
+	return client
+}
+
+func (c *fakeLoggingClient) Search(ctx context.Context, in *grpc_application_manager_go.SearchRequest, opts ...grpc.CallOption) (*grpc_application_manager_go.LogResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.calls++
+	window := make([]*grpc_application_manager_go.LogEntryResponse, 0)
+	for _, entry := range c.entries {
+		if in.AppInstanceId != "" && entry.AppInstanceId != in.AppInstanceId {
+			continue
+		}
+		if entry.Timestamp >= in.From && entry.Timestamp <= in.To {
+			window = append(window, entry)
+		}
+	}
+	if len(window) > c.pageSize {
+		if in.NFirst {
+			window = window[:c.pageSize]
+		} else {
+			window = window[len(window)-c.pageSize:]
+		}
+	}
+	response := &grpc_application_manager_go.LogResponse{Entries: window}
+	if len(window) > 0 {
+		response.From = window[0].Timestamp
+		response.To = window[len(window)-1].Timestamp
+	}
+	return response, nil
+}
+
+// messages returns the messages of the entries in the given order
+func (c *fakeLoggingClient) messages(order grpc_common_go.Order) []string {
+	result := make([]string, 0, len(c.entries))
+	for _, entry := range entities.Sort(append([]*grpc_application_manager_go.LogEntryResponse(nil), c.entries...), order) {
+		result = append(result, entry.Msg)
+	}
+	return result
+}
+
+var _ = ginkgo.Describe("Log generation", func() {
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(downloadTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(downloadTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	// generateLimited runs a generation of the given number of shards and limits with the fake client, and returns
+	// the messages of the zip file, the info of the operation and the number of files in the zip
+	generateLimited := func(client *fakeLoggingClient, order grpc_common_go.Order, shards int, limits utils.ExportLimits) ([]string, string, int) {
+		manager := Manager{
+			appManagerClient:  client,
+			opeCache:          utils.NewDownloadCache("/test/", "nalej.tech"),
+			DownloadDirectory: downloadTestDir,
+			running:           newRunningOperations(),
+			retryPolicy:       utils.RetryPolicy{MaxAttempts: 1},
+			limits:            limits,
+		}
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: order},
+		}
+		requestId := uuid.New().String()
+		_, err := manager.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, downloadTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(utils.InitializeFile(utils.GetFilePath(downloadTestDir, requestId), false)).To(gomega.Succeed())
+
+		manager.download(&job{
+			ctx:        manager.running.start(requestId),
+			requestId:  requestId,
+			request:    request,
+			checkpoint: utils.NewCheckpoint(request, request.From, request.To, shards, 0),
+		})
+
+		ope, err := manager.opeCache.Get(requestId)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(utils.Ready))
+		gomega.Expect(utils.GetSegmentPaths(downloadTestDir, requestId)).Should(gomega.BeEmpty())
+
+		reader, zErr := zip.OpenReader(utils.GetZipFilePath(downloadTestDir, requestId))
+		gomega.Expect(zErr).To(gomega.Succeed())
+		defer reader.Close()
+		gomega.Expect(reader.File).ShouldNot(gomega.BeEmpty())
+		file, zErr := reader.File[0].Open()
+		gomega.Expect(zErr).To(gomega.Succeed())
+		defer file.Close()
+		content, zErr := ioutil.ReadAll(file)
+		gomega.Expect(zErr).To(gomega.Succeed())
+		return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n"), ope.Info, len(reader.File)
+	}
+
+	// readArchive returns the names of the files of the zip file of an operation and their content
+	readArchive := func(requestId string) ([]string, map[string]string) {
+		reader, err := zip.OpenReader(utils.GetZipFilePath(downloadTestDir, requestId))
+		gomega.Expect(err).To(gomega.Succeed())
+		defer reader.Close()
+		names := make([]string, 0, len(reader.File))
+		contents := make(map[string]string, 0)
+		for _, zipped := range reader.File {
+			file, err := zipped.Open()
+			gomega.Expect(err).To(gomega.Succeed())
+			content, err := ioutil.ReadAll(file)
+			gomega.Expect(err).To(gomega.Succeed())
+			file.Close()
+			names = append(names, zipped.Name)
+			contents[zipped.Name] = string(content)
+		}
+		return names, contents
+	}
+
+	// download runs the generation of a request in the given number of shards with the fake client and
+	// returns the ready operation
+	download := func(client *fakeLoggingClient, request *grpc_log_download_manager_go.DownloadLogRequest, shards int) *utils.DownloadOperation {
+		manager := Manager{
+			appManagerClient:  client,
+			opeCache:          utils.NewDownloadCache("/test/", "nalej.tech"),
+			DownloadDirectory: downloadTestDir,
+			running:           newRunningOperations(),
+			retryPolicy:       utils.RetryPolicy{MaxAttempts: 1},
+		}
+		requestId := uuid.New().String()
+		_, err := manager.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, downloadTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		if request.Archive != grpc_log_download_manager_go.ArchiveFormat_ZIP {
+			err = manager.opeCache.SetArchive(requestId, utils.ArchiveExtension(request.Archive))
+			gomega.Expect(err).To(gomega.Succeed())
+		}
+		filePath := utils.GetFilePath(downloadTestDir, requestId)
+		gomega.Expect(utils.InitializeFormattedFile(filePath, utils.NewEntryFormat(request))).To(gomega.Succeed())
+
+		manager.download(&job{
+			ctx:        manager.running.start(requestId),
+			requestId:  requestId,
+			request:    request,
+			checkpoint: utils.NewCheckpoint(request, request.From, request.To, shards, fileSize(filePath)),
+		})
+
+		ope, err := manager.opeCache.Get(requestId)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(utils.Ready))
+		return ope
+	}
+
+	// generate runs a generation without limits and returns the messages of the zip file
+	generate := func(client *fakeLoggingClient, order grpc_common_go.Order, shards int) []string {
+		messages, _, files := generateLimited(client, order, shards, utils.ExportLimits{})
+		gomega.Expect(files).Should(gomega.Equal(1))
+		return messages
+	}
+
+	ginkgo.It("should not lose the entries in the gap between pages", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 4, 5, 6, 7)
+		gomega.Expect(generate(client, grpc_common_go.Order_ASC, 1)).Should(gomega.Equal(client.messages(grpc_common_go.Order_ASC)))
+	})
+
+	ginkgo.It("should not lose or duplicate the entries sharing the boundary timestamp", func() {
+		client := newFakeLoggingClient(3, 10, 20, 20, 20, 30, 30, 40, 40)
+		gomega.Expect(generate(client, grpc_common_go.Order_ASC, 1)).Should(gomega.Equal(client.messages(grpc_common_go.Order_ASC)))
+
+		client = newFakeLoggingClient(3, 10, 20, 20, 20, 30, 30, 40, 40)
+		// the order of the entries sharing a timestamp is not defined
+		gomega.Expect(generate(client, grpc_common_go.Order_DESC, 1)).Should(gomega.ConsistOf(client.messages(grpc_common_go.Order_DESC)))
+	})
+
+	ginkgo.It("should finish when a timestamp has more entries than a page", func() {
+		client := newFakeLoggingClient(2, 10, 10, 10, 20)
+		done := make(chan []string)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			done <- generate(client, grpc_common_go.Order_ASC, 1)
+		}()
+		var messages []string
+		gomega.Eventually(done, 5*time.Second).Should(gomega.Receive(&messages))
+		gomega.Expect(messages).Should(gomega.Equal(client.messages(grpc_common_go.Order_ASC)))
+
+		client = newFakeLoggingClient(2, 10, 20, 20, 20)
+		gomega.Expect(generate(client, grpc_common_go.Order_DESC, 1)).Should(gomega.ConsistOf(client.messages(grpc_common_go.Order_DESC)))
+	})
+
+	ginkgo.It("should truncate the operation when the entries of a timestamp cannot be retrieved", func() {
+		client := newFakeLoggingClient(2, 10, 10, 10, 10, 10, 20)
+		messages, info, _ := generateLimited(client, grpc_common_go.Order_ASC, 1, utils.ExportLimits{})
+		// the first and the last page of the timestamp do not overlap, the entry between them is missed
+		gomega.Expect(messages).Should(gomega.Equal([]string{"entry 0", "entry 1", "entry 3", "entry 4", "entry 5"}))
+		gomega.Expect(info).Should(gomega.ContainSubstring(TruncatedMsg))
+		gomega.Expect(info).Should(gomega.ContainSubstring("timestamp 10"))
+	})
+
+	ginkgo.It("should merge the shards in the requested order", func() {
+		client := newFakeLoggingClient(2, 5, 100, 200, 333, 334, 335, 500, 666, 667, 900, 1000)
+		gomega.Expect(generate(client, grpc_common_go.Order_ASC, 3)).Should(gomega.Equal(client.messages(grpc_common_go.Order_ASC)))
+		gomega.Expect(generate(client, grpc_common_go.Order_DESC, 3)).Should(gomega.Equal(client.messages(grpc_common_go.Order_DESC)))
+	})
+
+	ginkgo.It("should stop the generation when it reaches the limits", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 4, 5, 6, 7)
+		messages, info, files := generateLimited(client, grpc_common_go.Order_DESC, 1, utils.ExportLimits{MaxEntries: 3})
+		gomega.Expect(messages).Should(gomega.Equal(client.messages(grpc_common_go.Order_DESC)[:3]))
+		gomega.Expect(info).Should(gomega.ContainSubstring(TruncatedMsg))
+		gomega.Expect(files).Should(gomega.Equal(2))
+
+		// each message takes 8 bytes ("entry N\n")
+		messages, info, files = generateLimited(client, grpc_common_go.Order_ASC, 1, utils.ExportLimits{MaxBytes: 20})
+		gomega.Expect(messages).Should(gomega.Equal(client.messages(grpc_common_go.Order_ASC)[:2]))
+		gomega.Expect(files).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should not truncate a generation with the exact number of entries of the limit", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 4)
+		messages, info, files := generateLimited(client, grpc_common_go.Order_ASC, 1, utils.ExportLimits{MaxEntries: 4})
+		gomega.Expect(messages).Should(gomega.HaveLen(4))
+		gomega.Expect(info).ShouldNot(gomega.ContainSubstring(TruncatedMsg))
+		gomega.Expect(files).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should generate a file for each target", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 4, 5, 6, 7)
+		for i, entry := range client.entries {
+			entry.AppDescriptorName = "shop"
+			entry.AppInstanceId = fmt.Sprintf("instance-%d", i%2)
+			entry.AppInstanceName = fmt.Sprintf("shop-%d", i%2)
+		}
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			Targets: []*grpc_log_download_manager_go.LogTarget{
+				{AppInstanceId: "instance-1"}, {AppInstanceId: "instance-0"}, {AppInstanceId: "missing"},
+			},
+			From:  0,
+			To:    1000,
+			Order: &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+		}
+		ope := download(client, request, 2)
+		requestId := ope.RequestId
+		gomega.Expect(utils.GetTargetFilePaths(downloadTestDir, requestId)).Should(gomega.BeEmpty())
+		targets := ope.ToGRPC().TargetEntries
+		gomega.Expect(targets).Should(gomega.HaveLen(3))
+		gomega.Expect(targets[0].FileName).Should(gomega.Equal("shop_shop-1.log"))
+		gomega.Expect(targets[0].Entries).Should(gomega.Equal(int64(3)))
+		gomega.Expect(targets[1].FileName).Should(gomega.Equal("shop_shop-0.log"))
+		gomega.Expect(targets[1].Entries).Should(gomega.Equal(int64(4)))
+		gomega.Expect(targets[2].FileName).Should(gomega.Equal("missing.log"))
+		gomega.Expect(targets[2].Entries).Should(gomega.Equal(int64(0)))
+
+		_, contents := readArchive(requestId)
+		gomega.Expect(contents).Should(gomega.Equal(map[string]string{
+			"shop_shop-1.log": "entry 1\nentry 3\nentry 5\n",
+			"shop_shop-0.log": "entry 0\nentry 2\nentry 4\nentry 6\n",
+			"missing.log":     "",
+		}))
+	})
+
+	ginkgo.It("should split the file in numbered parts", func() {
+		client := newFakeLoggingClient(2, 5, 100, 200, 333, 334, 335, 500, 666, 667, 900, 1000)
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_DESC},
+			PartMaxEntries: 3,
+		}
+		ope := download(client, request, 2)
+		requestId := ope.RequestId
+		gomega.Expect(ope.Progress.Entries).Should(gomega.Equal(int64(11)))
+
+		names, contents := readArchive(requestId)
+		// the shards are [0, 499] with 6 entries and [500, 1000] with 5 entries
+		gomega.Expect(names).Should(gomega.Equal([]string{
+			requestId + ".part-00001.file", requestId + ".part-00002.file",
+			requestId + ".part-00003.file", requestId + ".part-00004.file",
+		}))
+		messages := make([]string, 0)
+		for _, name := range names {
+			lines := strings.Split(strings.TrimSuffix(contents[name], "\n"), "\n")
+			gomega.Expect(len(lines)).Should(gomega.BeNumerically("<=", 3))
+			messages = append(messages, lines...)
+		}
+		gomega.Expect(messages).Should(gomega.Equal(client.messages(grpc_common_go.Order_DESC)))
+	})
+
+	ginkgo.It("should write the entries as JSON Lines", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3)
+		for _, entry := range client.entries {
+			entry.AppDescriptorName = "shop"
+			entry.ServiceName = "cart"
+		}
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+			Format:         grpc_log_download_manager_go.OutputFormat_JSON_LINES,
+		}
+		ope := download(client, request, 2)
+
+		names, contents := readArchive(ope.RequestId)
+		gomega.Expect(names).Should(gomega.Equal([]string{ope.RequestId + ".jsonl"}))
+		lines := strings.Split(strings.TrimSuffix(contents[names[0]], "\n"), "\n")
+		gomega.Expect(lines).Should(gomega.HaveLen(3))
+		for i, line := range lines {
+			var entry map[string]string
+			gomega.Expect(json.Unmarshal([]byte(line), &entry)).To(gomega.Succeed())
+			gomega.Expect(entry["msg"]).Should(gomega.Equal(fmt.Sprintf("entry %d", i)))
+			gomega.Expect(entry["descriptor"]).Should(gomega.Equal("shop"))
+			gomega.Expect(entry["service"]).Should(gomega.Equal("cart"))
+		}
+	})
+
+	ginkgo.It("should write a header in each CSV file", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 600, 700)
+		client.entries[0].Msg = "with, comma"
+		client.entries[1].Msg = "with \"quotes\"\nand lines"
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+			Format:         grpc_log_download_manager_go.OutputFormat_CSV,
+			Columns:        []string{"msg"},
+		}
+		ope := download(client, request, 2)
+		names, contents := readArchive(ope.RequestId)
+		gomega.Expect(names).Should(gomega.Equal([]string{ope.RequestId + ".csv"}))
+		records, err := csv.NewReader(strings.NewReader(contents[names[0]])).ReadAll()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(records).Should(gomega.Equal([][]string{
+			{"msg"}, {"with, comma"}, {"with \"quotes\"\nand lines"}, {"entry 2"}, {"entry 3"}, {"entry 4"},
+		}))
+
+		// the shards are [0, 499] with 3 entries and [500, 1000] with 2 entries
+		request.PartMaxEntries = 2
+		ope = download(client, request, 2)
+		names, contents = readArchive(ope.RequestId)
+		gomega.Expect(names).Should(gomega.HaveLen(3))
+		gomega.Expect(names[2]).Should(gomega.Equal(ope.RequestId + ".part-00003.csv"))
+		for _, name := range names {
+			gomega.Expect(contents[name]).Should(gomega.HavePrefix("msg\n"))
+		}
+		gomega.Expect(contents[names[1]]).Should(gomega.Equal("msg\nentry 2\n"))
+	})
+	ginkgo.It("should create the archive in the requested format", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3)
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+			Archive:        grpc_log_download_manager_go.ArchiveFormat_TAR_GZ,
+		}
+		ope := download(client, request, 2)
+		gomega.Expect(ope.Url).Should(gomega.HaveSuffix(ope.RequestId + ".tar.gz"))
+		gomega.Expect(fileExists(utils.GetZipFilePath(downloadTestDir, ope.RequestId))).Should(gomega.BeFalse())
+
+		f, err := os.Open(ope.ArchivePath())
+		gomega.Expect(err).To(gomega.Succeed())
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		gomega.Expect(err).To(gomega.Succeed())
+		reader := tar.NewReader(gz)
+		header, err := reader.Next()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(header.Name).Should(gomega.Equal(ope.RequestId + ".file"))
+		content, err := ioutil.ReadAll(reader)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(string(content)).Should(gomega.Equal("entry 0\nentry 1\nentry 2\n"))
+	})
+	ginkgo.It("should write the entries in Parquet files", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 600, 700)
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+			Format:         grpc_log_download_manager_go.OutputFormat_PARQUET,
+		}
+		ope := download(client, request, 2)
+		names, contents := readArchive(ope.RequestId)
+		gomega.Expect(names).Should(gomega.Equal([]string{ope.RequestId + ".parquet"}))
+		gomega.Expect(contents[names[0]]).Should(gomega.HavePrefix("PAR1"))
+		gomega.Expect(contents[names[0]]).Should(gomega.HaveSuffix("PAR1"))
+		gomega.Expect(utils.GetParquetFilePaths(downloadTestDir, ope.RequestId)).Should(gomega.BeEmpty())
+
+		// the shards are [0, 499] with 3 entries and [500, 1000] with 2 entries
+		request.PartMaxEntries = 2
+		ope = download(client, request, 2)
+		names, contents = readArchive(ope.RequestId)
+		gomega.Expect(names).Should(gomega.Equal([]string{ope.RequestId + ".part-00001.parquet",
+			ope.RequestId + ".part-00002.parquet", ope.RequestId + ".part-00003.parquet"}))
+		for _, name := range names {
+			gomega.Expect(contents[name]).Should(gomega.HavePrefix("PAR1"))
+		}
+	})
+})
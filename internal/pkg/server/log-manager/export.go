@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/entities"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// ExportLog sends the log entries of a request in batches, one for each page retrieved, without storing them
+// in the download directory. The next page is not retrieved until the batch has been sent, so a slow consumer
+// (the stream blocks when its flow control window is full) slows down the searches instead of buffering entries.
+// The export stops when it reaches the export limits, the last batch contains the reason of the truncation.
+// The requests with several targets can only be downloaded.
+func (m *Manager) ExportLog(ctx context.Context, request *grpc_log_download_manager_go.DownloadLogRequest, userID string,
+	send func(batch *grpc_log_download_manager_go.ExportLogResponse) error) derrors.Error {
+
+	if len(request.Targets) > 0 {
+		return derrors.NewUnimplementedError("the export of several targets is not supported, download them instead")
+	}
+
+	// the exports are not queued, they run in a worker if one is available
+	if err := m.dispatcher.acquire(); err != nil {
+		return err
+	}
+	defer m.dispatcher.release()
+
+	// the exports are not stored, the id only identifies the export in the logs
+	requestId := uuid.New().String()
+	log.Debug().Str("requestId", requestId).Str("userId", userID).Interface("request", request).Msg("ExportLog request")
+
+	searchRequest := entities.NewSearchRequest(request)
+	ascending := request.Order.Order == grpc_common_go.Order_ASC
+	limits := m.limits.Restrict(request)
+	var truncated string
+	searchRequest.From, searchRequest.To, truncated = limits.Window(searchRequest.From, searchRequest.To, ascending)
+	boundary := searchRequest.From
+	if !ascending {
+		boundary = searchRequest.To
+	}
+	cursor := newPageCursor(ascending, boundary, nil)
+
+	var entries, bytes int64
+	var sendErr error
+	err := m.paginate(ctx, requestId, request.Order.Order, searchRequest, cursor, nil,
+		func(fresh []*grpc_application_manager_go.LogEntryResponse) error {
+			limited := ""
+			if limits.Bounded() {
+				lines := make([]int, 0, len(fresh))
+				for _, entry := range fresh {
+					lines = append(lines, len(utils.FormatResponse(entry, request.IncludeMetadata)))
+				}
+				var fits int
+				fits, limited = limits.Fit(entries, bytes, lines)
+				for _, size := range lines[:fits] {
+					bytes += int64(size)
+				}
+				fresh = fresh[:fits]
+				entries += int64(fits)
+			}
+			if len(fresh) == 0 && limited == "" {
+				return nil
+			}
+			batch := &grpc_log_download_manager_go.ExportLogResponse{
+				OrganizationId: request.OrganizationId,
+				Entries:        fresh,
+			}
+			if len(fresh) > 0 {
+				batch.From, batch.To = fresh[0].Timestamp, fresh[len(fresh)-1].Timestamp
+			}
+			if limited != "" {
+				truncated, batch.Truncated = limited, limited
+			}
+			if sendErr = send(batch); sendErr != nil {
+				return sendErr
+			}
+			if limited != "" {
+				return errLimitReached
+			}
+			return nil
+		})
+	if err == nil && truncated == "" {
+		truncated = cursor.incomplete
+	}
+	if err == nil && truncated != "" {
+		// the window was limited, the consumer is told with an empty batch
+		sendErr = send(&grpc_log_download_manager_go.ExportLogResponse{OrganizationId: request.OrganizationId, Truncated: truncated})
+		err = sendErr
+	}
+	if err == nil || err == errLimitReached {
+		log.Debug().Str("requestId", requestId).Str("truncated", truncated).Msg("export finished")
+		return nil
+	}
+	if sendErr != nil {
+		return derrors.NewUnavailableError("cannot send the log entries", sendErr)
+	}
+	if ctx.Err() != nil {
+		return derrors.NewCanceledError("export cancelled", err)
+	}
+	return derrors.NewInternalError("cannot retrieve the log entries", err)
+}
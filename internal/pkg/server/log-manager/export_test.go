@@ -0,0 +1,147 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Log export", func() {
+
+	var workers *dispatcher
+
+	ginkgo.BeforeEach(func() {
+		workers = newDispatcher(newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), false), 1, 10, func(j *job) {})
+	})
+	ginkgo.AfterEach(func() {
+		workers.stop()
+	})
+
+	export := func(client *fakeLoggingClient, order grpc_common_go.Order, send func(batch *grpc_log_download_manager_go.ExportLogResponse) error) derrors.Error {
+		manager := Manager{
+			appManagerClient: client,
+			retryPolicy:      utils.RetryPolicy{MaxAttempts: 1},
+			dispatcher:       workers,
+		}
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           0,
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: order},
+		}
+		return manager.ExportLog(context.Background(), request, "user", send)
+	}
+
+	ginkgo.It("should send all the entries in order in batches", func() {
+		for _, order := range []grpc_common_go.Order{grpc_common_go.Order_ASC, grpc_common_go.Order_DESC} {
+			client := newFakeLoggingClient(2, 1, 2, 3, 4, 5, 6, 7)
+			batches := 0
+			messages := make([]string, 0)
+			err := export(client, order, func(batch *grpc_log_download_manager_go.ExportLogResponse) error {
+				batches++
+				gomega.Expect(len(batch.Entries)).Should(gomega.BeNumerically("<=", 2))
+				for _, entry := range batch.Entries {
+					messages = append(messages, entry.Msg)
+				}
+				return nil
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(batches).Should(gomega.BeNumerically(">", 1))
+			gomega.Expect(messages).Should(gomega.Equal(client.messages(order)))
+		}
+	})
+
+	ginkgo.It("should stop searching when a batch cannot be sent", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 4, 5, 6, 7)
+		err := export(client, grpc_common_go.Order_ASC, func(batch *grpc_log_download_manager_go.ExportLogResponse) error {
+			return fmt.Errorf("stream closed")
+		})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.Unavailable))
+		gomega.Expect(client.calls).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should report the truncation in the last batch", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3, 4, 5, 6, 7)
+		manager := Manager{
+			appManagerClient: client,
+			retryPolicy:      utils.RetryPolicy{MaxAttempts: 1},
+			limits:           utils.ExportLimits{MaxEntries: 3},
+			dispatcher:       workers,
+		}
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			To:             1000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+		}
+		batches := make([]*grpc_log_download_manager_go.ExportLogResponse, 0)
+		err := manager.ExportLog(context.Background(), request, "user", func(batch *grpc_log_download_manager_go.ExportLogResponse) error {
+			batches = append(batches, batch)
+			return nil
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		entries := 0
+		for _, batch := range batches {
+			entries += len(batch.Entries)
+		}
+		gomega.Expect(entries).Should(gomega.Equal(3))
+		gomega.Expect(batches[len(batches)-1].Truncated).ShouldNot(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should report the entries of a timestamp that cannot be retrieved", func() {
+		client := newFakeLoggingClient(2, 10, 10, 10, 10, 10, 20)
+		var last *grpc_log_download_manager_go.ExportLogResponse
+		entries := 0
+		err := export(client, grpc_common_go.Order_ASC, func(batch *grpc_log_download_manager_go.ExportLogResponse) error {
+			entries += len(batch.Entries)
+			last = batch
+			return nil
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(entries).Should(gomega.Equal(5))
+		gomega.Expect(last.Entries).Should(gomega.BeEmpty())
+		gomega.Expect(last.Truncated).Should(gomega.ContainSubstring("timestamp 10"))
+	})
+
+	ginkgo.It("should take a worker while it runs", func() {
+		client := newFakeLoggingClient(2, 1, 2, 3)
+		err := export(client, grpc_common_go.Order_ASC, func(batch *grpc_log_download_manager_go.ExportLogResponse) error {
+			// the only worker is taken by this export
+			gomega.Expect(workers.acquire()).NotTo(gomega.Succeed())
+			return nil
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(workers.acquire()).To(gomega.Succeed())
+
+		// all the workers are busy
+		calls := client.calls
+		err = export(client, grpc_common_go.Order_ASC, func(batch *grpc_log_download_manager_go.ExportLogResponse) error {
+			return nil
+		})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		gomega.Expect(err.Type()).Should(gomega.Equal(derrors.ResourceExhausted))
+		gomega.Expect(client.calls).Should(gomega.Equal(calls))
+		workers.release()
+	})
+})
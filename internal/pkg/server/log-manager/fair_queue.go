@@ -0,0 +1,209 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+)
+
+// interactiveWeight multiplies the weight of an organization for its interactive jobs, so they cost
+// less of its share of the workers but cannot take the share of the other organizations
+const interactiveWeight = 2
+
+// userJobs are the jobs of a user waiting, the ones with higher priority first and then in arrival order
+type userJobs struct {
+	userId string
+	jobs   []*job
+}
+
+// add inserts a job after the jobs with the same or higher priority
+func (u *userJobs) add(j *job) {
+	index := len(u.jobs)
+	for index > 0 && u.jobs[index-1].request.Priority < j.request.Priority {
+		index--
+	}
+	u.jobs = append(u.jobs, nil)
+	copy(u.jobs[index+1:], u.jobs[index:])
+	u.jobs[index] = j
+}
+
+// organizationFlow are the jobs of an organization waiting. The users are served in round robin
+// when the queue is fair by user, otherwise all the jobs are kept in a single list.
+type organizationFlow struct {
+	organizationId string
+	// pass is the virtual time of the next generation of the organization, it advances 1/weight
+	// each time a job of the organization is popped
+	pass  float64
+	users []*userJobs
+	next  int
+}
+
+// fairQueue shares the workers between the organizations according to their weights (stride scheduling)
+// whatever the priority of their jobs. The priority orders the jobs of an organization (or a user) and
+// counts as a weight, so an interactive job is generated before the bulk ones of the same organization
+// without starving the other organizations.
+type fairQueue struct {
+	weights *utils.SchedulingWeights
+	byUser  bool
+	flows   map[string]*organizationFlow
+	// virtualTime is the pass of the last flow served, new flows start from it so an organization
+	// cannot accumulate credit while it has nothing queued
+	virtualTime float64
+	size        int
+}
+
+func newFairQueue(weights *utils.SchedulingWeights, byUser bool) *fairQueue {
+	return &fairQueue{
+		weights: weights,
+		byUser:  byUser,
+		flows:   make(map[string]*organizationFlow, 0),
+	}
+}
+
+// userKey returns the key used to group the jobs inside an organization
+func (q *fairQueue) userKey(j *job) string {
+	if q.byUser {
+		return j.userId
+	}
+	return ""
+}
+
+// weight returns the weight of a job of an organization
+func (q *fairQueue) weight(organizationId string, priority grpc_log_download_manager_go.DownloadPriority) float64 {
+	weight := float64(q.weights.Get(organizationId))
+	if priority == grpc_log_download_manager_go.DownloadPriority_INTERACTIVE {
+		weight *= interactiveWeight
+	}
+	return weight
+}
+
+func (q *fairQueue) push(j *job) {
+	flow, exists := q.flows[j.request.OrganizationId]
+	if !exists {
+		flow = &organizationFlow{organizationId: j.request.OrganizationId, pass: q.virtualTime}
+		q.flows[j.request.OrganizationId] = flow
+	}
+	key := q.userKey(j)
+	var user *userJobs
+	for _, u := range flow.users {
+		if u.userId == key {
+			user = u
+			break
+		}
+	}
+	if user == nil {
+		user = &userJobs{userId: key}
+		flow.users = append(flow.users, user)
+	}
+	user.add(j)
+	q.size++
+}
+
+func (q *fairQueue) pop() *job {
+	var selected *organizationFlow
+	for _, flow := range q.flows {
+		if selected == nil || flow.pass < selected.pass ||
+			(flow.pass == selected.pass && flow.organizationId < selected.organizationId) {
+			selected = flow
+		}
+	}
+	if selected == nil {
+		return nil
+	}
+
+	user := selected.users[selected.next]
+	j := user.jobs[0]
+	user.jobs[0] = nil
+	user.jobs = user.jobs[1:]
+	if len(user.jobs) == 0 {
+		selected.users = append(selected.users[:selected.next], selected.users[selected.next+1:]...)
+	} else {
+		selected.next++
+	}
+	if selected.next >= len(selected.users) {
+		selected.next = 0
+	}
+
+	q.virtualTime = selected.pass
+	selected.pass += 1 / q.weight(selected.organizationId, j.request.Priority)
+	q.cleanFlow(selected)
+	q.size--
+	return j
+}
+
+// cleanFlow deletes the flow when it has no jobs
+func (q *fairQueue) cleanFlow(flow *organizationFlow) {
+	if len(flow.users) > 0 {
+		return
+	}
+	delete(q.flows, flow.organizationId)
+}
+
+func (q *fairQueue) remove(requestId string) bool {
+	for _, flow := range q.flows {
+		for ui, user := range flow.users {
+			for ji, j := range user.jobs {
+				if j.requestId != requestId {
+					continue
+				}
+				user.jobs = append(user.jobs[:ji], user.jobs[ji+1:]...)
+				if len(user.jobs) == 0 {
+					flow.users = append(flow.users[:ui], flow.users[ui+1:]...)
+					if ui < flow.next {
+						flow.next--
+					}
+					if flow.next >= len(flow.users) {
+						flow.next = 0
+					}
+				}
+				q.cleanFlow(flow)
+				q.size--
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (q *fairQueue) len() int {
+	return q.size
+}
+
+// clone returns a copy of the queue that can be popped without modifying this one
+func (q *fairQueue) clone() *fairQueue {
+	result := newFairQueue(q.weights, q.byUser)
+	result.size = q.size
+	result.virtualTime = q.virtualTime
+	for organizationId, flow := range q.flows {
+		flowCopy := &organizationFlow{organizationId: flow.organizationId, pass: flow.pass, next: flow.next}
+		for _, user := range flow.users {
+			flowCopy.users = append(flowCopy.users, &userJobs{userId: user.userId, jobs: append([]*job(nil), user.jobs...)})
+		}
+		result.flows[organizationId] = flowCopy
+	}
+	return result
+}
+
+func (q *fairQueue) order() []string {
+	simulation := q.clone()
+	result := make([]string, 0, q.size)
+	for j := simulation.pop(); j != nil; j = simulation.pop() {
+		result = append(result, j.requestId)
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Fair queue", func() {
+
+	newJob := func(requestId string, organizationId string, userId string, priority grpc_log_download_manager_go.DownloadPriority) *job {
+		return &job{
+			requestId: requestId,
+			userId:    userId,
+			request: &grpc_log_download_manager_go.DownloadLogRequest{
+				OrganizationId: organizationId,
+				Priority:       priority,
+			},
+		}
+	}
+	normal := grpc_log_download_manager_go.DownloadPriority_NORMAL
+	interactive := grpc_log_download_manager_go.DownloadPriority_INTERACTIVE
+
+	ginkgo.It("should alternate between organizations", func() {
+		queue := newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), false)
+		queue.push(newJob("a1", "orgA", "", normal))
+		queue.push(newJob("a2", "orgA", "", normal))
+		queue.push(newJob("a3", "orgA", "", normal))
+		queue.push(newJob("b1", "orgB", "", normal))
+
+		gomega.Expect(queue.order()).Should(gomega.Equal([]string{"a1", "b1", "a2", "a3"}))
+		gomega.Expect(queue.len()).Should(gomega.Equal(4))
+		gomega.Expect(queue.pop().requestId).Should(gomega.Equal("a1"))
+		gomega.Expect(queue.pop().requestId).Should(gomega.Equal("b1"))
+		gomega.Expect(queue.pop().requestId).Should(gomega.Equal("a2"))
+		gomega.Expect(queue.pop().requestId).Should(gomega.Equal("a3"))
+		gomega.Expect(queue.pop()).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should share the workers according to the weights", func() {
+		weights := utils.NewSchedulingWeights(utils.DefaultSchedulingWeight)
+		weights.Organizations["orgA"] = 2
+		queue := newFairQueue(weights, false)
+		for _, id := range []string{"a1", "a2", "a3", "a4"} {
+			queue.push(newJob(id, "orgA", "", normal))
+		}
+		queue.push(newJob("b1", "orgB", "", normal))
+		queue.push(newJob("b2", "orgB", "", normal))
+
+		gomega.Expect(queue.order()).Should(gomega.Equal([]string{"a1", "b1", "a2", "a3", "b2", "a4"}))
+	})
+
+	ginkgo.It("should generate first the jobs with higher priority of an organization", func() {
+		queue := newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), false)
+		queue.push(newJob("bulk1", "orgA", "", normal))
+		queue.push(newJob("bulk2", "orgA", "", normal))
+		queue.push(newJob("interactive1", "orgA", "", interactive))
+		queue.push(newJob("interactive2", "orgA", "", interactive))
+
+		gomega.Expect(queue.order()).Should(gomega.Equal([]string{"interactive1", "interactive2", "bulk1", "bulk2"}))
+	})
+
+	ginkgo.It("should not let the priority starve the other organizations", func() {
+		queue := newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), false)
+		for _, id := range []string{"a1", "a2", "a3", "a4", "a5", "a6"} {
+			queue.push(newJob(id, "orgA", "", interactive))
+		}
+		queue.push(newJob("b1", "orgB", "", normal))
+		queue.push(newJob("b2", "orgB", "", normal))
+
+		// the interactive jobs count twice the weight of the organization
+		gomega.Expect(queue.order()).Should(gomega.Equal([]string{"a1", "b1", "a2", "a3", "b2", "a4", "a5", "a6"}))
+	})
+
+	ginkgo.It("should alternate between the users of an organization", func() {
+		queue := newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), true)
+		queue.push(newJob("u1-1", "orgA", "user1", normal))
+		queue.push(newJob("u1-2", "orgA", "user1", normal))
+		queue.push(newJob("u2-1", "orgA", "user2", normal))
+
+		gomega.Expect(queue.order()).Should(gomega.Equal([]string{"u1-1", "u2-1", "u1-2"}))
+	})
+
+	ginkgo.It("should remove a queued job", func() {
+		queue := newFairQueue(utils.NewSchedulingWeights(utils.DefaultSchedulingWeight), true)
+		queue.push(newJob("u1-1", "orgA", "user1", normal))
+		queue.push(newJob("u2-1", "orgA", "user2", normal))
+		queue.push(newJob("b1", "orgB", "user3", normal))
+
+		gomega.Expect(queue.remove("u1-1")).Should(gomega.BeTrue())
+		gomega.Expect(queue.remove("u1-1")).Should(gomega.BeFalse())
+		gomega.Expect(queue.len()).Should(gomega.Equal(2))
+		gomega.Expect(queue.order()).Should(gomega.Equal([]string{"u2-1", "b1"}))
+	})
+})
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"context"
+	"errors"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/entities"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"os"
+	"sync"
+)
+
+// generation is the state of an operation being generated, shared by the goroutines fetching its shards
+type generation struct {
+	sync.Mutex
+	requestId  string
+	request    *grpc_log_download_manager_go.DownloadLogRequest
+	ascending  bool
+	checkpoint utils.Checkpoint
+	tracker    *progressTracker
+	limits     utils.ExportLimits
+	format     utils.EntryFormat
+	// err is the first error of a shard
+	err error
+}
+
+// errLimitReached stops the pagination of a shard when the generation reaches its export limits
+var errLimitReached = errors.New("export limit reached")
+
+func newGeneration(j *job, limits utils.ExportLimits) *generation {
+	ascending := j.request.Order.Order == grpc_common_go.Order_ASC
+	checkpoint := j.checkpoint
+	// the shards are updated by the generation, the job keeps the original ones
+	checkpoint.Shards = append([]utils.ShardCheckpoint(nil), j.checkpoint.Shards...)
+	return &generation{
+		requestId:  j.requestId,
+		request:    j.request,
+		ascending:  ascending,
+		checkpoint: checkpoint,
+		tracker:    newProgressTracker(checkpoint, ascending, j.progress),
+		limits:     limits,
+		format:     utils.NewEntryFormat(j.request),
+	}
+}
+
+// shard returns the checkpoint of a shard
+func (g *generation) shard(index int) utils.ShardCheckpoint {
+	g.Lock()
+	defer g.Unlock()
+	return g.checkpoint.Shards[index]
+}
+
+// fail records the error of a shard, only the first one is kept
+func (g *generation) fail(err error) {
+	g.Lock()
+	defer g.Unlock()
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// fit returns the entries of a page that can be written without exceeding the limits of the generation and,
+// when some of them do not fit, the reason of the truncation
+func (g *generation) fit(entries []*grpc_application_manager_go.LogEntryResponse) ([]*grpc_application_manager_go.LogEntryResponse, string) {
+	if !g.limits.Bounded() {
+		return entries, ""
+	}
+	lines := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, g.format.Size(entry))
+	}
+	g.Lock()
+	defer g.Unlock()
+	fits, reason := g.limits.Fit(g.tracker.progress.Entries, g.tracker.progress.Bytes, lines)
+	if reason != "" {
+		g.checkpoint.Truncated = reason
+	}
+	return entries[:fits], reason
+}
+
+// incomplete records that some entries of the window could not be retrieved, the operation is truncated
+// unless it already is
+func (g *generation) incomplete(reason string) {
+	g.Lock()
+	defer g.Unlock()
+	if g.checkpoint.Truncated == "" {
+		g.checkpoint.Truncated = reason
+	}
+}
+
+// nameTarget names the target of a shard after one of its entries, if the request has targets
+func (g *generation) nameTarget(target int, entry *grpc_application_manager_go.LogEntryResponse) {
+	if target >= len(g.request.Targets) {
+		return
+	}
+	g.Lock()
+	defer g.Unlock()
+	g.tracker.name(target, utils.TargetName(g.request.Targets[target], entry))
+}
+
+// segments returns the segment files (and their parts) of a target in the order of the generation
+func (g *generation) segments(directory string, target int) []string {
+	shards := make([]int, 0, len(g.checkpoint.Shards))
+	for i, shard := range g.checkpoint.Shards {
+		if shard.Target == target {
+			shards = append(shards, i)
+		}
+	}
+	if !g.ascending {
+		for i, j := 0, len(shards)-1; i < j; i, j = i+1, j-1 {
+			shards[i], shards[j] = shards[j], shards[i]
+		}
+	}
+	result := make([]string, 0, len(shards))
+	for _, index := range shards {
+		// each shard writes its parts in the order of the generation
+		for part := 0; part <= g.checkpoint.Shards[index].Part; part++ {
+			result = append(result, utils.GetSegmentPartPath(directory, g.requestId, index, part))
+		}
+	}
+	return result
+}
+
+// retried counts a retried Search call of the generation
+func (m *Manager) retried(g *generation) {
+	g.Lock()
+	defer g.Unlock()
+	g.tracker.retry()
+	m.reportProgress(g.requestId, g.tracker.progress)
+}
+
+// checkpointShard stores the new checkpoint of a shard after writing a page
+func (m *Manager) checkpointShard(g *generation, index int, shard utils.ShardCheckpoint, entries int, position int64) {
+	g.Lock()
+	defer g.Unlock()
+	g.checkpoint.Shards[index] = shard
+	if shard.Done {
+		g.tracker.finishShard(index)
+	} else {
+		g.tracker.page(index, entries, position, shard.PartsBytes+shard.Bytes)
+	}
+	m.saveCheckpoint(g.requestId, g.checkpoint, g.tracker.progress)
+}
+
+// fetchShards retrieves the entries of all the shards concurrently. A failed shard stops the others.
+// The shards of a generation with limits are fetched one after the other, so the entries that fit
+// are always the first ones of the first targets.
+func (m *Manager) fetchShards(ctx context.Context, g *generation) error {
+	if g.limits.Bounded() {
+		for i := range g.checkpoint.Shards {
+			if err := m.fetchShard(ctx, g, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	shardsCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range g.checkpoint.Shards {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			if err := m.fetchShard(shardsCtx, g, index); err != nil {
+				g.fail(err)
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+	return g.err
+}
+
+// fetchShard writes the entries of a shard in its segment file, page by page
+func (m *Manager) fetchShard(ctx context.Context, g *generation, index int) error {
+	shard := g.shard(index)
+	if shard.Done {
+		return nil
+	}
+	partPath := func(part int) string {
+		return utils.GetSegmentPartPath(m.DownloadDirectory, g.requestId, index, part)
+	}
+	partLimits := utils.NewPartLimits(g.request)
+	if fileSize(partPath(shard.Part)) == 0 {
+		// the parts are archived as they are, so they start with the header of the format
+		format := utils.EntryFormat{}
+		if partLimits.Enabled() {
+			format = g.format
+		}
+		if err := utils.InitializeFormattedFile(partPath(shard.Part), format); err != nil {
+			return err
+		}
+	}
+
+	searchRequest := entities.NewTargetSearchRequest(g.request, shard.Target)
+	searchRequest.From, searchRequest.To = shard.From, shard.To
+	boundary := shard.From
+	if !g.ascending {
+		boundary = shard.To
+	}
+	cursor := newPageCursor(g.ascending, boundary, shard.Seen)
+
+	retried := func() {
+		m.retried(g)
+	}
+	err := m.paginate(ctx, g.requestId, g.request.Order.Order, searchRequest, cursor, retried,
+		func(fresh []*grpc_application_manager_go.LogEntryResponse) error {
+			fresh, truncated := g.fit(fresh)
+			if cursor.incomplete != "" {
+				g.incomplete(cursor.incomplete)
+			}
+			// Copy the log entries not written yet in the segment, rolling its parts
+			if len(fresh) > 0 {
+				g.nameTarget(shard.Target, fresh[0])
+				position := utils.PartPosition{Part: shard.Part, Entries: shard.PartEntries, Bytes: shard.Bytes, PreviousBytes: shard.PartsBytes}
+				position, err := utils.AppendPartResponses(fresh, partPath, position, partLimits, g.format)
+				if err != nil {
+					return err
+				}
+				shard.Part, shard.PartEntries, shard.PartsBytes = position.Part, position.Entries, position.PreviousBytes
+			}
+			shard.From, shard.To, shard.Bytes = searchRequest.From, searchRequest.To, fileSize(partPath(shard.Part))
+			shard.Seen = cursor.keys()
+			m.checkpointShard(g, index, shard, len(fresh), cursor.boundary)
+			if truncated != "" {
+				return errLimitReached
+			}
+			return nil
+		})
+	if err != nil && err != errLimitReached {
+		return err
+	}
+	shard.Done = true
+	m.checkpointShard(g, index, shard, 0, cursor.boundary)
+	return nil
+}
+
+// fileExists checks if a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -18,6 +18,7 @@ package log_manager
 
 import (
 	"context"
+	"github.com/nalej/grpc-common-go"
 	"github.com/nalej/grpc-log-download-manager-go"
 	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
@@ -27,11 +28,11 @@ import (
 
 // Handler structure for the user requests.
 type Handler struct {
-	Manager Manager
+	Manager *Manager
 }
 
 // NewHandler creates a new Handler with a linked manager.
-func NewHandler(manager Manager) *Handler {
+func NewHandler(manager *Manager) *Handler {
 	return &Handler{manager}
 }
 
@@ -42,6 +43,10 @@ func (h *Handler) DownloadLog(ctx context.Context, request *grpc_log_download_ma
 	if vErr != nil {
 		return nil, conversions.ToDerror(vErr)
 	}
+	vErr = entities.ValidDownloadLogWindow(request, h.Manager.limits.MaxWindow)
+	if vErr != nil {
+		return nil, conversions.ToDerror(vErr)
+	}
 
 	response, err := h.Manager.DownloadLog(request, utils.GetUserFromContext(ctx))
 	if err != nil {
@@ -77,3 +82,119 @@ func (h *Handler) List(ctx context.Context, organizationID *grpc_organization_go
 	}
 	return response, nil
 }
+
+// Cancel stops the generation of a download operation
+func (h *Handler) Cancel(ctx context.Context, request *grpc_log_download_manager_go.DownloadRequestId) (*grpc_log_download_manager_go.DownloadLogResponse, error) {
+
+	vErr := entities.ValidDownloadRequestId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.Cancel(request, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// GetQuotaUsage returns the usage and the quota of the organization and the user
+func (h *Handler) GetQuotaUsage(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*grpc_log_download_manager_go.QuotaUsageResponse, error) {
+
+	vErr := entities.ValidOrganizationId(organizationID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.GetQuotaUsage(organizationID, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// ExportLog streams the log entries of a request without generating a file
+func (h *Handler) ExportLog(request *grpc_log_download_manager_go.DownloadLogRequest, stream grpc_log_download_manager_go.LogDownloadManager_ExportLogServer) error {
+
+	vErr := entities.ValidDownloadLogRequest(request)
+	if vErr != nil {
+		return conversions.ToGRPCError(vErr)
+	}
+	vErr = entities.ValidDownloadLogWindow(request, h.Manager.limits.MaxWindow)
+	if vErr != nil {
+		return conversions.ToGRPCError(vErr)
+	}
+	err := h.Manager.ExportLog(stream.Context(), request, utils.GetUserFromContext(stream.Context()), stream.Send)
+	if err != nil {
+		return conversions.ToGRPCError(err)
+	}
+	return nil
+}
+
+// CreateSchedule adds a recurring export owned by the user
+func (h *Handler) CreateSchedule(ctx context.Context, request *grpc_log_download_manager_go.CreateScheduleRequest) (*grpc_log_download_manager_go.Schedule, error) {
+
+	vErr := entities.ValidCreateScheduleRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.CreateSchedule(request, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// ListSchedules returns the schedules of an organization
+func (h *Handler) ListSchedules(ctx context.Context, request *grpc_organization_go.OrganizationId) (*grpc_log_download_manager_go.ScheduleList, error) {
+
+	vErr := entities.ValidOrganizationId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.ListSchedules(request, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// PauseSchedule stops the runs of a schedule
+func (h *Handler) PauseSchedule(ctx context.Context, request *grpc_log_download_manager_go.ScheduleId) (*grpc_log_download_manager_go.Schedule, error) {
+
+	vErr := entities.ValidScheduleId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.PauseSchedule(request, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// ResumeSchedule restarts the runs of a paused schedule
+func (h *Handler) ResumeSchedule(ctx context.Context, request *grpc_log_download_manager_go.ScheduleId) (*grpc_log_download_manager_go.Schedule, error) {
+
+	vErr := entities.ValidScheduleId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.ResumeSchedule(request, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// DeleteSchedule removes a schedule
+func (h *Handler) DeleteSchedule(ctx context.Context, request *grpc_log_download_manager_go.ScheduleId) (*grpc_common_go.Success, error) {
+
+	vErr := entities.ValidScheduleId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	response, err := h.Manager.DeleteSchedule(request, utils.GetUserFromContext(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
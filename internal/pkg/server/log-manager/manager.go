@@ -17,6 +17,8 @@
 package log_manager
 
 import (
+	"context"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-manager-go"
@@ -27,85 +29,406 @@ import (
 	"github.com/nalej/log-download-manager/internal/pkg/entities"
 	"github.com/nalej/log-download-manager/internal/pkg/utils"
 	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 )
 
+// CancelledMsg is the info of the operations cancelled by the user
+const CancelledMsg = "cancelled by the user"
+
+// TruncatedMsg is the info of the operations that do not contain all the entries because of the export limits
+const TruncatedMsg = "truncated"
+
 // Manager structure with the required clients for roles operations.
 type Manager struct {
 	appManagerClient  grpc_application_manager_go.UnifiedLoggingClient
-	opeCache          *utils.DownloadCache
+	opeCache          utils.OperationStore
+	DownloadDirectory string
+	running           *runningOperations
+	dispatcher        *dispatcher
+	quotas            *quotas
+	disk              *diskWatchdog
+	retryPolicy       utils.RetryPolicy
+	// shards is the number of parts of the window fetched in parallel
+	shards int
+	limits utils.ExportLimits
+	// reuseGrace is the time the entries of a window may take to be ingested after its end
+	reuseGrace time.Duration
+	schedules  *scheduler
+	// stop finishes the background loops, loops waits for them
+	stop  chan struct{}
+	loops sync.WaitGroup
+}
+
+// Options with the configuration of a Manager
+type Options struct {
+	// DownloadDirectory where the files of the operations are generated
 	DownloadDirectory string
+	// Workers generating the logs, each one fetching the window in Shards parts in parallel
+	Workers int
+	Shards  int
+	// MaxQueueDepth with the maximum number of operations waiting for a worker
+	MaxQueueDepth int
+	// Weights share the workers between the organizations and, if FairByUser is set, between their users
+	Weights    *utils.SchedulingWeights
+	FairByUser bool
+	// OrganizationQuota and UserQuota limit the operations of each organization and user
+	OrganizationQuota utils.Quota
+	UserQuota         utils.Quota
+	// DiskLimits with the free space required in the download directory
+	DiskLimits utils.DiskLimits
+	// RetryPolicy of the Search calls
+	RetryPolicy utils.RetryPolicy
+	// Limits where the generations stop
+	Limits utils.ExportLimits
+	// ReuseGrace is the time after the end of a window until all its entries are ingested, the artifacts
+	// generated before it are not reused
+	ReuseGrace time.Duration
+	// Schedules with the scheduled exports
+	Schedules utils.ScheduleStore
 }
 
-// NewManager creates a Manager using a set of clients.
-func NewManager(appManagerClient grpc_application_manager_go.UnifiedLoggingClient, opeCache *utils.DownloadCache, downloadDirectory string) Manager {
-	return Manager{
+// NewManager creates a Manager and launches its workers, the disk watchdog and the scheduled exports.
+func NewManager(appManagerClient grpc_application_manager_go.UnifiedLoggingClient, opeCache utils.OperationStore, options Options) *Manager {
+	res := &Manager{
 		appManagerClient:  appManagerClient,
 		opeCache:          opeCache,
-		DownloadDirectory: downloadDirectory,
+		DownloadDirectory: options.DownloadDirectory,
+		running:           newRunningOperations(),
+		quotas:            &quotas{organization: options.OrganizationQuota, user: options.UserQuota},
+		disk:              newDiskWatchdog(options.DownloadDirectory, options.DiskLimits),
+		retryPolicy:       options.RetryPolicy,
+		shards:            options.Shards,
+		limits:            options.Limits,
+		reuseGrace:        options.ReuseGrace,
+		schedules:         newScheduler(options.Schedules),
+		stop:              make(chan struct{}),
+	}
+	res.dispatcher = newDispatcher(newFairQueue(options.Weights, options.FairByUser), options.Workers, options.MaxQueueDepth, res.download)
+	res.loops.Add(2)
+	go res.watchDisk()
+	go res.runSchedules()
+	return res
+}
+
+// Stop finishes the disk watchdog, the scheduled exports and the workers, waiting for them. The generations
+// in progress are interrupted, they are resumed from their checkpoints in the next execution as the queued operations.
+func (m *Manager) Stop() {
+	select {
+	case <-m.stop:
+		// already stopped
+	default:
+		close(m.stop)
+	}
+	m.dispatcher.stop()
+	m.running.interrupt()
+	m.dispatcher.wait()
+	m.loops.Wait()
+}
+
+// update changes the state of an operation logging the errors
+func (m *Manager) update(requestId string, state utils.DownloadLogState, info string) {
+	updateErr := m.opeCache.Update(requestId, state, info)
+	if updateErr != nil {
+		log.Error().Str("requestId", requestId).Str("trace", updateErr.DebugReport()).Msg("error updating the operation state")
 	}
 }
 
+// finish sets the final state of an operation unless it has been cancelled
+func (m *Manager) finish(requestId string, state utils.DownloadLogState, info string) {
+	finished := m.running.finish(requestId, func() {
+		m.update(requestId, state, info)
+	})
+	if !finished {
+		log.Debug().Str("requestId", requestId).Msg("operation cancelled")
+		m.removeFiles(requestId)
+	}
+}
+
+// interrupt stops a running or queued operation setting its final state. Returns false if the operation was not running.
+func (m *Manager) interrupt(requestId string, state utils.DownloadLogState, info string) bool {
+	interrupted := m.running.cancel(requestId, func() {
+		m.update(requestId, state, info)
+	})
+	if !interrupted {
+		return false
+	}
+	if m.dispatcher.remove(requestId) {
+		// it was still queued, no worker is going to clean it
+		m.removeFiles(requestId)
+	}
+	return true
+}
 
-// download generates the zip file with the log entries
-func (m *Manager) download(request *grpc_log_download_manager_go.DownloadLogRequest, requestId string) {
+// removeFiles deletes the files of an operation, including its archive whatever its format
+func (m *Manager) removeFiles(requestId string) {
+	m.deleteFiles(requestId, append(m.temporaryFiles(requestId), m.archiveFiles(requestId)...))
+}
+
+// archiveFiles returns the paths the archive of an operation can have
+func (m *Manager) archiveFiles(requestId string) []string {
+	paths := make([]string, 0)
+	for _, extension := range utils.ArchiveExtensions() {
+		paths = append(paths, utils.GetArchiveFilePath(m.DownloadDirectory, requestId, extension))
+	}
+	return paths
+}
+
+// removeTemporaryFiles deletes the files used to generate the zip file of an operation
+func (m *Manager) removeTemporaryFiles(requestId string) {
+	m.deleteFiles(requestId, m.temporaryFiles(requestId))
+}
+
+// temporaryFiles returns the files of the targets, the segments and the Parquet files of an operation
+func (m *Manager) temporaryFiles(requestId string) []string {
+	files := append([]string{utils.GetFilePath(m.DownloadDirectory, requestId), utils.GetTruncatedFilePath(m.DownloadDirectory, requestId)},
+		utils.GetSegmentPaths(m.DownloadDirectory, requestId)...)
+	files = append(files, utils.GetParquetFilePaths(m.DownloadDirectory, requestId)...)
+	return append(files, utils.GetTargetFilePaths(m.DownloadDirectory, requestId)...)
+}
+
+func (m *Manager) deleteFiles(requestId string, paths []string) {
+	for _, path := range paths {
+		if err := utils.RemoveFile(path); err != nil && !os.IsNotExist(err) {
+			log.Warn().Str("requestId", requestId).Str("file", path).Msg("error deleting file")
+		}
+	}
+}
+
+// download generates the zip file with the log entries, starting from the checkpoint of the job
+func (m *Manager) download(j *job) {
+	ctx, requestId := j.ctx, j.requestId
 	log.Debug().Str("requestId", requestId).Msg("downloading logs...")
 
 	// 1.- update the status of the operation
-	updateErr := m.opeCache.Update(requestId, utils.Generating, "")
-	if updateErr != nil {
-		log.Error().Err(updateErr).Msg("error updating the operation state")
+	generating := m.running.update(requestId, func() {
+		m.update(requestId, utils.Generating, "")
+	})
+	if !generating {
+		// cancelled while it was queued
+		m.removeFiles(requestId)
+		return
 	}
 
-	// 2.- create the search request
-	searchRequest := entities.NewSearchRequest(request)
+	// 2.- fetch the shards of the window in parallel
+	g := newGeneration(j, m.limits.Restrict(j.request))
+	err := m.fetchShards(ctx, g)
+	if ctx.Err() != nil {
+		if m.running.interrupted() {
+			// the service is stopping, the operation keeps its state and its files to be resumed
+			log.Info().Str("requestId", requestId).Msg("generation interrupted")
+			return
+		}
+		// cancelled, the state has already been updated
+		m.removeFiles(requestId)
+		return
+	}
+	if err != nil {
+		m.finish(requestId, utils.Error, err.Error())
+		return
+	}
 
-	for {
-		// check it the connection already exists
-		ctx, cancel := utils.GetContext()
-		// 3.- Search
-		response, err := m.appManagerClient.Search(ctx, searchRequest)
-		if err != nil {
-			m.opeCache.Update(requestId, utils.Error, err.Error())
-			cancel()
-			break
-		} else {
-			cancel()
-			log.Debug().Int("responses", len(response.Entries)).Msg("entries retrieved")
-			if len(response.Entries) > 0 {
-				// 4.- Copy the log entries in a file ordered
-				err = utils.AppendResponses(entities.Sort(response.Entries, request.Order.Order), utils.GetFilePath(m.DownloadDirectory, requestId), request.IncludeMetadata)
-				if err != nil {
-					updateErr := m.opeCache.Update(requestId, utils.Error, err.Error())
-					if updateErr != nil {
-						log.Error().Err(updateErr).Msg("error updating the operation state")
-					}
-					break
-				}
-			} else {
-				// 5.- If there is no more entries -> create zip file
-				zipErr := utils.ZipFiles(utils.GetZipFilePath(m.DownloadDirectory, requestId), []string{utils.GetFilePath(m.DownloadDirectory, requestId)})
-
-				if zipErr != nil {
-					updateErr := m.opeCache.Update(requestId, utils.Error, zipErr.Error())
-					if updateErr != nil {
-						log.Error().Err(updateErr).Msg("error updating the operation state")
-					}
-				} else {
-					updateErr := m.opeCache.Update(requestId, utils.Ready, "file generated")
-					if updateErr != nil {
-						log.Error().Err(updateErr).Msg("error updating the operation state")
-					}
-					utils.RemoveFile(utils.GetFilePath(m.DownloadDirectory, requestId))
-				}
-				break
+	// 3.- merge the segments of each target in the requested order
+	files, mergeErr := m.mergeTargets(g)
+	if mergeErr != nil {
+		m.finish(requestId, utils.Error, mergeErr.Error())
+		return
+	}
+	g.tracker.complete()
+	m.reportProgress(requestId, g.tracker.progress)
+
+	// 4.- If there is no more entries -> create the archive in the requested format, including the reason of the truncation if any
+	info := "file generated"
+	if truncated := g.checkpoint.Truncated; truncated != "" {
+		truncatedPath := utils.GetTruncatedFilePath(m.DownloadDirectory, requestId)
+		if err := utils.WriteFile(truncatedPath, fmt.Sprintf("%s: %s\n", TruncatedMsg, truncated)); err != nil {
+			m.finish(requestId, utils.Error, err.Error())
+			return
+		}
+		files = append(files, utils.ArchiveFile{Path: truncatedPath, Name: filepath.Base(truncatedPath)})
+		info = fmt.Sprintf("%s, %s: %s", info, TruncatedMsg, truncated)
+	}
+	archivePath := utils.GetArchiveFilePath(m.DownloadDirectory, requestId, utils.ArchiveExtension(g.request.Archive))
+	archiveErr := utils.WriteArchive(archivePath, g.request.Archive, files)
+	if archiveErr != nil {
+		m.finish(requestId, utils.Error, archiveErr.Error())
+		return
+	}
+	m.finish(requestId, utils.Ready, info)
+	m.removeTemporaryFiles(requestId)
+}
+
+// mergeTargets joins the segments of each target in its file and returns the files of the archive. A request
+// without targets generates a single file, the file of each target is named after its descriptor, instance and
+// service names. The files take the extension of the output format. When the request sets part limits the parts
+// of the segments are archived instead, numbered in the order of the generation. The Parquet files are written
+// with the row groups of the segments, the parts of the segments are written in a Parquet file each.
+func (m *Manager) mergeTargets(g *generation) ([]utils.ArchiveFile, error) {
+	if len(g.request.Targets) == 0 {
+		filePath := utils.GetFilePath(m.DownloadDirectory, g.requestId)
+		name := g.format.FileName(filepath.Base(filePath))
+		if utils.NewPartLimits(g.request).Enabled() {
+			return m.parquetParts(g, partFiles(g.segments(m.DownloadDirectory, 0), name, g.format))
+		}
+		if g.format.Columnar() {
+			if err := utils.WriteParquetFile(filePath, g.segments(m.DownloadDirectory, 0)); err != nil {
+				return nil, err
 			}
-			if request.Order.Order == grpc_common_go.Order_ASC {
-				searchRequest.From = response.To + 1000000
-			} else {
-				searchRequest.To = response.From - 1000000
+		} else if err := utils.MergeFiles(filePath, g.segments(m.DownloadDirectory, 0)); err != nil {
+			return nil, err
+		}
+		return []utils.ArchiveFile{{Path: filePath, Name: name}}, nil
+	}
+	names := utils.TargetFileNames(g.tracker.progress.Targets)
+	files := make([]utils.ArchiveFile, 0, len(names))
+	for target, name := range names {
+		if utils.NewPartLimits(g.request).Enabled() {
+			files = append(files, partFiles(g.segments(m.DownloadDirectory, target), name, g.format)...)
+			continue
+		}
+		filePath := utils.GetTargetFilePath(m.DownloadDirectory, g.requestId, target)
+		if g.format.Columnar() {
+			if err := utils.WriteParquetFile(filePath, g.segments(m.DownloadDirectory, target)); err != nil {
+				return nil, err
 			}
+			files = append(files, utils.ArchiveFile{Path: filePath, Name: name})
+			continue
+		}
+		// the file is created again, the segments are kept until the zip file is generated
+		if err := utils.InitializeFormattedFile(filePath, g.format); err != nil {
+			return nil, err
 		}
+		if err := utils.MergeFiles(filePath, g.segments(m.DownloadDirectory, target)); err != nil {
+			return nil, err
+		}
+		files = append(files, utils.ArchiveFile{Path: filePath, Name: name})
+	}
+	return m.parquetParts(g, files)
+}
 
+// parquetParts writes the parts of the segments in Parquet files, if the format is Parquet and the request sets
+// part limits. The files keep their names.
+func (m *Manager) parquetParts(g *generation, files []utils.ArchiveFile) ([]utils.ArchiveFile, error) {
+	if !g.format.Columnar() || !utils.NewPartLimits(g.request).Enabled() {
+		return files, nil
+	}
+	parts := make([]utils.ArchiveFile, 0, len(files))
+	for i, file := range files {
+		parquetPath := utils.GetParquetFilePath(m.DownloadDirectory, g.requestId, i)
+		if err := utils.WriteParquetFile(parquetPath, []string{file.Path}); err != nil {
+			return nil, err
+		}
+		parts = append(parts, utils.ArchiveFile{Path: parquetPath, Name: file.Name})
+	}
+	return parts, nil
+}
+
+// partFiles names the parts of a file in the archive skipping the ones without entries (only with the header
+// of the format), a file without entries keeps its first part
+func partFiles(segments []string, name string, format utils.EntryFormat) []utils.ArchiveFile {
+	header := int64(len(format.Header()))
+	files := make([]utils.ArchiveFile, 0, len(segments))
+	for _, segment := range segments {
+		if fileSize(segment) > header {
+			files = append(files, utils.ArchiveFile{Path: segment, Name: utils.PartFileName(name, len(files)+1)})
+		}
+	}
+	if len(files) == 0 && len(segments) > 0 {
+		files = append(files, utils.ArchiveFile{Path: segments[0], Name: utils.PartFileName(name, 1)})
+	}
+	return files
+}
+
+// search retrieves a page of log entries, retrying the transient errors. The retried function (if any) is
+// called before each retry.
+func (m *Manager) search(ctx context.Context, requestId string, searchRequest *grpc_application_manager_go.SearchRequest, retried func()) (*grpc_application_manager_go.LogResponse, error) {
+	for attempt := 1; ; attempt++ {
+		searchCtx, cancel := context.WithTimeout(ctx, utils.DefaultTimeout)
+		response, err := m.appManagerClient.Search(searchCtx, searchRequest)
+		cancel()
+		if err == nil {
+			return response, nil
+		}
+		if ctx.Err() != nil || attempt >= m.retryPolicy.MaxAttempts || !m.retryPolicy.IsRetryable(err) {
+			return nil, err
+		}
+
+		backoff := m.retryPolicy.Backoff(attempt)
+		log.Warn().Str("requestId", requestId).Int("attempt", attempt).Str("backoff", backoff.String()).Str("err", err.Error()).
+			Msg("search failed, retrying")
+		if retried != nil {
+			retried()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// searchBoundary retrieves the entries of the boundary timestamp from the other end of the order and returns the
+// ones not returned yet. The first page of the timestamp (with the given number of entries) and this one contain
+// all its entries when they overlap or this one is shorter, otherwise the entries between them cannot be retrieved
+// (the search has no offset) and the cursor records them as missed.
+func (m *Manager) searchBoundary(ctx context.Context, requestId string, order grpc_common_go.Order, searchRequest *grpc_application_manager_go.SearchRequest,
+	cursor *pageCursor, first int, retried func()) ([]*grpc_application_manager_go.LogEntryResponse, error) {
+	from, to, nFirst := searchRequest.From, searchRequest.To, searchRequest.NFirst
+	searchRequest.From, searchRequest.To, searchRequest.NFirst = cursor.boundary, cursor.boundary, !nFirst
+	response, err := m.search(ctx, requestId, searchRequest, retried)
+	searchRequest.From, searchRequest.To, searchRequest.NFirst = from, to, nFirst
+	if err != nil {
+		return nil, err
+	}
+	fresh := cursor.next(entities.Sort(response.Entries, order))
+	if len(response.Entries) >= first && len(fresh) == len(response.Entries) && len(fresh) > 0 {
+		log.Warn().Str("requestId", requestId).Int64("timestamp", cursor.boundary).Msg("entries of the timestamp not retrieved")
+		cursor.missed()
+	}
+	return fresh, nil
+}
+
+// paginate retrieves the entries of the search window page by page, following the order of the request. The
+// entries not returned yet are passed to the consume function after moving the window of the search request
+// past them, so an empty page may be consumed too. It finishes when the window has no more entries.
+func (m *Manager) paginate(ctx context.Context, requestId string, order grpc_common_go.Order, searchRequest *grpc_application_manager_go.SearchRequest,
+	cursor *pageCursor, retried func(), consume func(fresh []*grpc_application_manager_go.LogEntryResponse) error) error {
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		response, err := m.search(ctx, requestId, searchRequest, retried)
+		if err != nil {
+			return err
+		}
+		log.Debug().Str("requestId", requestId).Int("responses", len(response.Entries)).Msg("entries retrieved")
+		if len(response.Entries) == 0 {
+			return nil
+		}
+		fresh := cursor.next(entities.Sort(response.Entries, order))
+		if len(fresh) == 0 {
+			// the page only contains entries of the boundary already returned: either the last page or
+			// a timestamp with more entries than fit in a page
+			log.Debug().Str("requestId", requestId).Int64("timestamp", cursor.boundary).Msg("page without new entries")
+			fresh, err = m.searchBoundary(ctx, requestId, order, searchRequest, cursor, len(response.Entries), retried)
+			if err != nil {
+				return err
+			}
+			cursor.skipBoundary()
+		}
+		if cursor.ascending {
+			searchRequest.From = cursor.boundary
+		} else {
+			searchRequest.To = cursor.boundary
+		}
+		if err := consume(fresh); err != nil {
+			return err
+		}
 	}
 }
 
@@ -113,16 +436,74 @@ func (m *Manager) download(request *grpc_log_download_manager_go.DownloadLogRequ
 func (m *Manager) DownloadLog(request *grpc_log_download_manager_go.DownloadLogRequest, userID string) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error) {
 
 	log.Debug().Interface("request", request).Msg("DownloadLog request")
+	// An identical request that is ready does not need to be generated again
+	fingerprint := utils.Fingerprint(request, m.limits.Restrict(request))
+	if source := m.reusable(request, fingerprint); source != nil {
+		response, err := m.reuse(request, userID, fingerprint, source)
+		if err != nil || response != nil {
+			return response, err
+		}
+	}
+
+	if err := m.disk.check(); err != nil {
+		return nil, err
+	}
+
 	requestId := uuid.New().String()
+	m.quotas.Lock()
+	if err := m.checkQuotas(request.OrganizationId, userID); err != nil {
+		m.quotas.Unlock()
+		return nil, err
+	}
 	op, err := m.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, m.DownloadDirectory, userID)
+	m.quotas.Unlock()
 	if err != nil {
 		return nil, err
 	}
+	if fingerprint != "" {
+		if err := m.opeCache.SetFingerprint(requestId, fingerprint, 0); err != nil {
+			log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error setting the operation fingerprint")
+		}
+	}
+	// the url of the operation depends on its archive, it cannot be generated without it
+	if request.Archive != grpc_log_download_manager_go.ArchiveFormat_ZIP {
+		if err := m.opeCache.SetArchive(requestId, utils.ArchiveExtension(request.Archive)); err != nil {
+			if rErr := m.opeCache.Remove(requestId); rErr != nil {
+				log.Warn().Str("requestId", requestId).Str("trace", rErr.DebugReport()).Msg("error removing rejected operation")
+			}
+			return nil, err
+		}
+	}
 
 	// Create the file
-	utils.InitializeFile(utils.GetFilePath(m.DownloadDirectory, requestId), request.IncludeMetadata)
+	filePath := utils.GetFilePath(m.DownloadDirectory, requestId)
+	utils.InitializeFormattedFile(filePath, utils.NewEntryFormat(request))
 
-	go m.download(request, requestId)
+	// The first checkpoint allows resuming the operation even if it has not started. A limited number of entries
+	// or bytes is fetched in a single shard, so the truncated file contains the first entries in the requested order.
+	searchRequest := entities.NewSearchRequest(request)
+	limits := m.limits.Restrict(request)
+	from, to, truncated := limits.Window(searchRequest.From, searchRequest.To, request.Order.Order == grpc_common_go.Order_ASC)
+	shards := m.shards
+	if limits.Bounded() {
+		shards = 1
+	}
+	checkpoint := utils.NewCheckpoint(request, from, to, shards, fileSize(filePath))
+	checkpoint.Truncated = truncated
+	if err := m.opeCache.SaveCheckpoint(requestId, checkpoint, utils.GenerationProgress{}); err != nil {
+		log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error saving the first checkpoint")
+	}
+
+	ctx := m.running.start(requestId)
+	queueErr := m.dispatcher.enqueue(&job{ctx: ctx, requestId: requestId, userId: userID, request: request, checkpoint: checkpoint})
+	if queueErr != nil {
+		m.running.finish(requestId, func() {})
+		if err := m.opeCache.Remove(requestId); err != nil {
+			log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error removing rejected operation")
+		}
+		m.removeFiles(requestId)
+		return nil, queueErr
+	}
 
 	return &grpc_log_download_manager_go.DownloadLogResponse{
 		OrganizationId: request.OrganizationId,
@@ -130,11 +511,28 @@ func (m *Manager) DownloadLog(request *grpc_log_download_manager_go.DownloadLogR
 		From:           op.From,
 		To:             op.To,
 		State:          utils.DownloadLogStateToGRPC[op.State],
+		QueuePosition:  int32(m.dispatcher.positions()[requestId]),
 	}, nil
 }
 
-// Check asks for a download operation state
-func (m *Manager) Check(request *grpc_log_download_manager_go.DownloadRequestId, userID string) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error) {
+// Resume queues again the operations interrupted by a restart, they continue from their checkpoint
+func (m *Manager) Resume(operations []*utils.DownloadOperation) {
+	for _, ope := range operations {
+		log.Info().Str("requestId", ope.RequestId).Int64("bytes", ope.Checkpoint.Bytes).Msg("resuming operation")
+		ctx := m.running.start(ope.RequestId)
+		m.dispatcher.requeue(&job{
+			ctx:        ctx,
+			requestId:  ope.RequestId,
+			userId:     ope.UserId,
+			request:    ope.Checkpoint.Request,
+			checkpoint: *ope.Checkpoint,
+			progress:   ope.Progress,
+		})
+	}
+}
+
+// getOperation retrieves an operation checking that the user is allowed to access it
+func (m *Manager) getOperation(request *grpc_log_download_manager_go.DownloadRequestId, userID string) (*utils.DownloadOperation, derrors.Error) {
 	operation, err := m.opeCache.Get(request.RequestId)
 	if err != nil {
 		return nil, conversions.ToDerror(err)
@@ -144,7 +542,36 @@ func (m *Manager) Check(request *grpc_log_download_manager_go.DownloadRequestId,
 			return nil, derrors.NewPermissionDeniedError("operation not allowed for the user").WithParams(userID)
 		}
 	}
-	return entities.NewDownloadLogResponse(request, operation), nil
+	return operation, nil
+}
+
+// Check asks for a download operation state
+func (m *Manager) Check(request *grpc_log_download_manager_go.DownloadRequestId, userID string) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error) {
+	operation, err := m.getOperation(request, userID)
+	if err != nil {
+		return nil, err
+	}
+	response := entities.NewDownloadLogResponse(request, operation)
+	response.QueuePosition = int32(m.dispatcher.positions()[request.RequestId])
+	return response, nil
+}
+
+// Cancel stops the generation of a download operation
+func (m *Manager) Cancel(request *grpc_log_download_manager_go.DownloadRequestId, userID string) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error) {
+	operation, err := m.getOperation(request, userID)
+	if err != nil {
+		return nil, err
+	}
+	if operation.OrganizationId != request.OrganizationId {
+		return nil, derrors.NewPermissionDeniedError("operation not allowed for the organization").WithParams(request.OrganizationId)
+	}
+
+	if !m.interrupt(request.RequestId, utils.Cancelled, CancelledMsg) {
+		return nil, derrors.NewFailedPreconditionError("the operation is not running").WithParams(request.RequestId)
+	}
+	log.Debug().Str("requestId", request.RequestId).Msg("operation cancelled")
+
+	return m.Check(request, userID)
 }
 
 // List retrieves a list of LogResponses
@@ -154,10 +581,13 @@ func (m *Manager) List(organizationID *grpc_organization_go.OrganizationId, user
 	if err != nil {
 		return nil, conversions.ToDerror(err)
 	}
+	positions := m.dispatcher.positions()
 	logResponseList := make([]*grpc_log_download_manager_go.DownloadLogResponse, 0)
 	for _, ope := range list {
 		if (ope.UserId != "" && userID != ""  && ope.UserId == userID ) || (ope.UserId == "" || userID == ""){
-			logResponseList = append(logResponseList, ope.ToGRPC())
+			response := ope.ToGRPC()
+			response.QueuePosition = int32(positions[ope.RequestId])
+			logResponseList = append(logResponseList, response)
 		}
 
 	}
@@ -165,3 +595,20 @@ func (m *Manager) List(organizationID *grpc_organization_go.OrganizationId, user
 		Responses: logResponseList,
 	}, nil
 }
+
+// GetQuotaUsage returns the usage and the quota of the organization and the user
+func (m *Manager) GetQuotaUsage(organizationID *grpc_organization_go.OrganizationId, userID string) (*grpc_log_download_manager_go.QuotaUsageResponse, derrors.Error) {
+	organizationUsage, userUsage, err := m.usage(organizationID.OrganizationId, userID)
+	if err != nil {
+		return nil, err
+	}
+	response := &grpc_log_download_manager_go.QuotaUsageResponse{
+		OrganizationId: organizationID.OrganizationId,
+		UserId:         userID,
+		Organization:   organizationUsage.ToGRPC(m.quotas.organization),
+	}
+	if userUsage != nil {
+		response.User = userUsage.ToGRPC(m.quotas.user)
+	}
+	return response, nil
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"os"
+)
+
+// shardProgress is the part of the window covered by a shard
+type shardProgress struct {
+	// start and end delimit the window (ns) of the shard
+	start int64
+	end   int64
+	// position is the timestamp (ns) reached
+	position int64
+	// bytes is the size of the segment file, including all its parts
+	bytes int64
+	done  bool
+	// target is the index of the target of the request fetched by the shard
+	target int
+}
+
+// covered returns the length (ns) of the window of the shard already covered
+func (s shardProgress) covered(ascending bool) int64 {
+	width := s.end - s.start
+	if s.done {
+		return width
+	}
+	covered := s.position - s.start
+	if !ascending {
+		covered = s.end - s.position
+	}
+	if covered < 0 {
+		return 0
+	}
+	if covered > width {
+		return width
+	}
+	return covered
+}
+
+// progressTracker accumulates the progress of a generation
+type progressTracker struct {
+	progress  utils.GenerationProgress
+	ascending bool
+	// fileBytes is the size of the file before merging the segments
+	fileBytes int64
+	shards    []shardProgress
+}
+
+// newProgressTracker creates a tracker starting at a checkpoint with the progress reached on it
+func newProgressTracker(checkpoint utils.Checkpoint, ascending bool, progress utils.GenerationProgress) *progressTracker {
+	tracker := &progressTracker{
+		progress:  progress,
+		ascending: ascending,
+		fileBytes: checkpoint.Bytes,
+		shards:    make([]shardProgress, 0, len(checkpoint.Shards)),
+	}
+	if len(tracker.progress.Targets) == 0 && checkpoint.Request != nil {
+		tracker.progress.Targets = utils.NewTargetsProgress(checkpoint.Request)
+	}
+	for _, shard := range checkpoint.Shards {
+		position := shard.From
+		if !ascending {
+			position = shard.To
+		}
+		tracker.shards = append(tracker.shards, shardProgress{
+			start:    shard.Start,
+			end:      shard.End,
+			position: position,
+			bytes:    shard.PartsBytes + shard.Bytes,
+			done:     shard.Done,
+			target:   shard.Target,
+		})
+	}
+	return tracker
+}
+
+// page adds a Search page of a shard. The position is the timestamp (ns) reached by the page and bytes
+// the size of the segment file.
+func (p *progressTracker) page(shard int, entries int, position int64, bytes int64) {
+	p.progress.Entries += int64(entries)
+	p.progress.Pages++
+	if entries > 0 && p.shards[shard].target < len(p.progress.Targets) {
+		p.targets()[p.shards[shard].target].Entries += int64(entries)
+	}
+	p.shards[shard].position = position
+	p.shards[shard].bytes = bytes
+	p.update()
+}
+
+// name sets the name of a target, the first one is kept
+func (p *progressTracker) name(target int, name string) {
+	if target >= len(p.progress.Targets) || p.progress.Targets[target].Name != "" {
+		return
+	}
+	p.targets()[target].Name = name
+}
+
+// targets copies the progress of the targets before changing it, so the progress already reported is not modified
+func (p *progressTracker) targets() []utils.TargetProgress {
+	p.progress.Targets = append([]utils.TargetProgress(nil), p.progress.Targets...)
+	return p.progress.Targets
+}
+
+// finishShard marks the whole window of a shard as covered
+func (p *progressTracker) finishShard(shard int) {
+	p.shards[shard].done = true
+	p.update()
+}
+
+// update computes the bytes and the fraction of the window covered by all the shards
+func (p *progressTracker) update() {
+	var covered, window int64
+	p.progress.Bytes = p.fileBytes
+	for _, shard := range p.shards {
+		covered += shard.covered(p.ascending)
+		window += shard.end - shard.start
+		p.progress.Bytes += shard.bytes
+	}
+	if window > 0 {
+		p.progress.Covered = float64(covered) / float64(window)
+	}
+}
+
+// retry counts a retried Search call
+func (p *progressTracker) retry() {
+	p.progress.Retries++
+}
+
+// complete marks the whole window as covered
+func (p *progressTracker) complete() {
+	p.progress.Covered = 1
+}
+
+// fileSize returns the size of a file, 0 if it cannot be read
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// reportProgress stores the progress of an operation logging the errors
+func (m *Manager) reportProgress(requestId string, progress utils.GenerationProgress) {
+	if err := m.opeCache.UpdateProgress(requestId, progress); err != nil {
+		log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error updating the operation progress")
+	}
+}
+
+// saveCheckpoint stores the checkpoint and the progress of an operation logging the errors
+func (m *Manager) saveCheckpoint(requestId string, checkpoint utils.Checkpoint, progress utils.GenerationProgress) {
+	if err := m.opeCache.SaveCheckpoint(requestId, checkpoint, progress); err != nil {
+		log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error saving the operation checkpoint")
+	}
+}
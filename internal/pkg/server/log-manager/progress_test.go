@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Progress tracker", func() {
+
+	shard := utils.ShardCheckpoint{Start: 1000, End: 2000, From: 1000, To: 2000}
+
+	ginkgo.It("should track an ascending generation", func() {
+		tracker := newProgressTracker(utils.Checkpoint{Shards: []utils.ShardCheckpoint{shard}}, true, utils.GenerationProgress{})
+		tracker.page(0, 10, 1250, 100)
+		tracker.page(0, 5, 1500, 150)
+
+		gomega.Expect(tracker.progress.Entries).Should(gomega.Equal(int64(15)))
+		gomega.Expect(tracker.progress.Pages).Should(gomega.Equal(2))
+		gomega.Expect(tracker.progress.Bytes).Should(gomega.Equal(int64(150)))
+		gomega.Expect(tracker.progress.Covered).Should(gomega.BeNumerically("~", 0.5))
+
+		tracker.complete()
+		gomega.Expect(tracker.progress.Covered).Should(gomega.BeNumerically("~", 1))
+	})
+
+	ginkgo.It("should track a descending generation", func() {
+		tracker := newProgressTracker(utils.Checkpoint{Shards: []utils.ShardCheckpoint{shard}}, false, utils.GenerationProgress{})
+		tracker.page(0, 10, 1750, 100)
+		gomega.Expect(tracker.progress.Covered).Should(gomega.BeNumerically("~", 0.25))
+
+		tracker.page(0, 10, 500, 200)
+		gomega.Expect(tracker.progress.Covered).Should(gomega.BeNumerically("~", 1))
+	})
+
+	ginkgo.It("should add the progress of all the shards", func() {
+		checkpoint := utils.NewCheckpoint(nil, 1000, 2000, 2, 50)
+		tracker := newProgressTracker(checkpoint, true, utils.GenerationProgress{Entries: 3, Pages: 1})
+		tracker.page(0, 10, 1250, 100)
+		tracker.page(1, 5, 1750, 40)
+
+		gomega.Expect(tracker.progress.Entries).Should(gomega.Equal(int64(18)))
+		gomega.Expect(tracker.progress.Pages).Should(gomega.Equal(3))
+		gomega.Expect(tracker.progress.Bytes).Should(gomega.Equal(int64(190)))
+		gomega.Expect(tracker.progress.Covered).Should(gomega.BeNumerically("~", 0.5, 0.01))
+
+		tracker.finishShard(0)
+		gomega.Expect(tracker.progress.Covered).Should(gomega.BeNumerically("~", 0.75, 0.01))
+	})
+})
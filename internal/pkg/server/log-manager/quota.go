@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"sync"
+)
+
+// quotas keeps the limits of the organizations and the users. The lock is held while checking the
+// quota and adding the operation, so concurrent requests cannot exceed it.
+type quotas struct {
+	sync.Mutex
+	organization utils.Quota
+	user         utils.Quota
+}
+
+// usage computes the usage of the organization and of the user (if any)
+func (m *Manager) usage(organizationId string, userID string) (utils.QuotaUsage, *utils.QuotaUsage, derrors.Error) {
+	operations, err := m.opeCache.List(organizationId)
+	if err != nil {
+		return utils.QuotaUsage{}, nil, err
+	}
+	organizationUsage := utils.NewQuotaUsage(operations, m.DownloadDirectory)
+	if userID == "" {
+		return organizationUsage, nil, nil
+	}
+	userOperations := make([]*utils.DownloadOperation, 0)
+	for _, ope := range operations {
+		if ope.UserId == userID {
+			userOperations = append(userOperations, ope)
+		}
+	}
+	userUsage := utils.NewQuotaUsage(userOperations, m.DownloadDirectory)
+	return organizationUsage, &userUsage, nil
+}
+
+// checkQuotas returns a ResourceExhausted error if a new operation exceeds the quota of the organization or the user.
+// It must be called holding the quotas lock.
+func (m *Manager) checkQuotas(organizationId string, userID string) derrors.Error {
+	organizationUsage, userUsage, err := m.usage(organizationId, userID)
+	if err != nil {
+		return err
+	}
+	if err := m.quotas.organization.Check(organizationUsage, "organization", organizationId); err != nil {
+		return err
+	}
+	if userUsage != nil {
+		return m.quotas.user.Check(*userUsage, "user", userID)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// ReusedMsg is the info of the operations that reuse the artifact of a previous one
+const ReusedMsg = "reused the artifact of"
+
+// reusable returns a ready operation of the organization generated for a request with the same fingerprint whose
+// artifact can still be downloaded, nil if there is none. The artifact must have been generated once all the
+// entries of the window were ingested, that is, after its end plus the grace period.
+func (m *Manager) reusable(request *grpc_log_download_manager_go.DownloadLogRequest, fingerprint string) *utils.DownloadOperation {
+	if fingerprint == "" {
+		return nil
+	}
+	list, err := m.opeCache.List(request.OrganizationId)
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Msg("error looking for a reusable artifact")
+		return nil
+	}
+	now := time.Now().UnixNano()
+	ingested := request.To + int64(m.reuseGrace)
+	for _, ope := range list {
+		if ope.Fingerprint == fingerprint && ope.State == utils.Ready && ope.Expiration > now &&
+			ope.Generated > ingested && fileExists(ope.ArchivePath()) {
+			return ope
+		}
+	}
+	return nil
+}
+
+// reuse creates a ready operation for the user whose artifact is linked to the one of the source operation, so
+// both can be downloaded and expire independently. A nil response means the artifact could not be linked
+// (e.g. it has just expired) and the request must be generated.
+func (m *Manager) reuse(request *grpc_log_download_manager_go.DownloadLogRequest, userID string, fingerprint string,
+	source *utils.DownloadOperation) (*grpc_log_download_manager_go.DownloadLogResponse, derrors.Error) {
+
+	requestId := uuid.New().String()
+	m.quotas.Lock()
+	if err := m.checkQuotas(request.OrganizationId, userID); err != nil {
+		m.quotas.Unlock()
+		return nil, err
+	}
+	_, err := m.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, m.DownloadDirectory, userID)
+	m.quotas.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// the artifact keeps the archive format of the source
+	if source.Archive != "" {
+		if err := m.opeCache.SetArchive(requestId, source.Archive); err != nil {
+			if rErr := m.opeCache.Remove(requestId); rErr != nil {
+				log.Warn().Str("requestId", requestId).Str("trace", rErr.DebugReport()).Msg("error removing the operation")
+			}
+			return nil, err
+		}
+	}
+
+	linkErr := utils.LinkFile(source.ArchivePath(), utils.GetArchiveFilePath(m.DownloadDirectory, requestId, source.ArchiveExtension()))
+	if linkErr != nil {
+		log.Warn().Str("requestId", requestId).Str("source", source.RequestId).Str("err", linkErr.Error()).
+			Msg("cannot reuse the artifact, generating it")
+		if err := m.opeCache.Remove(requestId); err != nil {
+			log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error removing the operation")
+		}
+		m.removeFiles(requestId)
+		return nil, nil
+	}
+
+	// the artifact has the generation time of the source
+	if err := m.opeCache.SetFingerprint(requestId, fingerprint, source.Generated); err != nil {
+		log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("error setting the operation fingerprint")
+	}
+	m.reportProgress(requestId, source.Progress)
+	m.update(requestId, utils.Ready, fmt.Sprintf("%s (%s %s)", source.Info, ReusedMsg, source.RequestId))
+	log.Debug().Str("requestId", requestId).Str("source", source.RequestId).Msg("artifact reused")
+
+	op, err := m.opeCache.Get(requestId)
+	if err != nil {
+		return nil, err
+	}
+	return op.ToGRPC(), nil
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/google/uuid"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+const reuseTestDir = "./reuseTestDir/"
+
+var _ = ginkgo.Describe("Artifact reuse", func() {
+
+	var manager Manager
+	var request *grpc_log_download_manager_go.DownloadLogRequest
+	var fingerprint string
+
+	// ready adds a ready operation of the request with a zip file
+	ready := func() string {
+		requestId := uuid.New().String()
+		_, err := manager.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, reuseTestDir, "owner")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.opeCache.SetFingerprint(requestId, fingerprint, 0)).To(gomega.Succeed())
+		gomega.Expect(manager.opeCache.Update(requestId, utils.Generating, "")).To(gomega.Succeed())
+		gomega.Expect(ioutil.WriteFile(utils.GetZipFilePath(reuseTestDir, requestId), []byte("zip"), 0644)).To(gomega.Succeed())
+		gomega.Expect(manager.opeCache.Update(requestId, utils.Ready, "file generated")).To(gomega.Succeed())
+		return requestId
+	}
+
+	ginkgo.BeforeEach(func() {
+		gomega.Expect(os.MkdirAll(reuseTestDir, os.ModePerm)).To(gomega.Succeed())
+		manager = Manager{
+			opeCache:          utils.NewDownloadCache("/test/", "nalej.tech"),
+			DownloadDirectory: reuseTestDir,
+			quotas:            &quotas{},
+			reuseGrace:        time.Minute,
+		}
+		request = &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			From:           1000,
+			To:             2000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+		}
+		fingerprint = utils.Fingerprint(request, manager.limits)
+	})
+	ginkgo.AfterEach(func() {
+		gomega.Expect(os.RemoveAll(reuseTestDir)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should create a ready operation linked to the artifact", func() {
+		sourceId := ready()
+		source := manager.reusable(request, fingerprint)
+		gomega.Expect(source).NotTo(gomega.BeNil())
+		gomega.Expect(source.RequestId).Should(gomega.Equal(sourceId))
+
+		response, err := manager.reuse(request, "user", fingerprint, source)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response).NotTo(gomega.BeNil())
+		gomega.Expect(response.RequestId).ShouldNot(gomega.Equal(sourceId))
+		gomega.Expect(response.State).Should(gomega.Equal(grpc_log_download_manager_go.DownloadLogState_READY))
+		gomega.Expect(response.Info).Should(gomega.ContainSubstring(sourceId))
+		reused, err := manager.opeCache.Get(response.RequestId)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(reused.Generated).Should(gomega.Equal(source.Generated))
+
+		// the artifact is kept when the source is removed
+		gomega.Expect(os.Remove(utils.GetZipFilePath(reuseTestDir, sourceId))).To(gomega.Succeed())
+		content, rErr := ioutil.ReadFile(utils.GetZipFilePath(reuseTestDir, response.RequestId))
+		gomega.Expect(rErr).To(gomega.Succeed())
+		gomega.Expect(string(content)).Should(gomega.Equal("zip"))
+	})
+
+	ginkgo.It("should not reuse the artifacts of other requests or not ready", func() {
+		ready()
+		other := &grpc_log_download_manager_go.DownloadLogRequest{OrganizationId: "other", To: request.To}
+		gomega.Expect(manager.reusable(other, fingerprint)).To(gomega.BeNil())
+		gomega.Expect(manager.reusable(request, "other")).To(gomega.BeNil())
+		gomega.Expect(manager.reusable(request, "")).To(gomega.BeNil())
+
+		requestId := uuid.New().String()
+		_, err := manager.opeCache.Add(request.OrganizationId, requestId, request.From, request.To, reuseTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.opeCache.SetFingerprint(requestId, "generating", 0)).To(gomega.Succeed())
+		gomega.Expect(manager.reusable(request, "generating")).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should not reuse the artifacts generated before the entries of the window were ingested", func() {
+		request.To = time.Now().UnixNano()
+		fingerprint = utils.Fingerprint(request, manager.limits)
+		sourceId := ready()
+		gomega.Expect(manager.reusable(request, fingerprint)).To(gomega.BeNil())
+
+		// without grace period, the artifacts generated after the end of the window are reused
+		manager.reuseGrace = 0
+		source := manager.reusable(request, fingerprint)
+		gomega.Expect(source).NotTo(gomega.BeNil())
+		gomega.Expect(source.RequestId).Should(gomega.Equal(sourceId))
+	})
+})
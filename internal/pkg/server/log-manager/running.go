@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"context"
+	"sync"
+)
+
+// runningOperations keeps the contexts of the operations being generated so they can be cancelled.
+// The final state of an operation is set holding the lock, so a cancellation never overwrites a
+// finished operation and a finished operation never overwrites a cancellation. The contexts of the operations
+// derive from the shutdown context, cancelled when the service stops.
+type runningOperations struct {
+	sync.Mutex
+	cancels  map[string]context.CancelFunc
+	shutdown context.Context
+	stop     context.CancelFunc
+}
+
+func newRunningOperations() *runningOperations {
+	shutdown, stop := context.WithCancel(context.Background())
+	return &runningOperations{
+		cancels:  make(map[string]context.CancelFunc, 0),
+		shutdown: shutdown,
+		stop:     stop,
+	}
+}
+
+// start registers a new operation and returns its context
+func (r *runningOperations) start(requestId string) context.Context {
+	r.Lock()
+	defer r.Unlock()
+
+	ctx, cancel := context.WithCancel(r.shutdown)
+	r.cancels[requestId] = cancel
+	return ctx
+}
+
+// update executes the update (holding the lock) only if the operation has not been cancelled,
+// and the result reports it.
+func (r *runningOperations) update(requestId string, update func()) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	_, running := r.cancels[requestId]
+	if !running {
+		return false
+	}
+	update()
+	return true
+}
+
+// finish unregisters an operation. The update is executed (holding the lock) only if the
+// operation has not been cancelled, and the result reports it.
+func (r *runningOperations) finish(requestId string, update func()) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	cancel, running := r.cancels[requestId]
+	if !running {
+		return false
+	}
+	update()
+	cancel()
+	delete(r.cancels, requestId)
+	return true
+}
+
+// cancel cancels the context of a running operation. The update is executed (holding the lock) only if
+// the operation was still running, and the result reports it.
+func (r *runningOperations) cancel(requestId string, update func()) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	cancel, running := r.cancels[requestId]
+	if !running {
+		return false
+	}
+	update()
+	cancel()
+	delete(r.cancels, requestId)
+	return true
+}
+
+// interrupt cancels the contexts of all the operations because the service is stopping. The operations
+// are not unregistered and their state is not changed, they are resumed in the next execution.
+func (r *runningOperations) interrupt() {
+	r.stop()
+}
+
+// interrupted checks if the service is stopping
+func (r *runningOperations) interrupted() bool {
+	return r.shutdown.Err() != nil
+}
+
+// ids returns the request ids of the running operations
+func (r *runningOperations) ids() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	result := make([]string, 0, len(r.cancels))
+	for requestId := range r.cancels {
+		result = append(result, requestId)
+	}
+	return result
+}
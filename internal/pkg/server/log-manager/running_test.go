@@ -0,0 +1,97 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"fmt"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"sync"
+)
+
+var _ = ginkgo.Describe("Running operations", func() {
+
+	ginkgo.It("should update a running operation until it finishes", func() {
+		running := newRunningOperations()
+		ctx := running.start("id")
+		gomega.Expect(running.ids()).Should(gomega.Equal([]string{"id"}))
+
+		updates := 0
+		gomega.Expect(running.update("id", func() { updates++ })).Should(gomega.BeTrue())
+		gomega.Expect(running.finish("id", func() { updates++ })).Should(gomega.BeTrue())
+		gomega.Expect(updates).Should(gomega.Equal(2))
+		gomega.Expect(ctx.Err()).ShouldNot(gomega.Succeed())
+		gomega.Expect(running.ids()).Should(gomega.BeEmpty())
+
+		gomega.Expect(running.update("id", func() { updates++ })).Should(gomega.BeFalse())
+		gomega.Expect(running.finish("id", func() { updates++ })).Should(gomega.BeFalse())
+		gomega.Expect(running.cancel("id", func() { updates++ })).Should(gomega.BeFalse())
+		gomega.Expect(updates).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should not update a cancelled operation", func() {
+		running := newRunningOperations()
+		ctx := running.start("id")
+
+		cancelled := false
+		gomega.Expect(running.cancel("id", func() { cancelled = true })).Should(gomega.BeTrue())
+		gomega.Expect(cancelled).Should(gomega.BeTrue())
+		gomega.Eventually(ctx.Done()).Should(gomega.BeClosed())
+
+		gomega.Expect(running.update("id", func() { ginkgo.Fail("cancelled operation updated") })).Should(gomega.BeFalse())
+		gomega.Expect(running.finish("id", func() { ginkgo.Fail("cancelled operation finished") })).Should(gomega.BeFalse())
+		gomega.Expect(running.cancel("id", func() { ginkgo.Fail("cancelled twice") })).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should set a single final state when the cancellation races with the end", func() {
+		running := newRunningOperations()
+		operations := 100
+		for i := 0; i < operations; i++ {
+			running.start(fmt.Sprintf("id%d", i))
+		}
+
+		var mutex sync.Mutex
+		states := make(map[string][]string, 0)
+		record := func(requestId string, state string) func() {
+			return func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+				states[requestId] = append(states[requestId], state)
+			}
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < operations; i++ {
+			requestId := fmt.Sprintf("id%d", i)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				running.finish(requestId, record(requestId, "ready"))
+			}()
+			go func() {
+				defer wg.Done()
+				running.cancel(requestId, record(requestId, "cancelled"))
+			}()
+		}
+		wg.Wait()
+
+		gomega.Expect(running.ids()).Should(gomega.BeEmpty())
+		gomega.Expect(states).Should(gomega.HaveLen(operations))
+		for requestId, final := range states {
+			gomega.Expect(final).Should(gomega.HaveLen(1), requestId)
+		}
+	})
+})
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/google/uuid"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/log-download-manager/internal/pkg/entities"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// maxSchedulerSleep bounds the wait between two reviews of the schedules, so the changes of the clock are noticed
+const maxSchedulerSleep = time.Minute
+
+// scheduler runs the recurring exports. The changes of the schedules are done holding the lock, so a run
+// never overwrites a schedule paused or deleted at the same time.
+type scheduler struct {
+	sync.Mutex
+	store utils.ScheduleStore
+	// wake is signaled when the schedules change
+	wake chan struct{}
+}
+
+func newScheduler(store utils.ScheduleStore) *scheduler {
+	return &scheduler{
+		store: store,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// changed wakes up the scheduler to review the schedules
+func (s *scheduler) changed() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runSchedules creates the operations of the schedules on time
+func (m *Manager) runSchedules() {
+	defer m.loops.Done()
+
+	for {
+		wait := maxSchedulerSleep
+		if next := m.runDueSchedules(time.Now()); next > 0 {
+			if untilNext := time.Until(time.Unix(0, next)); untilNext < wait {
+				wait = untilNext
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-m.schedules.wake:
+			timer.Stop()
+		case <-m.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// runDueSchedules runs the schedules whose time has been reached and returns the time (ns) of the next run,
+// 0 if there is none. A run missed while the service was down is executed once when it starts.
+func (m *Manager) runDueSchedules(now time.Time) int64 {
+	m.schedules.Lock()
+	defer m.schedules.Unlock()
+
+	schedules, err := m.schedules.store.ListAll()
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Msg("error listing the schedules")
+		return 0
+	}
+	var next int64
+	for _, schedule := range schedules {
+		if schedule.Paused || schedule.NextRun == 0 {
+			continue
+		}
+		if schedule.NextRun <= now.UnixNano() {
+			m.runSchedule(schedule, now)
+		}
+		if schedule.NextRun > 0 && (next == 0 || schedule.NextRun < next) {
+			next = schedule.NextRun
+		}
+	}
+	return next
+}
+
+// runSchedule creates the operation of a run and computes the next one, the lock must be held
+func (m *Manager) runSchedule(schedule *utils.Schedule, now time.Time) {
+	at := time.Unix(0, schedule.NextRun)
+	schedule.LastRun, schedule.LastRequestId, schedule.LastError = now.UnixNano(), "", ""
+	requestId, err := m.scheduledDownload(schedule, at)
+	if err != nil {
+		log.Warn().Str("scheduleId", schedule.ScheduleId).Str("trace", err.DebugReport()).Msg("error running the schedule")
+		schedule.LastError = err.Error()
+	} else {
+		log.Debug().Str("scheduleId", schedule.ScheduleId).Str("requestId", requestId).Msg("schedule run")
+		schedule.LastRequestId = requestId
+	}
+
+	next, err := schedule.Next(now)
+	if err != nil {
+		// the schedule was validated when it was created, it is paused to stop retrying it
+		schedule.LastError, schedule.Paused = err.Error(), true
+	}
+	schedule.NextRun = next
+	if err := m.schedules.store.Update(schedule); err != nil {
+		log.Warn().Str("scheduleId", schedule.ScheduleId).Str("trace", err.DebugReport()).Msg("error updating the schedule")
+	}
+}
+
+// scheduledDownload creates the operation of a run at the given time and returns its request id
+func (m *Manager) scheduledDownload(schedule *utils.Schedule, at time.Time) (string, derrors.Error) {
+	request, err := schedule.NewRequest(at)
+	if err != nil {
+		return "", err
+	}
+	if err := entities.ValidDownloadLogWindow(request, m.limits.MaxWindow); err != nil {
+		return "", err
+	}
+	response, err := m.DownloadLog(request, schedule.Owner)
+	if err != nil {
+		return "", err
+	}
+	return response.RequestId, nil
+}
+
+// getSchedule returns a schedule of the organization, checking that the user can access it
+func (m *Manager) getSchedule(request *grpc_log_download_manager_go.ScheduleId, userID string) (*utils.Schedule, derrors.Error) {
+	schedule, err := m.schedules.store.Get(request.ScheduleId)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.OrganizationId != request.OrganizationId {
+		return nil, derrors.NewNotFoundError("schedule").WithParams(request.ScheduleId)
+	}
+	if schedule.Owner != "" && userID != "" && schedule.Owner != userID {
+		return nil, derrors.NewPermissionDeniedError("schedule not allowed for the user").WithParams(userID)
+	}
+	return schedule, nil
+}
+
+// CreateSchedule adds a recurring export owned by the user
+func (m *Manager) CreateSchedule(request *grpc_log_download_manager_go.CreateScheduleRequest, userID string) (*grpc_log_download_manager_go.Schedule, derrors.Error) {
+	now := time.Now()
+	template := *request.Request
+	schedule := &utils.Schedule{
+		OrganizationId: request.OrganizationId,
+		ScheduleId:     uuid.New().String(),
+		Owner:          userID,
+		Cron:           request.Cron,
+		TimeZone:       request.TimeZone,
+		Request:        &template,
+		Window:         request.Window,
+		Period:         time.Duration(request.Period),
+		Created:        now.UnixNano(),
+	}
+	if m.limits.MaxWindow > 0 && schedule.WindowLength() > m.limits.MaxWindow {
+		return nil, derrors.NewInvalidArgumentError("the window of the schedule exceeds the maximum allowed").
+			WithParams(m.limits.MaxWindow.String())
+	}
+	next, err := schedule.Next(now)
+	if err != nil {
+		return nil, err
+	}
+	if next == 0 {
+		return nil, derrors.NewInvalidArgumentError("the cron expression does not match any time").WithParams(request.Cron)
+	}
+	schedule.NextRun = next
+
+	m.schedules.Lock()
+	err = m.schedules.store.Add(schedule)
+	m.schedules.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	m.schedules.changed()
+	return schedule.ToGRPC(), nil
+}
+
+// ListSchedules returns the schedules of the organization the user can access
+func (m *Manager) ListSchedules(organizationID *grpc_organization_go.OrganizationId, userID string) (*grpc_log_download_manager_go.ScheduleList, derrors.Error) {
+	list, err := m.schedules.store.List(organizationID.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*grpc_log_download_manager_go.Schedule, 0, len(list))
+	for _, schedule := range list {
+		if schedule.Owner == "" || userID == "" || schedule.Owner == userID {
+			result = append(result, schedule.ToGRPC())
+		}
+	}
+	return &grpc_log_download_manager_go.ScheduleList{Schedules: result}, nil
+}
+
+// PauseSchedule stops the runs of a schedule until it is resumed
+func (m *Manager) PauseSchedule(request *grpc_log_download_manager_go.ScheduleId, userID string) (*grpc_log_download_manager_go.Schedule, derrors.Error) {
+	m.schedules.Lock()
+	defer m.schedules.Unlock()
+
+	schedule, err := m.getSchedule(request, userID)
+	if err != nil {
+		return nil, err
+	}
+	schedule.Paused, schedule.NextRun = true, 0
+	if err := m.schedules.store.Update(schedule); err != nil {
+		return nil, err
+	}
+	return schedule.ToGRPC(), nil
+}
+
+// ResumeSchedule restarts the runs of a paused schedule, the runs missed while it was paused are skipped
+func (m *Manager) ResumeSchedule(request *grpc_log_download_manager_go.ScheduleId, userID string) (*grpc_log_download_manager_go.Schedule, derrors.Error) {
+	m.schedules.Lock()
+	defer m.schedules.Unlock()
+
+	schedule, err := m.getSchedule(request, userID)
+	if err != nil {
+		return nil, err
+	}
+	next, err := schedule.Next(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	schedule.Paused, schedule.NextRun = false, next
+	if err := m.schedules.store.Update(schedule); err != nil {
+		return nil, err
+	}
+	m.schedules.changed()
+	return schedule.ToGRPC(), nil
+}
+
+// DeleteSchedule removes a schedule, the operations it created are kept
+func (m *Manager) DeleteSchedule(request *grpc_log_download_manager_go.ScheduleId, userID string) (*grpc_common_go.Success, derrors.Error) {
+	m.schedules.Lock()
+	defer m.schedules.Unlock()
+
+	if _, err := m.getSchedule(request, userID); err != nil {
+		return nil, err
+	}
+	if err := m.schedules.store.Remove(request.ScheduleId); err != nil {
+		return nil, err
+	}
+	return &grpc_common_go.Success{}, nil
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log_manager
+
+import (
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/nalej/grpc-organization-go"
+	"github.com/nalej/log-download-manager/internal/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"time"
+)
+
+const schedulerTestDir = "./schedulerTestDir/"
+
+var _ = ginkgo.Describe("Scheduled exports", func() {
+
+	var manager Manager
+	var store *utils.FileScheduleStore
+
+	ginkgo.BeforeEach(func() {
+		gomega.Expect(os.MkdirAll(schedulerTestDir, os.ModePerm)).To(gomega.Succeed())
+		var err error
+		store, err = utils.NewFileScheduleStore(utils.GetSchedulesPath(schedulerTestDir))
+		gomega.Expect(err).To(gomega.Succeed())
+		// the scheduler of the manager is not started, the tests run the schedules
+		manager = Manager{
+			appManagerClient:  newFakeLoggingClient(10),
+			opeCache:          utils.NewDownloadCache("/test/", "nalej.tech"),
+			DownloadDirectory: schedulerTestDir,
+			running:           newRunningOperations(),
+			quotas:            &quotas{},
+			disk:              newDiskWatchdog(schedulerTestDir, utils.DiskLimits{}),
+			retryPolicy:       utils.RetryPolicy{MaxAttempts: 1},
+			shards:            1,
+			schedules:         newScheduler(store),
+		}
+		manager.dispatcher = newDispatcher(newFairQueue(utils.NewSchedulingWeights(1), false), 0, 10, func(j *job) {})
+	})
+	ginkgo.AfterEach(func() {
+		gomega.Expect(os.RemoveAll(schedulerTestDir)).To(gomega.Succeed())
+	})
+
+	create := func() *grpc_log_download_manager_go.Schedule {
+		schedule, err := manager.CreateSchedule(&grpc_log_download_manager_go.CreateScheduleRequest{
+			OrganizationId: "org",
+			Cron:           "0 * * * *",
+			Request: &grpc_log_download_manager_go.DownloadLogRequest{
+				AppInstanceId: "app",
+				Order:         &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_ASC},
+			},
+			Window: grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_HOUR,
+		}, "owner")
+		gomega.Expect(err).To(gomega.Succeed())
+		return schedule
+	}
+
+	ginkgo.It("should create the operations of the due schedules", func() {
+		created := create()
+		gomega.Expect(created.NextRun).Should(gomega.BeNumerically(">", time.Now().UnixNano()))
+		gomega.Expect(created.Owner).Should(gomega.Equal("owner"))
+
+		// not due yet
+		gomega.Expect(manager.runDueSchedules(time.Now())).Should(gomega.Equal(created.NextRun))
+
+		at := time.Unix(0, created.NextRun)
+		next := manager.runDueSchedules(at)
+		gomega.Expect(next).Should(gomega.Equal(at.Add(time.Hour).UnixNano()))
+
+		schedule, err := store.Get(created.ScheduleId)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(schedule.LastError).Should(gomega.BeEmpty())
+		gomega.Expect(schedule.LastRequestId).ShouldNot(gomega.BeEmpty())
+		ope, err := manager.opeCache.Get(schedule.LastRequestId)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.UserId).Should(gomega.Equal("owner"))
+		gomega.Expect(ope.From).Should(gomega.Equal(at.Add(-time.Hour).UnixNano()))
+		gomega.Expect(ope.To).Should(gomega.Equal(at.UnixNano() - 1))
+	})
+
+	ginkgo.It("should not run the paused schedules", func() {
+		created := create()
+		id := &grpc_log_download_manager_go.ScheduleId{OrganizationId: "org", ScheduleId: created.ScheduleId}
+		paused, err := manager.PauseSchedule(id, "owner")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(paused.Paused).Should(gomega.BeTrue())
+		gomega.Expect(manager.runDueSchedules(time.Unix(0, created.NextRun))).Should(gomega.Equal(int64(0)))
+
+		resumed, err := manager.ResumeSchedule(id, "owner")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(resumed.NextRun).Should(gomega.Equal(created.NextRun))
+	})
+
+	ginkgo.It("should only let the owner change the schedules", func() {
+		created := create()
+		id := &grpc_log_download_manager_go.ScheduleId{OrganizationId: "org", ScheduleId: created.ScheduleId}
+		_, err := manager.PauseSchedule(id, "other")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		_, err = manager.DeleteSchedule(&grpc_log_download_manager_go.ScheduleId{OrganizationId: "other", ScheduleId: created.ScheduleId}, "owner")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		list, err := manager.ListSchedules(&grpc_organization_go.OrganizationId{OrganizationId: "org"}, "other")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(list.Schedules).Should(gomega.BeEmpty())
+
+		_, err = manager.DeleteSchedule(id, "owner")
+		gomega.Expect(err).To(gomega.Succeed())
+		list, err = manager.ListSchedules(&grpc_organization_go.OrganizationId{OrganizationId: "org"}, "owner")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(list.Schedules).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should reject the schedules that never run", func() {
+		_, err := manager.CreateSchedule(&grpc_log_download_manager_go.CreateScheduleRequest{
+			OrganizationId: "org",
+			Cron:           "0 0 30 2 *",
+			Request:        &grpc_log_download_manager_go.DownloadLogRequest{},
+			Window:         grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_DAY,
+		}, "owner")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+})
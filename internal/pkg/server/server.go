@@ -17,6 +17,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/nalej/derrors"
@@ -30,14 +31,24 @@ import (
 	"google.golang.org/grpc/reflection"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 const PathPrefix = "/v1/logs/download/"
 
+// CancelPathPrefix is the http path to cancel an operation (/v1/logs/cancel/<request_id>)
+const CancelPathPrefix = "/v1/logs/cancel/"
+
+// ShutdownTimeout is the time the servers wait for the requests in progress when the service stops
+const ShutdownTimeout = 20 * time.Second
+
 // Service structure with the configuration and the gRPC server.
 type Service struct {
 	Configuration Config
-	OpeCache      *utils.DownloadCache
+	OpeCache      utils.OperationStore
 	Router        *mux.Router
 }
 
@@ -46,7 +57,6 @@ func NewService(conf Config) *Service {
 	return &Service{
 		Configuration: conf,
 		Router:        mux.NewRouter(),
-		OpeCache:      utils.NewDownloadCache(PathPrefix, conf.ManagementPublicHost),
 	}
 }
 
@@ -66,20 +76,10 @@ func (s *Service) GetClients() (*Clients, derrors.Error) {
 
 	return &Clients{appManagerClient}, nil
 }
-func (s *Service) LaunchGRPC() error {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.Configuration.Port))
-	if err != nil {
-		log.Fatal().Errs("failed to listen: %v", []error{err})
-	}
-
-	// Clients
-	clients, cErr := s.GetClients()
-	if cErr != nil {
-		log.Fatal().Str("err", cErr.DebugReport()).Msg("Cannot create clients")
-	}
 
+// newGRPCServer creates the gRPC server with the handlers of the manager
+func (s *Service) newGRPCServer(appManager *log_manager.Manager) *grpc.Server {
 	// Create handlers
-	appManager := log_manager.NewManager(clients.AppManagerClient, s.OpeCache, s.Configuration.DownloadPath)
 	appHandler := log_manager.NewHandler(appManager)
 
 	grpcServer := grpc.NewServer()
@@ -87,28 +87,74 @@ func (s *Service) LaunchGRPC() error {
 
 	// Register reflection service on gRPC server.
 	reflection.Register(grpcServer)
+	return grpcServer
+}
+
+// LaunchGRPC serves the gRPC requests until the server is stopped
+func (s *Service) LaunchGRPC(grpcServer *grpc.Server) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", s.Configuration.Port))
+	if err != nil {
+		return derrors.AsError(err, "failed to listen")
+	}
+
 	log.Info().Int("port", s.Configuration.Port).Msg("Launching gRPC server")
 	if err := grpcServer.Serve(lis); err != nil {
-		log.Fatal().Errs("failed to serve: %v", []error{err})
+		return derrors.AsError(err, "failed to serve")
 	}
 	return nil
 }
 
-func (s *Service) LaunchHTTP() error {
+// newHTTPServer creates the http server downloading the files and cancelling the operations
+func (s *Service) newHTTPServer(canceller http_log_manager.Canceller) *http.Server {
 
-	httpLogManager := http_log_manager.NewManager(s.OpeCache, s.Configuration.AuthSecret, s.Configuration.AuthHeader, PathPrefix, s.Configuration.DownloadPath)
+	httpLogManager := http_log_manager.NewManager(s.OpeCache, canceller, s.Configuration.AuthSecret, s.Configuration.AuthHeader,
+		PathPrefix, CancelPathPrefix, s.Configuration.DownloadPath)
 	httpLogHandler := http_log_manager.NewHandler(httpLogManager)
 
 	//s.Router.PathPrefix(PathPrefix).Handler(httpLogHandler.DownloadFile(PathPrefix, http.FileServer(http.Dir(s.Configuration.DownloadPath))))
 	s.Router.PathPrefix(PathPrefix).Handler(httpLogHandler.DownloadFile())
+	s.Router.PathPrefix(CancelPathPrefix).Handler(httpLogHandler.CancelOperation())
 
+	return &http.Server{Addr: fmt.Sprintf(":%d", s.Configuration.HttpPort), Handler: s.Router}
+}
+
+// LaunchHTTP serves the http requests until the server is shut down
+func (s *Service) LaunchHTTP(httpServer *http.Server) error {
 	log.Info().Int("port", s.Configuration.HttpPort).Msg("Launching Http server")
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", s.Configuration.HttpPort), s.Router); err != nil {
-		log.Fatal().Errs("failed to serve: %v", []error{err})
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return derrors.AsError(err, "failed to serve")
 	}
 	return nil
 }
 
+// shutdown stops the servers waiting for the requests in progress, then the manager, interrupting the
+// generations to resume them in the next execution, and finally closes the operations store
+func (s *Service) shutdown(httpServer *http.Server, grpcServer *grpc.Server, appManager *log_manager.Manager) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Warn().Str("err", err.Error()).Msg("error stopping the http server")
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Warn().Msg("gRPC requests in progress aborted")
+		grpcServer.Stop()
+	}
+
+	appManager.Stop()
+	if err := s.OpeCache.Close(); err != nil {
+		log.Warn().Str("err", err.Error()).Msg("error closing the download operations")
+	}
+	log.Info().Msg("service stopped")
+}
+
 func (s *Service) Run() error {
 	// Configuration
 	cErr := s.Configuration.Validate()
@@ -117,6 +163,73 @@ func (s *Service) Run() error {
 	}
 	s.Configuration.Print()
 
-	go s.LaunchGRPC()
-	return s.LaunchHTTP()
+	policies, err := s.Configuration.GetRetentionPolicies()
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot load the retention policies")
+	}
+
+	weights, err := s.Configuration.GetSchedulingWeights()
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot load the scheduling weights")
+	}
+
+	// Operations store, loading the operations stored in previous executions
+	opeCache, err := utils.NewOperationStore(s.Configuration.OperationStore, PathPrefix, s.Configuration.ManagementPublicHost,
+		s.Configuration.DownloadPath, s.Configuration.SQLitePath, policies)
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot load the download operations")
+	}
+	s.OpeCache = opeCache
+
+	// Clean the files left by previous executions
+	report, err := utils.Reconcile(s.OpeCache, s.Configuration.DownloadPath)
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot reconcile the download directory")
+	}
+	report.Print()
+
+	// Scheduled exports
+	schedulesPath := s.Configuration.SchedulesPath
+	if schedulesPath == "" {
+		schedulesPath = utils.GetSchedulesPath(s.Configuration.DownloadPath)
+	}
+	schedules, err := utils.NewFileScheduleStore(schedulesPath)
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("cannot load the schedules")
+	}
+
+	// Clients
+	clients, err := s.GetClients()
+	if err != nil {
+		log.Fatal().Str("err", err.DebugReport()).Msg("Cannot create clients")
+	}
+
+	// Managers
+	appManager := log_manager.NewManager(clients.AppManagerClient, s.OpeCache, s.Configuration.GetManagerOptions(weights, schedules))
+	appManager.Resume(report.Resumable)
+
+	// Servers, the service stops when it is terminated or a server fails
+	grpcServer := s.newGRPCServer(appManager)
+	httpServer := s.newHTTPServer(appManager)
+	failed := make(chan error, 2)
+	go func() {
+		failed <- s.LaunchGRPC(grpcServer)
+	}()
+	go func() {
+		failed <- s.LaunchHTTP(httpServer)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(signals)
+
+	var result error
+	select {
+	case received := <-signals:
+		log.Info().Str("signal", received.String()).Msg("stopping the service")
+	case result = <-failed:
+		log.Error().Str("err", fmt.Sprint(result)).Msg("server failed, stopping the service")
+	}
+	s.shutdown(httpServer, grpcServer, appManager)
+	return result
 }
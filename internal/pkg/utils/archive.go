@@ -0,0 +1,195 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"io"
+	"os"
+	"strings"
+)
+
+// ZipExtension is the extension of the zip archives, the archive of the operations that do not set one
+const ZipExtension = ".zip"
+
+// archiveFormat contains the extension and the Content-Type of the files of an archive format
+type archiveFormat struct {
+	extension   string
+	contentType string
+}
+
+// archiveFormats contains the supported archive formats
+var archiveFormats = map[grpc_log_download_manager_go.ArchiveFormat]archiveFormat{
+	grpc_log_download_manager_go.ArchiveFormat_ZIP:     {extension: ZipExtension, contentType: "application/zip"},
+	grpc_log_download_manager_go.ArchiveFormat_TAR_GZ:  {extension: ".tar.gz", contentType: "application/gzip"},
+	grpc_log_download_manager_go.ArchiveFormat_TAR_ZST: {extension: ".tar.zst", contentType: "application/zstd"},
+}
+
+// ValidArchiveFormat checks if the archive format is supported
+func ValidArchiveFormat(format grpc_log_download_manager_go.ArchiveFormat) bool {
+	_, exists := archiveFormats[format]
+	return exists
+}
+
+// ArchiveExtension returns the extension of the files of an archive format
+func ArchiveExtension(format grpc_log_download_manager_go.ArchiveFormat) string {
+	return archiveFormats[format].extension
+}
+
+// ArchiveExtensions returns the extensions of all the archive formats
+func ArchiveExtensions() []string {
+	extensions := make([]string, 0, len(archiveFormats))
+	for _, format := range archiveFormats {
+		extensions = append(extensions, format.extension)
+	}
+	return extensions
+}
+
+// archiveExtension returns the extension of the archive format of the file name, empty if it is not an archive
+func archiveExtension(name string) string {
+	for _, format := range archiveFormats {
+		if strings.HasSuffix(name, format.extension) {
+			return format.extension
+		}
+	}
+	return ""
+}
+
+// ArchiveContentType returns the Content-Type of an archive file, empty if the name has not the extension
+// of an archive format
+func ArchiveContentType(name string) string {
+	for _, format := range archiveFormats {
+		if strings.HasSuffix(name, format.extension) {
+			return format.contentType
+		}
+	}
+	return ""
+}
+
+// GetArchiveFilePath returns the path of the archive of an operation with the given extension
+func GetArchiveFilePath(filesDirectory string, requestId string, extension string) string {
+	return fmt.Sprintf("%s%s%s", filesDirectory, requestId, extension)
+}
+
+// ArchiveWriter adds files to an archive
+type ArchiveWriter interface {
+	// Add writes the content of the file in the archive
+	Add(file ArchiveFile) error
+	// Close completes the archive, the underlying writer is not closed
+	Close() error
+}
+
+// NewArchiveWriter creates an ArchiveWriter of the given format writing in w
+func NewArchiveWriter(w io.Writer, format grpc_log_download_manager_go.ArchiveFormat) (ArchiveWriter, error) {
+	switch format {
+	case grpc_log_download_manager_go.ArchiveFormat_ZIP:
+		return &zipArchiveWriter{writer: zip.NewWriter(w)}, nil
+	case grpc_log_download_manager_go.ArchiveFormat_TAR_GZ:
+		return newTarArchiveWriter(gzip.NewWriter(w)), nil
+	case grpc_log_download_manager_go.ArchiveFormat_TAR_ZST:
+		compressor, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return newTarArchiveWriter(compressor), nil
+	}
+	return nil, fmt.Errorf("unsupported archive format %s", format.String())
+}
+
+// WriteArchive creates an archive of the given format with the files, naming each one as requested
+func WriteArchive(filename string, format grpc_log_download_manager_go.ArchiveFormat, files []ArchiveFile) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	writer, err := NewArchiveWriter(f, format)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, file := range files {
+		if err := writer.Add(file); err != nil {
+			writer.Close()
+			f.Close()
+			return err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// zipArchiveWriter writes zip archives
+type zipArchiveWriter struct {
+	writer *zip.Writer
+}
+
+func (z *zipArchiveWriter) Add(file ArchiveFile) error {
+	return addNamedFileToZip(z.writer, file.Path, file.Name)
+}
+
+func (z *zipArchiveWriter) Close() error {
+	return z.writer.Close()
+}
+
+// tarArchiveWriter writes tar archives compressed by the compressor
+type tarArchiveWriter struct {
+	writer     *tar.Writer
+	compressor io.WriteCloser
+}
+
+func newTarArchiveWriter(compressor io.WriteCloser) *tarArchiveWriter {
+	return &tarArchiveWriter{writer: tar.NewWriter(compressor), compressor: compressor}
+}
+
+func (t *tarArchiveWriter) Add(file ArchiveFile) error {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = file.Name
+	if err := t.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(t.writer, f)
+	return err
+}
+
+func (t *tarArchiveWriter) Close() error {
+	if err := t.writer.Close(); err != nil {
+		t.compressor.Close()
+		return err
+	}
+	return t.compressor.Close()
+}
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const archiveTestDir = "./archiveTestDir/"
+
+// readArchive returns the content of the files of an archive by name, in the order they were added
+func readArchive(path string, format grpc_log_download_manager_go.ArchiveFormat) ([]string, map[string]string) {
+	names := make([]string, 0)
+	contents := make(map[string]string, 0)
+	if format == grpc_log_download_manager_go.ArchiveFormat_ZIP {
+		reader, err := zip.OpenReader(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		defer reader.Close()
+		for _, zipped := range reader.File {
+			file, err := zipped.Open()
+			gomega.Expect(err).To(gomega.Succeed())
+			content, err := ioutil.ReadAll(file)
+			gomega.Expect(err).To(gomega.Succeed())
+			file.Close()
+			names = append(names, zipped.Name)
+			contents[zipped.Name] = string(content)
+		}
+		return names, contents
+	}
+
+	f, err := os.Open(path)
+	gomega.Expect(err).To(gomega.Succeed())
+	defer f.Close()
+	var decompressed io.Reader
+	if format == grpc_log_download_manager_go.ArchiveFormat_TAR_GZ {
+		gz, err := gzip.NewReader(f)
+		gomega.Expect(err).To(gomega.Succeed())
+		defer gz.Close()
+		decompressed = gz
+	} else {
+		zst, err := zstd.NewReader(f)
+		gomega.Expect(err).To(gomega.Succeed())
+		defer zst.Close()
+		decompressed = zst
+	}
+	reader := tar.NewReader(decompressed)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		gomega.Expect(err).To(gomega.Succeed())
+		content, err := ioutil.ReadAll(reader)
+		gomega.Expect(err).To(gomega.Succeed())
+		names = append(names, header.Name)
+		contents[header.Name] = string(content)
+	}
+	return names, contents
+}
+
+var _ = ginkgo.Describe("Archive formats", func() {
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(archiveTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(archiveTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should round trip the files in every format", func() {
+		first := GetTargetFilePath(archiveTestDir, "id", 0)
+		second := GetTargetFilePath(archiveTestDir, "id", 1)
+		gomega.Expect(WriteFile(first, "first\nentries\n")).To(gomega.Succeed())
+		gomega.Expect(WriteFile(second, "")).To(gomega.Succeed())
+		files := []ArchiveFile{{Path: first, Name: "shop-cart.log"}, {Path: second, Name: "shop-web.log"}}
+
+		for format := range archiveFormats {
+			path := GetArchiveFilePath(archiveTestDir, "id", ArchiveExtension(format))
+			gomega.Expect(WriteArchive(path, format, files)).To(gomega.Succeed())
+
+			names, contents := readArchive(path, format)
+			gomega.Expect(names).Should(gomega.Equal([]string{"shop-cart.log", "shop-web.log"}), format.String())
+			gomega.Expect(contents["shop-cart.log"]).Should(gomega.Equal("first\nentries\n"), format.String())
+			gomega.Expect(contents["shop-web.log"]).Should(gomega.BeEmpty(), format.String())
+		}
+	})
+
+	ginkgo.It("should fail with an unknown format or a missing file", func() {
+		path := GetArchiveFilePath(archiveTestDir, "id", ZipExtension)
+		err := WriteArchive(path, grpc_log_download_manager_go.ArchiveFormat(10), []ArchiveFile{})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		err = WriteArchive(path, grpc_log_download_manager_go.ArchiveFormat_TAR_GZ,
+			[]ArchiveFile{{Path: GetFilePath(archiveTestDir, "missing"), Name: "missing.log"}})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should name the extension and the content type of each format", func() {
+		gomega.Expect(ArchiveExtension(grpc_log_download_manager_go.ArchiveFormat_ZIP)).Should(gomega.Equal(".zip"))
+		gomega.Expect(ArchiveExtension(grpc_log_download_manager_go.ArchiveFormat_TAR_GZ)).Should(gomega.Equal(".tar.gz"))
+		gomega.Expect(ArchiveExtension(grpc_log_download_manager_go.ArchiveFormat_TAR_ZST)).Should(gomega.Equal(".tar.zst"))
+		gomega.Expect(ArchiveContentType("id.zip")).Should(gomega.Equal("application/zip"))
+		gomega.Expect(ArchiveContentType("id.tar.gz")).Should(gomega.Equal("application/gzip"))
+		gomega.Expect(ArchiveContentType("id.tar.zst")).Should(gomega.Equal("application/zstd"))
+		gomega.Expect(ArchiveContentType("id.file")).Should(gomega.BeEmpty())
+		gomega.Expect(ValidArchiveFormat(grpc_log_download_manager_go.ArchiveFormat(10))).Should(gomega.BeFalse())
+	})
+})
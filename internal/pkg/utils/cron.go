@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/derrors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMacros are the shortcuts of the most common expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSearchLimit is how far the next time of an expression is searched, the expressions without matches
+// in that period (e.g. the 30th of February) never fire
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// CronExpression is a parsed cron expression with the standard five fields: minute, hour, day of the month,
+// month and day of the week. The fields accept lists, ranges and steps (e.g. 0,30 1-5 */2), the days of the
+// week go from 0 (Sunday) to 6 and 7 is also Sunday.
+type CronExpression struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	// when both days are restricted, a time matches if any of them does
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+// ParseCron parses a cron expression
+func ParseCron(expression string) (*CronExpression, derrors.Error) {
+	trimmed := strings.TrimSpace(expression)
+	if macro, exists := cronMacros[trimmed]; exists {
+		trimmed = macro
+	}
+	fields := strings.Fields(trimmed)
+	if len(fields) != 5 {
+		return nil, derrors.NewInvalidArgumentError("a cron expression must have five fields").WithParams(expression)
+	}
+	bounds := [][]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	values := make([]uint64, 5)
+	for i, field := range fields {
+		value, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	// 7 is Sunday too
+	if values[4]&(1<<7) != 0 {
+		values[4] = values[4]&^(1<<7) | 1
+	}
+	return &CronExpression{
+		minute:        values[0],
+		hour:          values[1],
+		dayOfMonth:    values[2],
+		month:         values[3],
+		dayOfWeek:     values[4],
+		anyDayOfMonth: fields[2] == "*",
+		anyDayOfWeek:  fields[4] == "*",
+	}, nil
+}
+
+// parseCronField returns the set of values (as bits) of a field
+func parseCronField(field string, min int, max int) (uint64, derrors.Error) {
+	var result uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			parsed, err := strconv.Atoi(part[index+1:])
+			if err != nil || parsed <= 0 {
+				return 0, derrors.NewInvalidArgumentError("invalid step in cron field").WithParams(field)
+			}
+			step = parsed
+			part = part[:index]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			parsed, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, derrors.NewInvalidArgumentError("invalid value in cron field").WithParams(field)
+			}
+			low, high = parsed, parsed
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, derrors.NewInvalidArgumentError("invalid range in cron field").WithParams(field)
+				}
+			} else if step > 1 {
+				// a/n means from a to the maximum
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, derrors.NewInvalidArgumentError("cron field out of range").WithParams(field, min, max)
+		}
+		for value := low; value <= high; value += step {
+			result |= 1 << uint(value)
+		}
+	}
+	return result, nil
+}
+
+// matchesDay checks the day of the month and the day of the week of a time
+func (c *CronExpression) matchesDay(t time.Time) bool {
+	dayOfMonth := c.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first time after the given one matching the expression, in the location of the given time.
+// The zero time is returned when the expression does not match any time in the next years.
+func (c *CronExpression) Next(after time.Time) time.Time {
+	location := after.Location()
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(cronSearchLimit)
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
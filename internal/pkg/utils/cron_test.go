@@ -0,0 +1,69 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Cron expressions", func() {
+
+	// next returns the next time of an expression after the given UTC time
+	next := func(expression string, after time.Time) time.Time {
+		cron, err := ParseCron(expression)
+		gomega.Expect(err).To(gomega.Succeed())
+		return cron.Next(after)
+	}
+	start := time.Date(2019, time.March, 14, 10, 30, 15, 0, time.UTC)
+
+	ginkgo.It("should reject invalid expressions", func() {
+		for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+			_, err := ParseCron(expression)
+			gomega.Expect(err).NotTo(gomega.Succeed(), expression)
+		}
+	})
+
+	ginkgo.It("should return the next matching minute", func() {
+		gomega.Expect(next("* * * * *", start)).Should(gomega.Equal(time.Date(2019, time.March, 14, 10, 31, 0, 0, time.UTC)))
+		gomega.Expect(next("*/15 * * * *", start)).Should(gomega.Equal(time.Date(2019, time.March, 14, 10, 45, 0, 0, time.UTC)))
+		gomega.Expect(next("0 2 * * *", start)).Should(gomega.Equal(time.Date(2019, time.March, 15, 2, 0, 0, 0, time.UTC)))
+		gomega.Expect(next("@daily", start)).Should(gomega.Equal(time.Date(2019, time.March, 15, 0, 0, 0, 0, time.UTC)))
+		gomega.Expect(next("0 0 1 1,6 *", start)).Should(gomega.Equal(time.Date(2019, time.June, 1, 0, 0, 0, 0, time.UTC)))
+		gomega.Expect(next("30 10 14 3 *", start)).Should(gomega.Equal(time.Date(2020, time.March, 14, 10, 30, 0, 0, time.UTC)))
+	})
+
+	ginkgo.It("should match the days of the week", func() {
+		// 2019-03-14 is Thursday
+		gomega.Expect(next("0 9 * * 1-5", start)).Should(gomega.Equal(time.Date(2019, time.March, 15, 9, 0, 0, 0, time.UTC)))
+		gomega.Expect(next("0 0 * * 7", start)).Should(gomega.Equal(time.Date(2019, time.March, 17, 0, 0, 0, 0, time.UTC)))
+		// when both days are restricted any of them matches
+		gomega.Expect(next("0 0 20 * 6", start)).Should(gomega.Equal(time.Date(2019, time.March, 16, 0, 0, 0, 0, time.UTC)))
+	})
+
+	ginkgo.It("should not fire when no time matches", func() {
+		gomega.Expect(next("0 0 30 2 *", start).IsZero()).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should use the location of the given time", func() {
+		location := time.FixedZone("UTC+5:30", 5*3600+1800)
+		result := next("0 * * * *", start.In(location))
+		gomega.Expect(result.In(location).Minute()).Should(gomega.Equal(0))
+		gomega.Expect(result.Equal(time.Date(2019, time.March, 14, 11, 30, 0, 0, time.UTC))).Should(gomega.BeTrue())
+	})
+})
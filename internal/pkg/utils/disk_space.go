@@ -0,0 +1,54 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/derrors"
+	"syscall"
+	"time"
+)
+
+// DiskLimits defines the free space required in the download directory
+type DiskLimits struct {
+	// LowWater is the free space (bytes) below which new operations are refused
+	LowWater uint64
+	// Critical is the free space (bytes) below which the largest generation is stopped
+	Critical uint64
+	// Interval is the time between two checks of the free space
+	Interval time.Duration
+}
+
+// Validate checks that the critical mark is below the low-water mark and the interval is positive
+func (d DiskLimits) Validate() derrors.Error {
+	if d.Critical > d.LowWater {
+		return derrors.NewInvalidArgumentError("the critical mark cannot be greater than the low-water mark").
+			WithParams(d.Critical, d.LowWater)
+	}
+	if d.Interval <= 0 {
+		return derrors.NewInvalidArgumentError("the disk check interval must be positive").WithParams(d.Interval.String())
+	}
+	return nil
+}
+
+// FreeSpace returns the bytes available to the process in the filesystem containing the path
+func FreeSpace(path string) (uint64, derrors.Error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, derrors.AsErrorWithParams(err, "cannot read the free space", path)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
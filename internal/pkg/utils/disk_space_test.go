@@ -0,0 +1,41 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Disk space", func() {
+
+	ginkgo.It("should read the free space of a directory", func() {
+		free, err := FreeSpace(".")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(free).Should(gomega.BeNumerically(">", 0))
+
+		_, err = FreeSpace("./notExistingDirectory/")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should validate the limits", func() {
+		gomega.Expect(DiskLimits{LowWater: 100, Critical: 10, Interval: time.Second}.Validate()).To(gomega.Succeed())
+		gomega.Expect(DiskLimits{LowWater: 10, Critical: 100, Interval: time.Second}.Validate()).NotTo(gomega.Succeed())
+		gomega.Expect(DiskLimits{LowWater: 100, Critical: 10}.Validate()).NotTo(gomega.Succeed())
+	})
+})
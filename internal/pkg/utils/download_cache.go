@@ -17,7 +17,6 @@
 package utils
 
 import (
-	"fmt"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-log-download-manager-go"
 	"github.com/rs/zerolog/log"
@@ -28,12 +27,10 @@ import (
 type DownloadLogState int
 
 const (
-	// ExpirationTime time the file is ready to download
+	// ExpirationTime default time the file is ready to download
 	ExpirationTime = 10 * time.Minute
 	ExpiredMsg     = "Expired"
-	// ReviewTime time to check the status of the operations
-	ReviewTime = 2 * time.Minute
-	// AliveTime time the operation is stored
+	// AliveTime default time the operation is stored once it cannot be downloaded
 	AliveTime = ExpirationTime + 2*time.Minute
 )
 
@@ -43,6 +40,7 @@ const (
 	Ready
 	Error
 	Downloaded
+	Cancelled
 )
 
 var DownloadLogStateFromGRPC = map[grpc_log_download_manager_go.DownloadLogState]DownloadLogState{
@@ -51,6 +49,7 @@ var DownloadLogStateFromGRPC = map[grpc_log_download_manager_go.DownloadLogState
 	grpc_log_download_manager_go.DownloadLogState_READY:      Ready,
 	grpc_log_download_manager_go.DownloadLogState_DOWNLOADED: Downloaded,
 	grpc_log_download_manager_go.DownloadLogState_ERROR:      Error,
+	grpc_log_download_manager_go.DownloadLogState_CANCELLED:  Cancelled,
 }
 
 var DownloadLogStateToGRPC = map[DownloadLogState]grpc_log_download_manager_go.DownloadLogState{
@@ -59,6 +58,7 @@ var DownloadLogStateToGRPC = map[DownloadLogState]grpc_log_download_manager_go.D
 	Ready:      grpc_log_download_manager_go.DownloadLogState_READY,
 	Downloaded: grpc_log_download_manager_go.DownloadLogState_DOWNLOADED,
 	Error:      grpc_log_download_manager_go.DownloadLogState_ERROR,
+	Cancelled:  grpc_log_download_manager_go.DownloadLogState_CANCELLED,
 }
 
 func (d DownloadLogState) ToString() string {
@@ -83,10 +83,19 @@ func (d DownloadLogState) ToString() string {
 		{
 			return "DOWNLOADED"
 		}
+	case Cancelled:
+		{
+			return "CANCELLED"
+		}
 	}
 	return ""
 }
 
+// IsFinal checks if the generation of the operation has finished
+func (d DownloadLogState) IsFinal() bool {
+	return d == Ready || d == Error || d == Downloaded || d == Cancelled
+}
+
 type DownloadOperation struct {
 	OrganizationId string
 	RequestId      string
@@ -99,6 +108,148 @@ type DownloadOperation struct {
 	Url            string
 	Directory      string
 	UserId         string
+	// Retention is the time (ns) when the operation is removed, 0 until the operation finishes
+	Retention int64
+	// Progress of the generation of the file
+	Progress GenerationProgress
+	// Checkpoint to resume the generation, nil once the operation finishes
+	Checkpoint *Checkpoint
+	// Fingerprint of the request, empty if the artifact cannot be reused by other requests
+	Fingerprint string
+	// Archive is the extension of the archive file, empty for the zip archives
+	Archive string
+	// Generated is the time (ns) the entries of the artifact started to be retrieved, 0 until then
+	Generated int64
+}
+
+// ArchiveExtension returns the extension of the archive file of the operation
+func (d *DownloadOperation) ArchiveExtension() string {
+	if d.Archive == "" {
+		return ZipExtension
+	}
+	return d.Archive
+}
+
+// ArchivePath returns the path of the archive file of the operation
+func (d *DownloadOperation) ArchivePath() string {
+	return GetArchiveFilePath(d.Directory, d.RequestId, d.ArchiveExtension())
+}
+
+// Checkpoint is the state needed to resume the generation of an operation after a restart
+type Checkpoint struct {
+	// Request is the download request
+	Request *grpc_log_download_manager_go.DownloadLogRequest
+	// End is the end (ns) of the window, resolved when the request does not set it
+	End int64
+	// Bytes is the size of the file when the checkpoint was taken
+	Bytes int64
+	// Shards contains the state of each part of the window, they are fetched in parallel
+	Shards []ShardCheckpoint
+	// Truncated is the reason why the generation does not contain all the entries of the request, if any
+	Truncated string
+}
+
+// ShardCheckpoint is the state of the generation of a part of the window, written in its own segment file
+type ShardCheckpoint struct {
+	// Start and End delimit the part of the window (ns) of the shard
+	Start int64
+	End   int64
+	// From and To delimit the window (ns) of the next Search call
+	From int64
+	To   int64
+	// Bytes is the size of the segment file (or of its last part) when the checkpoint was taken
+	Bytes int64
+	// Seen contains the keys of the entries already written with the timestamp where the next page starts
+	Seen []string
+	// Done is set once all the entries of the shard are written
+	Done bool
+	// Target is the index of the target of the request whose entries are fetched by the shard
+	Target int
+	// Part is the index of the part of the segment being written, PartEntries its entries and PartsBytes
+	// the size of the previous parts. The segments are only split when the request sets part limits.
+	Part        int
+	PartEntries int64
+	PartsBytes  int64
+}
+
+// NewCheckpoint creates the first checkpoint of a generation, splitting the window [from, end] in
+// (at most) the given number of shards of the same length for each target of the request. Bytes is the size of the file.
+func NewCheckpoint(request *grpc_log_download_manager_go.DownloadLogRequest, from int64, end int64, shards int, bytes int64) Checkpoint {
+	width := end - from + 1
+	if width < int64(shards) {
+		shards = int(width)
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	size := width / int64(shards)
+	targets := 1
+	if request != nil && len(request.Targets) > 0 {
+		targets = len(request.Targets)
+	}
+
+	checkpoint := Checkpoint{Request: request, End: end, Bytes: bytes, Shards: make([]ShardCheckpoint, 0, shards*targets)}
+	for target := 0; target < targets; target++ {
+		for i := 0; i < shards; i++ {
+			start := from + int64(i)*size
+			shardEnd := start + size - 1
+			if i == shards-1 {
+				shardEnd = end
+			}
+			checkpoint.Shards = append(checkpoint.Shards, ShardCheckpoint{Start: start, End: shardEnd, From: start, To: shardEnd, Target: target})
+		}
+	}
+	return checkpoint
+}
+
+// GenerationProgress contains the work done generating the file of an operation
+type GenerationProgress struct {
+	// Entries is the number of log entries written
+	Entries int64
+	// Bytes is the size of the file
+	Bytes int64
+	// Pages is the number of Search pages fetched
+	Pages int
+	// Covered is the fraction (0 to 1) of the [From, To] window already covered
+	Covered float64
+	// Retries is the number of failed Search calls that have been retried
+	Retries int
+	// Targets contains the entries written for each target of the request, empty if it has no targets
+	Targets []TargetProgress
+}
+
+// IsArtifactExpired checks if the ready window of the zip file is over
+func (d *DownloadOperation) IsArtifactExpired(now time.Time) bool {
+	return d.State == Ready && d.Expiration < now.UnixNano()
+}
+
+// retentionTime returns the time (ns) when the finished operation is removed
+func (d *DownloadOperation) retentionTime(policy RetentionPolicy) int64 {
+	if d.Retention == 0 {
+		// operations stored before the retention was tracked
+		return time.Unix(0, d.Started).Add(policy.MetadataRetention).UnixNano()
+	}
+	return d.Retention
+}
+
+// IsExpired checks if the operation has finished and its retention is over
+func (d *DownloadOperation) IsExpired(now time.Time, policy RetentionPolicy) bool {
+	if !d.State.IsFinal() {
+		return false
+	}
+	return d.retentionTime(policy) < now.UnixNano()
+}
+
+// NextDeadline returns the next time (ns) when the artifact or the operation expires. Operations that
+// have not finished yet have no deadline.
+func (d *DownloadOperation) NextDeadline(now time.Time, policy RetentionPolicy) (int64, bool) {
+	if !d.State.IsFinal() {
+		return 0, false
+	}
+	if d.State == Ready && !d.IsArtifactExpired(now) {
+		return d.Expiration, true
+	}
+	return d.retentionTime(policy), true
 }
 
 func (d *DownloadOperation) ToGRPC() *grpc_log_download_manager_go.DownloadLogResponse {
@@ -111,64 +262,157 @@ func (d *DownloadOperation) ToGRPC() *grpc_log_download_manager_go.DownloadLogRe
 		Url:            d.Url,
 		Expiration:     d.Expiration,
 		Info:           d.Info,
+		Retention:      d.Retention,
+		EntriesWritten: d.Progress.Entries,
+		BytesWritten:   d.Progress.Bytes,
+		PagesFetched:   int32(d.Progress.Pages),
+		Progress:       d.Progress.Covered,
+		SearchRetries:  int32(d.Progress.Retries),
+		TargetEntries:  TargetsToGRPC(d.Progress.Targets),
 	}
 }
 
+// DownloadCache is the OperationStore that keeps the operations in memory, optionally journaled on disk.
 type DownloadCache struct {
 	sync.Mutex
-	cache map[string]*DownloadOperation
-	url   string
+	cache    map[string]*DownloadOperation
+	url      string
+	policies *RetentionPolicies
+	// scheduler removes the artifacts and the operations when they expire
+	scheduler *ExpiryScheduler
+	// journal to persist the operations, nil if the cache is only stored in memory
+	journal *journal
 }
 
 func NewDownloadCache(url string, publicHost string) *DownloadCache {
 	res := &DownloadCache{
-		cache: make(map[string]*DownloadOperation, 0),
-		url:   fmt.Sprintf("https://web.%s%s", publicHost, url),
+		cache:    make(map[string]*DownloadOperation, 0),
+		url:      getDownloadURL(url, publicHost),
+		policies: NewRetentionPolicies(DefaultRetentionPolicy()),
 	}
-	go res.ReviewOperations()
+	res.scheduler = NewExpiryScheduler(res.onDeadline)
 	return res
 }
 
+// NewPersistentDownloadCache creates a DownloadCache backed by a journal stored in the download directory.
+// The operations stored in a previous execution are loaded from the journal.
+func NewPersistentDownloadCache(url string, publicHost string, directory string, policies *RetentionPolicies) (*DownloadCache, derrors.Error) {
+	j, operations, err := openJournal(GetJournalPath(directory))
+	if err != nil {
+		return nil, err
+	}
+	res := &DownloadCache{
+		cache:    operations,
+		url:      getDownloadURL(url, publicHost),
+		policies: policies,
+		journal:  j,
+	}
+	res.scheduler = NewExpiryScheduler(res.onDeadline)
+	// the deadlines of the restored operations may have been reached while the service was down
+	res.CheckOperations()
+	return res, nil
+}
+
+// persist stores a change of an operation in the journal (if any) and then applies it to the cache. The journal
+// is compacted once the change is applied, so the compacted journal contains it.
+func (d *DownloadCache) persist(action string, requestId string, operation *DownloadOperation, apply func()) derrors.Error {
+	if d.journal == nil {
+		apply()
+		return nil
+	}
+	err := d.journal.append(action, requestId, operation)
+	if err != nil {
+		return err
+	}
+	apply()
+	if d.journal.needsCompaction(len(d.cache)) {
+		if cErr := d.journal.compact(d.cache); cErr != nil {
+			log.Warn().Str("trace", cErr.DebugReport()).Msg("error compacting the operations journal")
+		}
+	}
+	return nil
+}
+
+// Close releases the resources used by the cache
+func (d *DownloadCache) Close() error {
+	// the scheduler is stopped before taking the lock as it may be waiting for it
+	d.scheduler.Stop()
+
+	d.Lock()
+	defer d.Unlock()
+
+	if d.journal == nil {
+		return nil
+	}
+	return d.journal.close()
+}
+
+// CheckOperations reviews all the operations, removing the expired ones and scheduling the rest
 func (d *DownloadCache) CheckOperations() {
 
 	d.Lock()
 	defer d.Unlock()
 
+	now := time.Now()
 	for i, ope := range d.cache {
-		log.Debug().Str("index", i).Interface("operation", ope).Msg("operation")
-		switch ope.State {
-		// case Queue, Generating: nothing to do
-		case Ready:
-			if ope.Expiration < time.Now().UnixNano() {
-				err := RemoveFile(GetZipFilePath(ope.Directory, ope.RequestId))
-				if err != nil {
-					log.Warn().Str("requestId", ope.RequestId).Msg("error deleting zip file")
-				}
-				delete(d.cache, i)
-				log.Debug().Msg("deleted")
-			}
-		case Error, Downloaded:
-			if time.Unix(0, ope.Started).Add(AliveTime).After(time.Now()) {
-				err := RemoveFile(GetZipFilePath(ope.Directory, ope.RequestId))
-				if err != nil {
-					log.Warn().Str("requestId", ope.RequestId).Msg("error deleting zip file")
-				}
-				delete(d.cache, i)
-				log.Debug().Msg("deleted")
-			}
-		}
+		d.review(i, ope, now)
 	}
 }
 
-func (d *DownloadCache) ReviewOperations() {
-	log.Debug().Msg("ReviewOperations")
-	ticker := time.NewTicker(ReviewTime)
-	for {
-		select {
-		case <-ticker.C:
-			d.CheckOperations()
-		}
+// Expire removes the expired operations and their zip files
+func (d *DownloadCache) Expire() derrors.Error {
+	d.CheckOperations()
+	return nil
+}
+
+// onDeadline is called by the scheduler when the deadline of an operation is reached
+func (d *DownloadCache) onDeadline(requestId string) {
+	d.Lock()
+	defer d.Unlock()
+
+	ope, exists := d.cache[requestId]
+	if !exists {
+		return
+	}
+	d.review(requestId, ope, time.Now())
+}
+
+// review removes the expired zip file or operation and schedules its next deadline, the lock must be held
+func (d *DownloadCache) review(requestId string, ope *DownloadOperation, now time.Time) {
+	log.Debug().Str("requestId", requestId).Interface("operation", ope).Msg("reviewing operation")
+	policy := d.policies.Get(ope.OrganizationId)
+	if ope.IsExpired(now, policy) {
+		d.expire(requestId, ope)
+		return
+	}
+	if ope.IsArtifactExpired(now) {
+		removeArtifacts(ope)
+	}
+	d.schedule(requestId, ope, now)
+}
+
+// schedule sets the next deadline of an operation, the lock must be held
+func (d *DownloadCache) schedule(requestId string, ope *DownloadOperation, now time.Time) {
+	at, scheduled := ope.NextDeadline(now, d.policies.Get(ope.OrganizationId))
+	if scheduled {
+		d.scheduler.Schedule(requestId, at)
+	} else {
+		d.scheduler.Cancel(requestId)
+	}
+}
+
+// expire removes the operation and its zip file
+func (d *DownloadCache) expire(requestId string, ope *DownloadOperation) {
+	removeArtifacts(ope)
+	pErr := d.persist(journalRemove, requestId, nil, func() {
+		delete(d.cache, requestId)
+	})
+	if pErr != nil {
+		log.Warn().Str("requestId", ope.RequestId).Str("trace", pErr.DebugReport()).Msg("error persisting the deletion of the operation")
+		delete(d.cache, requestId)
 	}
+	d.scheduler.Cancel(requestId)
+	log.Debug().Msg("deleted")
 }
 
 func (d *DownloadCache) Add(organizationId string, requestId string, from int64, to int64, directory string, userID string) (*DownloadOperation, derrors.Error) {
@@ -190,7 +434,12 @@ func (d *DownloadCache) Add(organizationId string, requestId string, from int64,
 		Directory:      directory,
 		UserId:         userID,
 	}
-	d.cache[requestId] = op
+	err := d.persist(journalAdd, requestId, op, func() {
+		d.cache[requestId] = op
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	return op, nil
 
@@ -210,7 +459,9 @@ func (d *DownloadCache) Get(requestId string) (*DownloadOperation, derrors.Error
 		operation.Info = ExpiredMsg
 	}
 
-	return operation, nil
+	// a copy, the stored operation is updated by the workers
+	result := *operation
+	return &result, nil
 }
 
 func (d *DownloadCache) Update(requestId string, state DownloadLogState, info string) derrors.Error {
@@ -223,14 +474,99 @@ func (d *DownloadCache) Update(requestId string, state DownloadLogState, info st
 	if !exists {
 		return derrors.NewNotFoundError("operation").WithParams(requestId)
 	}
-	operation.State = state
-	operation.Info = info
+	updated := *operation
+	applyUpdate(&updated, state, info, d.url, d.policies.Get(operation.OrganizationId))
+
+	err := d.persist(journalUpdate, requestId, &updated, func() {
+		*operation = updated
+	})
+	if err != nil {
+		return err
+	}
+	d.schedule(requestId, operation, time.Now())
+
+	return nil
+}
+
+func (d *DownloadCache) UpdateProgress(requestId string, progress GenerationProgress) derrors.Error {
+	d.Lock()
+	defer d.Unlock()
 
-	if state == Ready {
-		operation.Expiration = time.Now().Add(ExpirationTime).UnixNano()
-		operation.Url = fmt.Sprintf("%s%s.zip", d.url, requestId)
+	operation, exists := d.cache[requestId]
+	if !exists {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
 	}
+	updated := *operation
+	updated.Progress = progress
+
+	err := d.persist(journalUpdate, requestId, &updated, func() {
+		*operation = updated
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DownloadCache) SaveCheckpoint(requestId string, checkpoint Checkpoint, progress GenerationProgress) derrors.Error {
+	d.Lock()
+	defer d.Unlock()
 
+	operation, exists := d.cache[requestId]
+	if !exists {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	updated := *operation
+	updated.Checkpoint = &checkpoint
+	updated.Progress = progress
+
+	err := d.persist(journalUpdate, requestId, &updated, func() {
+		*operation = updated
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DownloadCache) SetFingerprint(requestId string, fingerprint string, generated int64) derrors.Error {
+	d.Lock()
+	defer d.Unlock()
+
+	operation, exists := d.cache[requestId]
+	if !exists {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	updated := *operation
+	updated.Fingerprint = fingerprint
+	updated.Generated = generated
+
+	err := d.persist(journalUpdate, requestId, &updated, func() {
+		*operation = updated
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DownloadCache) SetArchive(requestId string, extension string) derrors.Error {
+	d.Lock()
+	defer d.Unlock()
+
+	operation, exists := d.cache[requestId]
+	if !exists {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	updated := *operation
+	updated.Archive = extension
+
+	err := d.persist(journalUpdate, requestId, &updated, func() {
+		*operation = updated
+	})
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -243,7 +579,13 @@ func (d *DownloadCache) Remove(requestId string) derrors.Error {
 	if !exists {
 		return derrors.NewNotFoundError("operation").WithParams(requestId)
 	}
-	delete(d.cache, requestId)
+	err := d.persist(journalRemove, requestId, nil, func() {
+		delete(d.cache, requestId)
+	})
+	if err != nil {
+		return err
+	}
+	d.scheduler.Cancel(requestId)
 
 	return nil
 }
@@ -260,7 +602,8 @@ func (d *DownloadCache) List(organizationID string) ([]*DownloadOperation, derro
 			if ope.State == Ready && ope.Expiration < time.Now().UnixNano() {
 				ope.Info = ExpiredMsg
 			}
-			list = append(list, ope)
+			copied := *ope
+			list = append(list, &copied)
 		}
 	}
 
@@ -268,6 +611,28 @@ func (d *DownloadCache) List(organizationID string) ([]*DownloadOperation, derro
 
 }
 
+func (d *DownloadCache) ListAll() ([]*DownloadOperation, derrors.Error) {
+
+	d.Lock()
+	defer d.Unlock()
+
+	list := make([]*DownloadOperation, 0, len(d.cache))
+	for _, ope := range d.cache {
+		copied := *ope
+		list = append(list, &copied)
+	}
+
+	return list, nil
+}
+
 func (d *DownloadCache) Clean() {
+	for requestId := range d.cache {
+		d.scheduler.Cancel(requestId)
+	}
 	d.cache = make(map[string]*DownloadOperation, 0)
+	if d.journal != nil {
+		if err := d.journal.compact(d.cache); err != nil {
+			log.Warn().Str("trace", err.DebugReport()).Msg("error cleaning the operations journal")
+		}
+	}
 }
@@ -18,6 +18,7 @@ package utils
 
 import (
 	"github.com/google/uuid"
+	"github.com/nalej/grpc-log-download-manager-go"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
 )
@@ -131,4 +132,34 @@ var _ = ginkgo.Describe("Utils", func() {
 
 		})
 	})
-})
\ No newline at end of file
+
+	ginkgo.Context("Checkpoints", func() {
+
+		ginkgo.It("should split the window in contiguous shards", func() {
+			checkpoint := NewCheckpoint(nil, 0, 1000, 3, 0)
+			gomega.Expect(checkpoint.Shards).Should(gomega.HaveLen(3))
+			gomega.Expect(checkpoint.Shards[0].Start).Should(gomega.Equal(int64(0)))
+			for i := 1; i < len(checkpoint.Shards); i++ {
+				gomega.Expect(checkpoint.Shards[i].Start).Should(gomega.Equal(checkpoint.Shards[i-1].End + 1))
+			}
+			gomega.Expect(checkpoint.Shards[2].End).Should(gomega.Equal(int64(1000)))
+		})
+
+		ginkgo.It("should not create more shards than nanoseconds in the window", func() {
+			checkpoint := NewCheckpoint(nil, 10, 11, 4, 0)
+			gomega.Expect(checkpoint.Shards).Should(gomega.HaveLen(2))
+		})
+
+		ginkgo.It("should split the window of each target", func() {
+			request := &grpc_log_download_manager_go.DownloadLogRequest{
+				Targets: []*grpc_log_download_manager_go.LogTarget{{AppInstanceId: "i1"}, {AppInstanceId: "i2"}},
+			}
+			checkpoint := NewCheckpoint(request, 0, 1000, 2, 0)
+			gomega.Expect(checkpoint.Shards).Should(gomega.HaveLen(4))
+			for i, shard := range checkpoint.Shards {
+				gomega.Expect(shard.Target).Should(gomega.Equal(i / 2))
+				gomega.Expect(shard.Start).Should(gomega.Equal(checkpoint.Shards[i%2].Start))
+			}
+		})
+	})
+})
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"path/filepath"
+)
+
+// JournalFileName is the name of the file where the operations are journaled inside the download directory
+const JournalFileName = "operations.journal"
+
+// journalCompactionMinEntries is the minimum number of appended entries before compacting the journal
+const journalCompactionMinEntries = 1000
+
+const (
+	journalAdd    = "ADD"
+	journalUpdate = "UPDATE"
+	journalRemove = "REMOVE"
+)
+
+// journalEntry is each of the lines stored in the journal
+type journalEntry struct {
+	Action    string             `json:"action"`
+	RequestId string             `json:"request_id"`
+	Operation *DownloadOperation `json:"operation,omitempty"`
+}
+
+// journal is a write-ahead log with the changes applied to the download operations.
+// Every change is appended (and synced) before it is applied in memory, so the cache
+// can be rebuilt replaying the journal after a restart.
+type journal struct {
+	path string
+	file *os.File
+	// appended is the number of entries written since the last compaction
+	appended int
+}
+
+// GetJournalPath returns the path of the journal inside the download directory
+func GetJournalPath(filesDirectory string) string {
+	return filepath.Join(filesDirectory, JournalFileName)
+}
+
+// openJournal replays the journal stored in path (if any), compacts it and opens it to append new entries
+func openJournal(path string) (*journal, map[string]*DownloadOperation, derrors.Error) {
+
+	operations, err := replayJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	j := &journal{path: path}
+	if err := j.compact(operations); err != nil {
+		return nil, nil, err
+	}
+
+	log.Info().Str("path", path).Int("operations", len(operations)).Msg("operations journal loaded")
+
+	return j, operations, nil
+}
+
+// replayJournal reads the journal and returns the resulting operations
+func replayJournal(path string) (map[string]*DownloadOperation, derrors.Error) {
+	operations := make(map[string]*DownloadOperation, 0)
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return operations, nil
+		}
+		return nil, derrors.AsError(err, "cannot open operations journal")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// a crash in the middle of a write leaves the last line truncated
+			log.Warn().Str("path", path).Int("line", line).Msg("ignoring corrupted journal entry")
+			continue
+		}
+		switch entry.Action {
+		case journalAdd, journalUpdate:
+			if entry.Operation != nil {
+				operations[entry.RequestId] = entry.Operation
+			}
+		case journalRemove:
+			delete(operations, entry.RequestId)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, derrors.AsError(err, "cannot read operations journal")
+	}
+
+	return operations, nil
+}
+
+// compact rewrites the journal with only the current state of the operations
+func (j *journal) compact(operations map[string]*DownloadOperation) derrors.Error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmpPath := j.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return derrors.AsError(err, "cannot create operations journal")
+	}
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for requestId, ope := range operations {
+		if err := encoder.Encode(journalEntry{Action: journalAdd, RequestId: requestId, Operation: ope}); err != nil {
+			tmp.Close()
+			return derrors.AsError(err, "cannot write operations journal")
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return derrors.AsError(err, "cannot write operations journal")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return derrors.AsError(err, "cannot sync operations journal")
+	}
+	if err := tmp.Close(); err != nil {
+		return derrors.AsError(err, "cannot close operations journal")
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return derrors.AsError(err, "cannot replace operations journal")
+	}
+
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return derrors.AsError(err, "cannot open operations journal")
+	}
+	j.file = file
+	j.appended = 0
+	return nil
+}
+
+// needsCompaction checks if the journal has grown too much compared with the number of live operations
+func (j *journal) needsCompaction(liveOperations int) bool {
+	return j.appended > journalCompactionMinEntries && j.appended > 4*liveOperations
+}
+
+// append writes a new entry in the journal
+func (j *journal) append(action string, requestId string, operation *DownloadOperation) derrors.Error {
+	data, err := json.Marshal(journalEntry{Action: action, RequestId: requestId, Operation: operation})
+	if err != nil {
+		return derrors.AsError(err, "cannot serialize journal entry")
+	}
+	data = append(data, '\n')
+	if _, err := j.file.Write(data); err != nil {
+		return derrors.AsError(err, "cannot write journal entry")
+	}
+	if err := j.file.Sync(); err != nil {
+		return derrors.AsError(err, "cannot sync journal entry")
+	}
+	j.appended++
+	return nil
+}
+
+// close closes the journal file
+func (j *journal) close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/google/uuid"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+)
+
+const journalTestDir = "./journalTestDir/"
+
+var _ = ginkgo.Describe("Persistent download cache", func() {
+
+	var organizationID string
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(journalTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+		organizationID = uuid.New().String()
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(journalTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should recover the operations after a restart", func() {
+		cache, err := NewPersistentDownloadCache("/test/", "nalej.tech", journalTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(err).To(gomega.Succeed())
+
+		ready := uuid.New().String()
+		_, err = cache.Add(organizationID, ready, 0, 0, journalTestDir, "user")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = cache.Update(ready, Ready, "file generated")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		removed := uuid.New().String()
+		_, err = cache.Add(organizationID, removed, 0, 0, journalTestDir, "user")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = cache.Remove(removed)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gomega.Expect(cache.Close()).To(gomega.Succeed())
+
+		restored, err := NewPersistentDownloadCache("/test/", "nalej.tech", journalTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(err).To(gomega.Succeed())
+		defer restored.Close()
+
+		ope, err := restored.Get(ready)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Ready))
+		gomega.Expect(ope.UserId).Should(gomega.Equal("user"))
+		gomega.Expect(ope.Url).ShouldNot(gomega.BeEmpty())
+
+		_, err = restored.Get(removed)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		list, err := restored.List(organizationID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list)).Should(gomega.Equal(1))
+	})
+
+	ginkgo.It("should keep the change that compacts the journal", func() {
+		cache, err := NewPersistentDownloadCache("/test/", "nalej.tech", journalTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(err).To(gomega.Succeed())
+		// the next entry compacts the journal
+		compactNext := func() {
+			cache.journal.appended = journalCompactionMinEntries
+		}
+
+		updated := uuid.New().String()
+		_, err = cache.Add(organizationID, updated, 0, 0, journalTestDir, "user")
+		gomega.Expect(err).To(gomega.Succeed())
+		removed := uuid.New().String()
+		_, err = cache.Add(organizationID, removed, 0, 0, journalTestDir, "user")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		compactNext()
+		added := uuid.New().String()
+		_, err = cache.Add(organizationID, added, 0, 0, journalTestDir, "user")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(cache.journal.appended).Should(gomega.Equal(0))
+
+		compactNext()
+		gomega.Expect(cache.Remove(removed)).To(gomega.Succeed())
+		gomega.Expect(cache.journal.appended).Should(gomega.Equal(0))
+
+		compactNext()
+		gomega.Expect(cache.Update(updated, Ready, "file generated")).To(gomega.Succeed())
+		gomega.Expect(cache.journal.appended).Should(gomega.Equal(0))
+		gomega.Expect(cache.Close()).To(gomega.Succeed())
+
+		restored, err := NewPersistentDownloadCache("/test/", "nalej.tech", journalTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(err).To(gomega.Succeed())
+		defer restored.Close()
+
+		_, err = restored.Get(added)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = restored.Get(removed)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		ope, err := restored.Get(updated)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Ready))
+	})
+
+	ginkgo.It("should ignore a truncated entry at the end of the journal", func() {
+		cache, err := NewPersistentDownloadCache("/test/", "nalej.tech", journalTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(err).To(gomega.Succeed())
+		requestID := uuid.New().String()
+		_, err = cache.Add(organizationID, requestID, 0, 0, journalTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(cache.Close()).To(gomega.Succeed())
+
+		f, fErr := os.OpenFile(GetJournalPath(journalTestDir), os.O_APPEND|os.O_WRONLY, 0600)
+		gomega.Expect(fErr).To(gomega.Succeed())
+		_, fErr = f.WriteString("{\"action\":\"UPDA")
+		gomega.Expect(fErr).To(gomega.Succeed())
+		gomega.Expect(f.Close()).To(gomega.Succeed())
+
+		restored, err := NewPersistentDownloadCache("/test/", "nalej.tech", journalTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(err).To(gomega.Succeed())
+		defer restored.Close()
+
+		ope, err := restored.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Queue))
+	})
+
+	ginkgo.It("should only journal the operations of the journal store", func() {
+		policies := NewRetentionPolicies(DefaultRetentionPolicy())
+		memory, err := NewOperationStore(MemoryStore, "/test/", "nalej.tech", journalTestDir, "", policies)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = memory.Add(organizationID, uuid.New().String(), 0, 0, journalTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(memory.Close()).To(gomega.Succeed())
+		_, sErr := os.Stat(GetJournalPath(journalTestDir))
+		gomega.Expect(os.IsNotExist(sErr)).Should(gomega.BeTrue())
+
+		journaled, err := NewOperationStore(JournalStore, "/test/", "nalej.tech", journalTestDir, "", policies)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = journaled.Add(organizationID, uuid.New().String(), 0, 0, journalTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(journaled.Close()).To(gomega.Succeed())
+		_, sErr = os.Stat(GetJournalPath(journalTestDir))
+		gomega.Expect(sErr).To(gomega.Succeed())
+	})
+})
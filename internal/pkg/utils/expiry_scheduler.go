@@ -0,0 +1,201 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// deadline is an element of the scheduler heap
+type deadline struct {
+	requestId string
+	// at is the time (ns) when the deadline is reached
+	at int64
+	// index of the element in the heap, maintained by the heap.Interface methods
+	index int
+}
+
+// deadlineHeap is a min-heap of deadlines ordered by time
+type deadlineHeap []*deadline
+
+func (h deadlineHeap) Len() int           { return len(h) }
+func (h deadlineHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h deadlineHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *deadlineHeap) Push(x interface{}) {
+	item := x.(*deadline)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *deadlineHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+	return item
+}
+
+// ExpiryScheduler calls a function when the deadline of an operation is reached. Each operation has
+// at most one deadline, scheduling it again replaces the previous one.
+type ExpiryScheduler struct {
+	sync.Mutex
+	deadlines deadlineHeap
+	index     map[string]*deadline
+	// onDeadline is called (without holding the scheduler lock) for each reached deadline
+	onDeadline func(requestId string)
+	// wakeup notifies the loop that the earliest deadline may have changed
+	wakeup chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewExpiryScheduler creates and launches a scheduler
+func NewExpiryScheduler(onDeadline func(requestId string)) *ExpiryScheduler {
+	s := &ExpiryScheduler{
+		deadlines:  make(deadlineHeap, 0),
+		index:      make(map[string]*deadline, 0),
+		onDeadline: onDeadline,
+		wakeup:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Schedule sets the deadline (ns) of an operation
+func (s *ExpiryScheduler) Schedule(requestId string, at int64) {
+	s.Lock()
+	item, exists := s.index[requestId]
+	if exists {
+		item.at = at
+		heap.Fix(&s.deadlines, item.index)
+	} else {
+		item = &deadline{requestId: requestId, at: at}
+		heap.Push(&s.deadlines, item)
+		s.index[requestId] = item
+	}
+	first := s.deadlines[0] == item
+	s.Unlock()
+
+	if first {
+		s.notify()
+	}
+}
+
+// Cancel removes the deadline of an operation
+func (s *ExpiryScheduler) Cancel(requestId string) {
+	s.Lock()
+	defer s.Unlock()
+
+	item, exists := s.index[requestId]
+	if !exists {
+		return
+	}
+	heap.Remove(&s.deadlines, item.index)
+	delete(s.index, requestId)
+}
+
+// Len returns the number of pending deadlines
+func (s *ExpiryScheduler) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.deadlines)
+}
+
+// Stop finishes the scheduler loop and waits for it. Pending deadlines are discarded.
+func (s *ExpiryScheduler) Stop() {
+	select {
+	case <-s.stop:
+		// already stopped
+	default:
+		close(s.stop)
+	}
+	<-s.done
+}
+
+func (s *ExpiryScheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+		// there is already a pending notification
+	}
+}
+
+// popReached removes and returns the operations whose deadline is reached. If there are none,
+// it returns the time to wait until the next deadline (or -1 if there are no deadlines).
+func (s *ExpiryScheduler) popReached(now int64) ([]string, time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	reached := make([]string, 0)
+	for len(s.deadlines) > 0 && s.deadlines[0].at <= now {
+		item := heap.Pop(&s.deadlines).(*deadline)
+		delete(s.index, item.requestId)
+		reached = append(reached, item.requestId)
+	}
+	if len(reached) > 0 {
+		return reached, 0
+	}
+	if len(s.deadlines) == 0 {
+		return reached, -1
+	}
+	return reached, time.Duration(s.deadlines[0].at - now)
+}
+
+func (s *ExpiryScheduler) run() {
+	defer close(s.done)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		reached, wait := s.popReached(time.Now().UnixNano())
+		for _, requestId := range reached {
+			s.onDeadline(requestId)
+		}
+		if len(reached) > 0 {
+			continue
+		}
+
+		var timeout <-chan time.Time
+		if wait >= 0 {
+			timer.Reset(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wakeup:
+			if !timer.Stop() && timeout != nil {
+				// drain the channel if the timer fired meanwhile
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		case <-timeout:
+		}
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"time"
+)
+
+const schedulerTestDir = "./schedulerTestDir/"
+
+var _ = ginkgo.Describe("Expiry scheduler", func() {
+
+	var scheduler *ExpiryScheduler
+	var reached chan string
+
+	ginkgo.BeforeEach(func() {
+		reached = make(chan string, 10)
+		scheduler = NewExpiryScheduler(func(requestId string) {
+			reached <- requestId
+		})
+	})
+	ginkgo.AfterEach(func() {
+		scheduler.Stop()
+	})
+
+	ginkgo.It("should notify the deadlines in order", func() {
+		now := time.Now()
+		scheduler.Schedule("second", now.Add(200*time.Millisecond).UnixNano())
+		scheduler.Schedule("first", now.Add(50*time.Millisecond).UnixNano())
+
+		gomega.Eventually(reached).Should(gomega.Receive(gomega.Equal("first")))
+		gomega.Eventually(reached).Should(gomega.Receive(gomega.Equal("second")))
+		gomega.Expect(scheduler.Len()).Should(gomega.Equal(0))
+	})
+
+	ginkgo.It("should replace and cancel deadlines", func() {
+		now := time.Now()
+		scheduler.Schedule("moved", now.Add(time.Hour).UnixNano())
+		scheduler.Schedule("cancelled", now.Add(50*time.Millisecond).UnixNano())
+		scheduler.Cancel("cancelled")
+		scheduler.Schedule("moved", now.Add(50*time.Millisecond).UnixNano())
+
+		gomega.Eventually(reached).Should(gomega.Receive(gomega.Equal("moved")))
+		gomega.Consistently(reached, 200*time.Millisecond).ShouldNot(gomega.Receive())
+	})
+
+	ginkgo.It("should remove the artifacts of an operation when it expires", func() {
+		err := os.MkdirAll(schedulerTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+		defer os.RemoveAll(schedulerTestDir)
+
+		policies := NewRetentionPolicies(RetentionPolicy{ReadyWindow: 100 * time.Millisecond, MetadataRetention: 100 * time.Millisecond})
+		cache, cErr := NewPersistentDownloadCache("/test/", "nalej.tech", schedulerTestDir, policies)
+		gomega.Expect(cErr).To(gomega.Succeed())
+		defer cache.Close()
+
+		_, cErr = cache.Add("org", "request", 0, 0, schedulerTestDir, "")
+		gomega.Expect(cErr).To(gomega.Succeed())
+		zipPath := GetZipFilePath(schedulerTestDir, "request")
+		gomega.Expect(InitializeFile(zipPath, false)).To(gomega.Succeed())
+		cErr = cache.Update("request", Ready, "")
+		gomega.Expect(cErr).To(gomega.Succeed())
+
+		gomega.Eventually(func() bool { return fileExists(zipPath) }).Should(gomega.BeFalse())
+		gomega.Eventually(func() error {
+			_, err := cache.Get("request")
+			return err
+		}).ShouldNot(gomega.Succeed())
+	})
+})
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"time"
+)
+
+// ExportLimits defines the maximum size of an export, the zero values mean unlimited
+type ExportLimits struct {
+	// MaxEntries is the maximum number of log entries
+	MaxEntries int64
+	// MaxBytes is the maximum size of the file before compressing it. The Parquet files are checked with the size
+	// of the pages already written plus the size of the new values before compressing them.
+	MaxBytes int64
+	// MaxWindow is the maximum length of the [From, To] window
+	MaxWindow time.Duration
+}
+
+// Validate checks the values of the limits
+func (l ExportLimits) Validate() derrors.Error {
+	if l.MaxEntries < 0 || l.MaxBytes < 0 || l.MaxWindow < 0 {
+		return derrors.NewInvalidArgumentError("the export limits cannot be negative").
+			WithParams(l.MaxEntries, l.MaxBytes, l.MaxWindow.String())
+	}
+	return nil
+}
+
+// lower returns the lowest limit, zero meaning unlimited
+func lower(limit int64, requested int64) int64 {
+	if requested > 0 && (limit == 0 || requested < limit) {
+		return requested
+	}
+	return limit
+}
+
+// Restrict returns the limits applied to a request, which can only lower them
+func (l ExportLimits) Restrict(request *grpc_log_download_manager_go.DownloadLogRequest) ExportLimits {
+	return ExportLimits{
+		MaxEntries: lower(l.MaxEntries, request.MaxEntries),
+		MaxBytes:   lower(l.MaxBytes, request.MaxBytes),
+		MaxWindow:  time.Duration(lower(int64(l.MaxWindow), request.MaxWindow)),
+	}
+}
+
+// Bounded checks if the number of entries or the size of the file are limited
+func (l ExportLimits) Bounded() bool {
+	return l.MaxEntries > 0 || l.MaxBytes > 0
+}
+
+// Window returns the part of the window [from, to] within the maximum length, starting at the end where the
+// generation starts. The reason of the truncation is returned when the window is cut.
+func (l ExportLimits) Window(from int64, to int64, ascending bool) (int64, int64, string) {
+	if l.MaxWindow <= 0 || to-from <= int64(l.MaxWindow) {
+		return from, to, ""
+	}
+	reason := fmt.Sprintf("window limited to %s", l.MaxWindow.String())
+	if ascending {
+		return from, from + int64(l.MaxWindow), reason
+	}
+	return to - int64(l.MaxWindow), to, reason
+}
+
+// Fit returns how many of the given lines (sizes in bytes) can be added to a file with the given entries and
+// bytes without exceeding the limits. The reason of the truncation is returned when a line does not fit.
+func (l ExportLimits) Fit(entries int64, bytes int64, lines []int) (int, string) {
+	for i, size := range lines {
+		if l.MaxEntries > 0 && entries+1 > l.MaxEntries {
+			return i, fmt.Sprintf("maximum number of entries (%d) reached", l.MaxEntries)
+		}
+		if l.MaxBytes > 0 && bytes+int64(size) > l.MaxBytes {
+			return i, fmt.Sprintf("maximum size (%d bytes) reached", l.MaxBytes)
+		}
+		entries++
+		bytes += int64(size)
+	}
+	return len(lines), ""
+}
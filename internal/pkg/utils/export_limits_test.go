@@ -0,0 +1,68 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Export limits", func() {
+
+	ginkgo.It("should only lower the limits with the request", func() {
+		limits := ExportLimits{MaxEntries: 100, MaxWindow: time.Hour}
+		restricted := limits.Restrict(&grpc_log_download_manager_go.DownloadLogRequest{
+			MaxEntries: 1000, MaxBytes: 50, MaxWindow: int64(time.Minute)})
+		gomega.Expect(restricted).Should(gomega.Equal(ExportLimits{MaxEntries: 100, MaxBytes: 50, MaxWindow: time.Minute}))
+		gomega.Expect(restricted.Bounded()).Should(gomega.BeTrue())
+		gomega.Expect(ExportLimits{MaxWindow: time.Hour}.Bounded()).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should cut the window from the end where the generation starts", func() {
+		limits := ExportLimits{MaxWindow: 100}
+		from, to, reason := limits.Window(1000, 2000, true)
+		gomega.Expect([]int64{from, to}).Should(gomega.Equal([]int64{1000, 1100}))
+		gomega.Expect(reason).ShouldNot(gomega.BeEmpty())
+
+		from, to, _ = limits.Window(1000, 2000, false)
+		gomega.Expect([]int64{from, to}).Should(gomega.Equal([]int64{1900, 2000}))
+
+		_, _, reason = limits.Window(1000, 1100, true)
+		gomega.Expect(reason).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should fit the lines within the limits", func() {
+		fits, reason := ExportLimits{MaxEntries: 5}.Fit(3, 0, []int{10, 10, 10})
+		gomega.Expect(fits).Should(gomega.Equal(2))
+		gomega.Expect(reason).ShouldNot(gomega.BeEmpty())
+
+		fits, reason = ExportLimits{MaxBytes: 25}.Fit(0, 0, []int{10, 10, 10})
+		gomega.Expect(fits).Should(gomega.Equal(2))
+		gomega.Expect(reason).ShouldNot(gomega.BeEmpty())
+
+		fits, reason = ExportLimits{MaxBytes: 30}.Fit(0, 0, []int{10, 10, 10})
+		gomega.Expect(fits).Should(gomega.Equal(3))
+		gomega.Expect(reason).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should reject negative limits", func() {
+		gomega.Expect(ExportLimits{MaxBytes: -1}.Validate()).NotTo(gomega.Succeed())
+		gomega.Expect(ExportLimits{}.Validate()).To(gomega.Succeed())
+	})
+})
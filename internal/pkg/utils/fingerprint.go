@@ -0,0 +1,74 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"strings"
+)
+
+// Fingerprint identifies the content of the artifact generated for a request with the given limits, the requests
+// with the same fingerprint generate the same artifact. The priority does not change the artifact so it is not
+// included. The requests without the end of the window are not fingerprinted (an empty string is returned)
+// because their window depends on the time they are generated.
+func Fingerprint(request *grpc_log_download_manager_go.DownloadLogRequest, limits ExportLimits) string {
+	if request.To == 0 {
+		return ""
+	}
+	order := grpc_common_go.Order_ASC
+	if request.Order != nil {
+		order = request.Order.Order
+	}
+	fields := []string{
+		strings.TrimSpace(request.OrganizationId),
+		strings.TrimSpace(request.AppDescriptorId),
+		strings.TrimSpace(request.AppInstanceId),
+		strings.TrimSpace(request.ServiceGroupId),
+		strings.TrimSpace(request.ServiceGroupInstanceId),
+		strings.TrimSpace(request.ServiceId),
+		strings.TrimSpace(request.ServiceInstanceId),
+		strings.TrimSpace(request.MsgQueryFilter),
+		fmt.Sprintf("%d", request.From),
+		fmt.Sprintf("%d", request.To),
+		order.String(),
+		fmt.Sprintf("%t", request.IncludeMetadata),
+		fmt.Sprintf("%d", limits.MaxEntries),
+		fmt.Sprintf("%d", limits.MaxBytes),
+		fmt.Sprintf("%d", int64(limits.MaxWindow)),
+		fmt.Sprintf("%d", request.PartMaxEntries),
+		fmt.Sprintf("%d", request.PartMaxBytes),
+		request.Format.String(),
+		strings.Join(request.Columns, ","),
+		request.Archive.String(),
+	}
+	// each target generates its own file, their order is kept
+	for _, target := range request.Targets {
+		fields = append(fields, strings.TrimSpace(target.AppDescriptorId), strings.TrimSpace(target.AppInstanceId),
+			strings.TrimSpace(target.ServiceGroupId), strings.TrimSpace(target.ServiceGroupInstanceId),
+			strings.TrimSpace(target.ServiceId), strings.TrimSpace(target.ServiceInstanceId))
+	}
+	// the fields are quoted so the separator cannot be confused with their content
+	for i, field := range fields {
+		fields[i] = fmt.Sprintf("%q", field)
+	}
+	hash := sha256.Sum256([]byte(strings.Join(fields, "|")))
+	return hex.EncodeToString(hash[:])
+}
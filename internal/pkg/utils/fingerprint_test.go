@@ -0,0 +1,70 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/grpc-common-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Fingerprint", func() {
+
+	newRequest := func() *grpc_log_download_manager_go.DownloadLogRequest {
+		return &grpc_log_download_manager_go.DownloadLogRequest{
+			OrganizationId: "org",
+			AppInstanceId:  "app",
+			MsgQueryFilter: "error",
+			From:           1000,
+			To:             2000,
+			Order:          &grpc_common_go.OrderOptions{Order: grpc_common_go.Order_DESC},
+		}
+	}
+
+	ginkgo.It("should ignore the priority and the surrounding spaces", func() {
+		other := newRequest()
+		other.MsgQueryFilter = " error "
+		other.Priority = grpc_log_download_manager_go.DownloadPriority_INTERACTIVE
+		gomega.Expect(Fingerprint(other, ExportLimits{})).Should(gomega.Equal(Fingerprint(newRequest(), ExportLimits{})))
+	})
+
+	ginkgo.It("should change with the content of the artifact", func() {
+		fingerprint := Fingerprint(newRequest(), ExportLimits{})
+		gomega.Expect(fingerprint).ShouldNot(gomega.BeEmpty())
+
+		other := newRequest()
+		other.IncludeMetadata = true
+		gomega.Expect(Fingerprint(other, ExportLimits{})).ShouldNot(gomega.Equal(fingerprint))
+		other = newRequest()
+		other.Order.Order = grpc_common_go.Order_ASC
+		gomega.Expect(Fingerprint(other, ExportLimits{})).ShouldNot(gomega.Equal(fingerprint))
+		gomega.Expect(Fingerprint(newRequest(), ExportLimits{MaxEntries: 10})).ShouldNot(gomega.Equal(fingerprint))
+		other = newRequest()
+		other.Targets = []*grpc_log_download_manager_go.LogTarget{{AppInstanceId: "app"}, {AppInstanceId: "other"}}
+		withTargets := Fingerprint(other, ExportLimits{})
+		gomega.Expect(withTargets).ShouldNot(gomega.Equal(fingerprint))
+		other.Targets[0], other.Targets[1] = other.Targets[1], other.Targets[0]
+		gomega.Expect(Fingerprint(other, ExportLimits{})).ShouldNot(gomega.Equal(withTargets))
+	})
+
+	ginkgo.It("should not fingerprint a request without the end of the window", func() {
+		request := newRequest()
+		request.To = 0
+		gomega.Expect(Fingerprint(request, ExportLimits{})).Should(gomega.BeEmpty())
+	})
+})
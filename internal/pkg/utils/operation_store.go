@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"time"
+)
+
+const (
+	// MemoryStore keeps the operations in a map, they are lost when the service stops
+	MemoryStore = "memory"
+	// JournalStore keeps the operations in a map journaled in the download directory
+	JournalStore = "journal"
+	// SQLiteStore keeps the operations in a SQLite database
+	SQLiteStore = "sqlite"
+)
+
+// OperationStore is the interface of the storage of the download operations.
+type OperationStore interface {
+	// Add a new operation in QUEUE state
+	Add(organizationId string, requestId string, from int64, to int64, directory string, userID string) (*DownloadOperation, derrors.Error)
+	// Get an operation
+	Get(requestId string) (*DownloadOperation, derrors.Error)
+	// Update the state and the info of an operation
+	Update(requestId string, state DownloadLogState, info string) derrors.Error
+	// UpdateProgress sets the progress of the generation of an operation
+	UpdateProgress(requestId string, progress GenerationProgress) derrors.Error
+	// SaveCheckpoint sets the checkpoint of an operation and the progress reached with it
+	SaveCheckpoint(requestId string, checkpoint Checkpoint, progress GenerationProgress) derrors.Error
+	// SetFingerprint sets the fingerprint of the request of an operation and the time its artifact was generated,
+	// 0 if it has not been generated yet
+	SetFingerprint(requestId string, fingerprint string, generated int64) derrors.Error
+	// SetArchive sets the extension of the archive file of an operation
+	SetArchive(requestId string, extension string) derrors.Error
+	// Remove an operation
+	Remove(requestId string) derrors.Error
+	// List the operations of an organization
+	List(organizationID string) ([]*DownloadOperation, derrors.Error)
+	// ListAll returns the operations of all the organizations
+	ListAll() ([]*DownloadOperation, derrors.Error)
+	// Expire reviews all the operations removing the expired ones and their zip files. The stores remove
+	// them when their deadlines are reached, a full review is only needed after a change of the policies.
+	Expire() derrors.Error
+	// Close releases the resources used by the store
+	Close() error
+}
+
+// NewOperationStore creates an OperationStore of the given type
+func NewOperationStore(storeType string, url string, publicHost string, directory string, databasePath string, policies *RetentionPolicies) (OperationStore, derrors.Error) {
+	switch storeType {
+	case MemoryStore:
+		store := NewDownloadCache(url, publicHost)
+		store.policies = policies
+		return store, nil
+	case JournalStore:
+		store, err := NewPersistentDownloadCache(url, publicHost, directory, policies)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case SQLiteStore:
+		if databasePath == "" {
+			databasePath = GetSQLitePath(directory)
+		}
+		store, err := NewSQLiteOperationStore(url, publicHost, databasePath, policies)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, derrors.NewInvalidArgumentError("unsupported operation store").WithParams(storeType)
+}
+
+// getDownloadURL returns the base url used to download the zip files
+func getDownloadURL(url string, publicHost string) string {
+	return fmt.Sprintf("https://web.%s%s", publicHost, url)
+}
+
+// applyUpdate changes the state and info of an operation. The generation time is set when it starts to be
+// generated (a resumed generation keeps the first one). When it is ready, the expiration and the url
+// are set. Once the operation cannot be downloaded anymore, its retention is set.
+func applyUpdate(operation *DownloadOperation, state DownloadLogState, info string, url string, policy RetentionPolicy) {
+	now := time.Now()
+	operation.State = state
+	operation.Info = info
+
+	switch state {
+	case Generating:
+		if operation.Generated == 0 {
+			operation.Generated = now.UnixNano()
+		}
+	case Ready:
+		operation.Expiration = now.Add(policy.ReadyWindow).UnixNano()
+		operation.Url = fmt.Sprintf("%s%s%s", url, operation.RequestId, operation.ArchiveExtension())
+		operation.Retention = time.Unix(0, operation.Expiration).Add(policy.MetadataRetention).UnixNano()
+	case Error, Downloaded, Cancelled:
+		operation.Retention = now.Add(policy.MetadataRetention).UnixNano()
+	}
+	if state.IsFinal() {
+		operation.Checkpoint = nil
+	}
+}
+
+// removeArtifacts deletes the archive file of an expired operation
+func removeArtifacts(ope *DownloadOperation) {
+	err := RemoveFile(ope.ArchivePath())
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn().Str("requestId", ope.RequestId).Msg("error deleting archive file")
+	}
+}
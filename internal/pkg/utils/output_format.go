@@ -0,0 +1,222 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// formatExtensions contains the extension of the files of each output format, the text files keep their own
+var formatExtensions = map[grpc_log_download_manager_go.OutputFormat]string{
+	grpc_log_download_manager_go.OutputFormat_JSON_LINES: ".jsonl",
+	grpc_log_download_manager_go.OutputFormat_CSV:        ".csv",
+	grpc_log_download_manager_go.OutputFormat_PARQUET:    ".parquet",
+}
+
+// csvColumns contains the columns that can be selected in the CSV format, named after the fields of the log entries
+var csvColumns = map[string]func(response *grpc_application_manager_go.LogEntryResponse) string{
+	"timestamp": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return formatTimestamp(response.Timestamp)
+	},
+	"app_descriptor_id": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.AppDescriptorId
+	},
+	"app_descriptor_name": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.AppDescriptorName
+	},
+	"app_instance_id": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.AppInstanceId
+	},
+	"app_instance_name": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.AppInstanceName
+	},
+	"service_group_id": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.ServiceGroupId
+	},
+	"service_group_name": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.ServiceGroupName
+	},
+	"service_group_instance_id": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.ServiceGroupInstanceId
+	},
+	"service_id": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.ServiceId
+	},
+	"service_name": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.ServiceName
+	},
+	"service_instance_id": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.ServiceInstanceId
+	},
+	"msg": func(response *grpc_application_manager_go.LogEntryResponse) string {
+		return response.Msg
+	},
+}
+
+// DefaultCSVColumns are the columns of the CSV files when the request does not select them
+var DefaultCSVColumns = []string{"timestamp", "app_descriptor_name", "app_instance_name", "service_group_name", "service_name", "msg"}
+
+// ValidCSVColumn checks if a column can be selected in the CSV format
+func ValidCSVColumn(column string) bool {
+	_, exists := csvColumns[column]
+	return exists
+}
+
+// jsonEntry is the object written for each log entry in the JSON Lines format
+type jsonEntry struct {
+	Timestamp    string `json:"timestamp"`
+	Descriptor   string `json:"descriptor"`
+	Instance     string `json:"instance"`
+	ServiceGroup string `json:"service_group"`
+	Service      string `json:"service"`
+	Msg          string `json:"msg"`
+}
+
+// EntryFormat writes the log entries in the output format of a request
+type EntryFormat struct {
+	Format          grpc_log_download_manager_go.OutputFormat
+	IncludeMetadata bool
+	// Columns of the CSV format
+	Columns []string
+}
+
+// NewEntryFormat returns the format of the entries of a request
+func NewEntryFormat(request *grpc_log_download_manager_go.DownloadLogRequest) EntryFormat {
+	format := EntryFormat{Format: request.Format, IncludeMetadata: request.IncludeMetadata, Columns: request.Columns}
+	if format.Format == grpc_log_download_manager_go.OutputFormat_CSV && len(format.Columns) == 0 {
+		format.Columns = DefaultCSVColumns
+	}
+	return format
+}
+
+// formatTimestamp returns a timestamp (ns) in RFC 3339 in UTC
+func formatTimestamp(timestamp int64) string {
+	return time.Unix(0, timestamp).UTC().Format(time.RFC3339Nano)
+}
+
+// csvRecord returns a CSV row, the values with commas, quotes or line breaks are quoted
+func csvRecord(values []string) string {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	// the buffer cannot fail
+	_ = writer.Write(values)
+	writer.Flush()
+	return buffer.String()
+}
+
+// Header returns the content written at the beginning of each file, the column names in the CSV format
+func (f EntryFormat) Header() string {
+	if f.Format != grpc_log_download_manager_go.OutputFormat_CSV {
+		return ""
+	}
+	return csvRecord(f.Columns)
+}
+
+// Line returns the content written in the file for a log entry. In the JSON Lines format each entry is an object
+// with its timestamp (RFC 3339 in UTC), its names and its message, the metadata is always included. In the CSV
+// format each entry is a row with the selected columns. The Parquet entries are not written in lines but in
+// row groups, see WriteEntries.
+func (f EntryFormat) Line(response *grpc_application_manager_go.LogEntryResponse) string {
+	switch f.Format {
+	case grpc_log_download_manager_go.OutputFormat_JSON_LINES:
+		return jsonLine(response)
+	case grpc_log_download_manager_go.OutputFormat_CSV:
+		values := make([]string, 0, len(f.Columns))
+		for _, column := range f.Columns {
+			value := ""
+			if field, exists := csvColumns[column]; exists {
+				value = field(response)
+			}
+			values = append(values, value)
+		}
+		return csvRecord(values)
+	}
+	return FormatResponse(response, f.IncludeMetadata)
+}
+
+// jsonLine returns a log entry as a JSON object in a line
+func jsonLine(response *grpc_application_manager_go.LogEntryResponse) string {
+	data, err := json.Marshal(jsonEntry{
+		Timestamp:    formatTimestamp(response.Timestamp),
+		Descriptor:   response.AppDescriptorName,
+		Instance:     response.AppInstanceName,
+		ServiceGroup: response.ServiceGroupName,
+		Service:      response.ServiceName,
+		Msg:          response.Msg,
+	})
+	if err != nil {
+		// the fields are strings, it cannot fail
+		return FormatResponse(response, true)
+	}
+	return string(data) + "\n"
+}
+
+// Extension returns the extension of the files of the format, the given one for the text files
+func (f EntryFormat) Extension(text string) string {
+	if extension, exists := formatExtensions[f.Format]; exists {
+		return extension
+	}
+	return text
+}
+
+// Columnar returns if the entries of the format are written in row groups, the files are written with the row
+// groups of their segments once the generation is complete
+func (f EntryFormat) Columnar() bool {
+	return f.Format == grpc_log_download_manager_go.OutputFormat_PARQUET
+}
+
+// Size returns the bytes taken by a log entry in the file. The Parquet entries take the size of their values in
+// the plain encoding, before compressing the pages, without the page headers and the metadata of the file.
+func (f EntryFormat) Size(response *grpc_application_manager_go.LogEntryResponse) int {
+	if f.Columnar() {
+		return parquetSize(response)
+	}
+	return len(f.Line(response))
+}
+
+// WriteEntries writes the log entries, a line each or a row group in the Parquet format. Returns the bytes written.
+func (f EntryFormat) WriteEntries(w io.Writer, responses []*grpc_application_manager_go.LogEntryResponse) (int64, error) {
+	if f.Columnar() {
+		if len(responses) == 0 {
+			return 0, nil
+		}
+		return writeParquetRowGroup(w, responses)
+	}
+	written := int64(0)
+	for _, response := range responses {
+		n, err := io.WriteString(w, f.Line(response))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// FileName returns the name of a file in the archive with the extension of the format
+func (f EntryFormat) FileName(name string) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + f.Extension(ext)
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+var _ = ginkgo.Describe("Output formats", func() {
+
+	entry := &grpc_application_manager_go.LogEntryResponse{
+		Timestamp:         time.Date(2019, 5, 1, 10, 30, 0, 500, time.UTC).UnixNano(),
+		AppDescriptorName: "shop",
+		AppInstanceName:   "shop-prod",
+		ServiceGroupName:  "backend",
+		ServiceName:       "cart",
+		Msg:               "quoted \"message\"\nin two lines",
+	}
+	jsonLines := EntryFormat{Format: grpc_log_download_manager_go.OutputFormat_JSON_LINES}
+
+	ginkgo.It("should write each entry as a JSON object in a line", func() {
+		line := jsonLines.Line(entry)
+		gomega.Expect(line).Should(gomega.HaveSuffix("}\n"))
+		gomega.Expect(line[:len(line)-1]).ShouldNot(gomega.ContainSubstring("\n"))
+
+		var decoded map[string]string
+		gomega.Expect(json.Unmarshal([]byte(line), &decoded)).To(gomega.Succeed())
+		gomega.Expect(decoded).Should(gomega.Equal(map[string]string{
+			"timestamp":     "2019-05-01T10:30:00.0000005Z",
+			"descriptor":    "shop",
+			"instance":      "shop-prod",
+			"service_group": "backend",
+			"service":       "cart",
+			"msg":           entry.Msg,
+		}))
+	})
+
+	ginkgo.It("should keep the text format", func() {
+		gomega.Expect(EntryFormat{}.Line(entry)).Should(gomega.Equal(FormatResponse(entry, false)))
+		gomega.Expect(EntryFormat{IncludeMetadata: true}.Line(entry)).Should(gomega.Equal(FormatResponse(entry, true)))
+	})
+
+	ginkgo.It("should name the files with the extension of the format", func() {
+		gomega.Expect(jsonLines.FileName("request.file")).Should(gomega.Equal("request.jsonl"))
+		gomega.Expect(jsonLines.FileName("request.part-00002.file")).Should(gomega.Equal("request.part-00002.jsonl"))
+		gomega.Expect(EntryFormat{}.FileName("request.file")).Should(gomega.Equal("request.file"))
+		gomega.Expect(EntryFormat{}.Extension(".log")).Should(gomega.Equal(".log"))
+	})
+
+	ginkgo.It("should write the selected columns as CSV rows", func() {
+		csvFormat := NewEntryFormat(&grpc_log_download_manager_go.DownloadLogRequest{
+			Format:  grpc_log_download_manager_go.OutputFormat_CSV,
+			Columns: []string{"service_name", "msg", "timestamp"},
+		})
+		gomega.Expect(csvFormat.Header()).Should(gomega.Equal("service_name,msg,timestamp\n"))
+		gomega.Expect(csvFormat.Line(entry)).Should(gomega.Equal("cart,\"quoted \"\"message\"\"\nin two lines\",2019-05-01T10:30:00.0000005Z\n"))
+
+		other := *entry
+		other.Msg = "a, b"
+		records, err := csv.NewReader(strings.NewReader(csvFormat.Header() + csvFormat.Line(entry) + csvFormat.Line(&other))).ReadAll()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(records).Should(gomega.HaveLen(3))
+		gomega.Expect(records[1][1]).Should(gomega.Equal(entry.Msg))
+		gomega.Expect(records[2][1]).Should(gomega.Equal("a, b"))
+	})
+
+	ginkgo.It("should use the default columns", func() {
+		csvFormat := NewEntryFormat(&grpc_log_download_manager_go.DownloadLogRequest{Format: grpc_log_download_manager_go.OutputFormat_CSV})
+		gomega.Expect(csvFormat.Columns).Should(gomega.Equal(DefaultCSVColumns))
+		gomega.Expect(csvFormat.FileName("request.file")).Should(gomega.Equal("request.csv"))
+		gomega.Expect(EntryFormat{}.Header()).Should(gomega.BeEmpty())
+		gomega.Expect(ValidCSVColumn("msg")).Should(gomega.BeTrue())
+		gomega.Expect(ValidCSVColumn("Msg")).Should(gomega.BeFalse())
+	})
+})
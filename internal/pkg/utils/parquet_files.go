@@ -0,0 +1,548 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/klauspost/compress/s2"
+	"github.com/nalej/grpc-application-manager-go"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// parquetMagic starts and ends the Parquet files
+const parquetMagic = "PAR1"
+
+// parquetCreatedBy is the application that writes the Parquet files, kept in their metadata
+const parquetCreatedBy = "log-download-manager"
+
+// Values of the Parquet metadata used by the files
+const (
+	parquetInt64     = 2
+	parquetByteArray = 6
+	parquetRequired  = 0
+	parquetUTF8      = 0
+	parquetPlain     = 0
+	parquetRLE       = 3
+	parquetSnappy    = 1
+	parquetDataPage  = 0
+)
+
+// Types of the Thrift compact protocol used by the Parquet metadata
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// parquetTimestamp is the column with the timestamp (ns in UTC) of the entries, the other columns are strings
+const parquetTimestamp = "timestamp"
+
+// parquetColumns are the columns of the Parquet files: the timestamp, the identifiers and names of the entry
+// and its message. All of them are required.
+var parquetColumns = []string{parquetTimestamp, "app_descriptor_id", "app_descriptor_name", "app_instance_id",
+	"app_instance_name", "service_group_id", "service_group_name", "service_group_instance_id", "service_id",
+	"service_name", "service_instance_id", "msg"}
+
+// parquetSize returns the size of the values of a log entry in the plain encoding
+func parquetSize(response *grpc_application_manager_go.LogEntryResponse) int {
+	size := 8
+	for _, column := range parquetColumns[1:] {
+		size += 4 + len(csvColumns[column](response))
+	}
+	return size
+}
+
+// parquetValues returns the values of a column in the plain encoding: the timestamps are little endian integers
+// and the strings are preceded by their length
+func parquetValues(column string, responses []*grpc_application_manager_go.LogEntryResponse) []byte {
+	var buffer bytes.Buffer
+	var number [8]byte
+	for _, response := range responses {
+		if column == parquetTimestamp {
+			binary.LittleEndian.PutUint64(number[:], uint64(response.Timestamp))
+			buffer.Write(number[:])
+			continue
+		}
+		value := csvColumns[column](response)
+		binary.LittleEndian.PutUint32(number[:4], uint32(len(value)))
+		buffer.Write(number[:4])
+		buffer.WriteString(value)
+	}
+	return buffer.Bytes()
+}
+
+// writeParquetRowGroup writes the log entries as a row group, a data page for each column with the values
+// compressed with Snappy. Only the pages are written, the metadata of the row groups is written in the footer
+// of the Parquet file once all of them are complete. Returns the bytes written.
+func writeParquetRowGroup(w io.Writer, responses []*grpc_application_manager_go.LogEntryResponse) (int64, error) {
+	written := int64(0)
+	for _, column := range parquetColumns {
+		values := parquetValues(column, responses)
+		data := s2.EncodeSnappy(nil, values)
+
+		header := newThriftWriter()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(len(data)))
+		header.begin(5)
+		header.i32(1, int32(len(responses)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.end()
+
+		for _, content := range [][]byte{header.Bytes(), data} {
+			n, err := w.Write(content)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// parquetChunk is a column chunk of a Parquet file, the data page of a column in a row group
+type parquetChunk struct {
+	// offset of the page in the file
+	offset int64
+	values int64
+	// uncompressed and compressed sizes, including the page header
+	uncompressed int64
+	compressed   int64
+}
+
+// WriteParquetFile writes a Parquet file with the row groups of the segments, in the given order, followed by
+// the metadata that describes them. The pages are copied as they are, the segments are not loaded in memory.
+func WriteParquetFile(target string, segments []string) error {
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(f)
+	if _, err = writer.WriteString(parquetMagic); err == nil {
+		offset := int64(len(parquetMagic))
+		rowGroups := make([][]parquetChunk, 0)
+		for _, segment := range segments {
+			if rowGroups, offset, err = copyParquetSegment(writer, segment, rowGroups, offset); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = writeParquetFooter(writer, rowGroups)
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyParquetSegment copies the pages of a segment at the given offset of the file, adding its row groups to the
+// given ones. Returns the row groups and the offset after the segment.
+func copyParquetSegment(w io.Writer, segment string, rowGroups [][]parquetChunk, offset int64) ([][]parquetChunk, int64, error) {
+	in, err := os.Open(segment)
+	if err != nil {
+		return rowGroups, offset, err
+	}
+	defer in.Close()
+	reader := bufio.NewReader(in)
+	rowGroup := make([]parquetChunk, 0, len(parquetColumns))
+	for {
+		if _, err := reader.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return rowGroups, offset, err
+		}
+		chunk, err := copyParquetPage(w, reader, offset)
+		if err != nil {
+			return rowGroups, offset, fmt.Errorf("invalid page in %s: %s", filepath.Base(segment), err.Error())
+		}
+		offset += chunk.compressed
+		rowGroup = append(rowGroup, chunk)
+		if len(rowGroup) == len(parquetColumns) {
+			rowGroups = append(rowGroups, rowGroup)
+			rowGroup = make([]parquetChunk, 0, len(parquetColumns))
+		}
+	}
+	if len(rowGroup) > 0 {
+		return rowGroups, offset, fmt.Errorf("incomplete row group in %s", filepath.Base(segment))
+	}
+	return rowGroups, offset, nil
+}
+
+// copyParquetPage copies a data page, reading its header to describe it in the metadata
+func copyParquetPage(w io.Writer, reader *bufio.Reader, offset int64) (parquetChunk, error) {
+	chunk := parquetChunk{offset: offset}
+	var header bytes.Buffer
+	r := thriftReader{recordingReader{ByteReader: reader, read: &header}}
+	size := int64(0)
+	err := r.readStruct(func(id int16, kind byte) (err error) {
+		switch id {
+		case 2:
+			chunk.uncompressed, err = r.varint()
+		case 3:
+			size, err = r.varint()
+		case 5:
+			err = r.readStruct(func(id int16, kind byte) (err error) {
+				if id == 1 {
+					chunk.values, err = r.varint()
+				} else {
+					_, err = r.value(kind)
+				}
+				return err
+			})
+		default:
+			_, err = r.value(kind)
+		}
+		return err
+	})
+	if err != nil {
+		return chunk, err
+	}
+	chunk.uncompressed += int64(header.Len())
+	chunk.compressed = int64(header.Len()) + size
+	if _, err := w.Write(header.Bytes()); err != nil {
+		return chunk, err
+	}
+	if _, err := io.CopyN(w, reader, size); err != nil {
+		return chunk, err
+	}
+	return chunk, nil
+}
+
+// writeParquetFooter writes the metadata of the file with its schema and row groups, its length and the magic
+// number that ends the file
+func writeParquetFooter(w io.Writer, rowGroups [][]parquetChunk) error {
+	metadata := newThriftWriter()
+	metadata.i32(1, 1)
+	metadata.list(2, thriftStruct, len(parquetColumns)+1)
+	metadata.element()
+	metadata.binary(4, "schema")
+	metadata.i32(5, int32(len(parquetColumns)))
+	metadata.end()
+	for _, column := range parquetColumns {
+		metadata.element()
+		metadata.i32(1, parquetType(column))
+		metadata.i32(3, parquetRequired)
+		metadata.binary(4, column)
+		if column == parquetTimestamp {
+			// logical type TIMESTAMP in UTC with unit NANOS
+			metadata.begin(10)
+			metadata.begin(8)
+			metadata.bool(1, true)
+			metadata.begin(2)
+			metadata.begin(3)
+			metadata.end()
+			metadata.end()
+			metadata.end()
+			metadata.end()
+		} else {
+			// converted type UTF8 and logical type STRING
+			metadata.i32(6, parquetUTF8)
+			metadata.begin(10)
+			metadata.begin(1)
+			metadata.end()
+			metadata.end()
+		}
+		metadata.end()
+	}
+	rows := int64(0)
+	for _, rowGroup := range rowGroups {
+		rows += rowGroup[0].values
+	}
+	metadata.i64(3, rows)
+	metadata.list(4, thriftStruct, len(rowGroups))
+	for _, rowGroup := range rowGroups {
+		metadata.element()
+		metadata.list(1, thriftStruct, len(rowGroup))
+		size := int64(0)
+		for i, chunk := range rowGroup {
+			metadata.element()
+			metadata.i64(2, chunk.offset)
+			metadata.begin(3)
+			metadata.i32(1, parquetType(parquetColumns[i]))
+			metadata.list(2, thriftI32, 1)
+			metadata.varint(parquetPlain)
+			metadata.list(3, thriftBinary, 1)
+			metadata.str(parquetColumns[i])
+			metadata.i32(4, parquetSnappy)
+			metadata.i64(5, chunk.values)
+			metadata.i64(6, chunk.uncompressed)
+			metadata.i64(7, chunk.compressed)
+			metadata.i64(9, chunk.offset)
+			metadata.end()
+			metadata.end()
+			size += chunk.uncompressed
+		}
+		metadata.i64(2, size)
+		metadata.i64(3, rowGroup[0].values)
+		metadata.end()
+	}
+	metadata.binary(6, parquetCreatedBy)
+	metadata.end()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(metadata.Len()))
+	for _, content := range [][]byte{metadata.Bytes(), length[:], []byte(parquetMagic)} {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parquetType returns the physical type of a column
+func parquetType(column string) int32 {
+	if column == parquetTimestamp {
+		return parquetInt64
+	}
+	return parquetByteArray
+}
+
+// thriftWriter writes a structure in the Thrift compact protocol
+type thriftWriter struct {
+	bytes.Buffer
+	// ids are the last field ids of the structures being written
+	ids []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{ids: []int16{0}}
+}
+
+// field writes the header of a field, with the delta from the previous field when it is small
+func (w *thriftWriter) field(id int16, kind byte) {
+	last := &w.ids[len(w.ids)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		w.WriteByte(kind)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) uvarint(value uint64) {
+	var buffer [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buffer[:], value)
+	w.Write(buffer[:n])
+}
+
+// varint writes an integer in the zigzag encoding
+func (w *thriftWriter) varint(value int64) {
+	w.uvarint(uint64(value<<1) ^ uint64(value>>63))
+}
+
+func (w *thriftWriter) str(value string) {
+	w.uvarint(uint64(len(value)))
+	w.WriteString(value)
+}
+
+func (w *thriftWriter) bool(id int16, value bool) {
+	if value {
+		w.field(id, thriftTrue)
+	} else {
+		w.field(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) i32(id int16, value int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(value))
+}
+
+func (w *thriftWriter) i64(id int16, value int64) {
+	w.field(id, thriftI64)
+	w.varint(value)
+}
+
+func (w *thriftWriter) binary(id int16, value string) {
+	w.field(id, thriftBinary)
+	w.str(value)
+}
+
+// list writes the header of a list field, followed by its elements
+func (w *thriftWriter) list(id int16, kind byte, size int) {
+	w.field(id, thriftList)
+	if size < 15 {
+		w.WriteByte(byte(size)<<4 | kind)
+	} else {
+		w.WriteByte(0xf0 | kind)
+		w.uvarint(uint64(size))
+	}
+}
+
+// begin starts a structure field, closed by end
+func (w *thriftWriter) begin(id int16) {
+	w.field(id, thriftStruct)
+	w.element()
+}
+
+// element starts a structure in a list, closed by end
+func (w *thriftWriter) element() {
+	w.ids = append(w.ids, 0)
+}
+
+// end closes the structure being written
+func (w *thriftWriter) end() {
+	w.WriteByte(0)
+	w.ids = w.ids[:len(w.ids)-1]
+}
+
+// thriftReader reads a structure in the Thrift compact protocol
+type thriftReader struct {
+	io.ByteReader
+}
+
+// varint reads an integer in the zigzag encoding
+func (r thriftReader) varint() (int64, error) {
+	value, err := binary.ReadUvarint(r)
+	return int64(value>>1) ^ -int64(value&1), err
+}
+
+// readStruct reads the fields of a structure, calling the read function with the id and the type of each one
+func (r thriftReader) readStruct(read func(id int16, kind byte) error) error {
+	last := int16(0)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if header == 0 {
+			return nil
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			value, err := r.varint()
+			if err != nil {
+				return err
+			}
+			id = int16(value)
+		}
+		if err := read(id, header&0x0f); err != nil {
+			return err
+		}
+		last = id
+	}
+}
+
+// value reads a value of the given type: a bool, an int64, a []byte, a []interface{} with the elements of a list
+// or a map[int16]interface{} with the fields of a structure
+func (r thriftReader) value(kind byte) (interface{}, error) {
+	switch kind {
+	case thriftTrue, thriftFalse:
+		return kind == thriftTrue, nil
+	case thriftByte:
+		value, err := r.ReadByte()
+		return int64(int8(value)), err
+	case thriftI16, thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, 0, size)
+		for i := uint64(0); i < size; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			value = append(value, b)
+		}
+		return value, nil
+	case thriftList:
+		header, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		size := uint64(header >> 4)
+		if size == 15 {
+			if size, err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		values := make([]interface{}, 0)
+		for i := uint64(0); i < size; i++ {
+			kind := header & 0x0f
+			if kind == thriftTrue || kind == thriftFalse {
+				// the bools of a list take a byte
+				kind = thriftByte
+			}
+			value, err := r.value(kind)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case thriftStruct:
+		fields := make(map[int16]interface{})
+		err := r.readStruct(func(id int16, kind byte) error {
+			value, err := r.value(kind)
+			fields[id] = value
+			return err
+		})
+		return fields, err
+	}
+	return nil, fmt.Errorf("unsupported thrift type %d", kind)
+}
+
+// recordingReader keeps the bytes read, so the page headers are copied as they are read
+type recordingReader struct {
+	io.ByteReader
+	read *bytes.Buffer
+}
+
+func (r recordingReader) ReadByte() (byte, error) {
+	b, err := r.ByteReader.ReadByte()
+	if err == nil {
+		r.read.WriteByte(b)
+	}
+	return b, err
+}
+
+// GetParquetFilePath returns the path of the Parquet file written for a file of the archive of an operation
+func GetParquetFilePath(filesDirectory string, requestId string, file int) string {
+	return fmt.Sprintf("%s%s.%d.parquet", filesDirectory, requestId, file)
+}
+
+// GetParquetFilePaths returns the paths of the Parquet files of an operation found in the directory
+func GetParquetFilePaths(filesDirectory string, requestId string) []string {
+	paths, err := filepath.Glob(fmt.Sprintf("%s%s.*.parquet", filesDirectory, requestId))
+	if err != nil {
+		return []string{}
+	}
+	return paths
+}
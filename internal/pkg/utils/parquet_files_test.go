@@ -0,0 +1,178 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/klauspost/compress/s2"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+const parquetTestDir = "./parquetTestDir/"
+
+// parquetContent is the content of a Parquet file read in the tests
+type parquetContent struct {
+	rows       int64
+	rowGroups  int
+	schema     []string
+	timestamps []int64
+	columns    map[string][]string
+}
+
+// readParquet reads a Parquet file with the pages found through its metadata
+func readParquet(path string) parquetContent {
+	data, err := ioutil.ReadFile(path)
+	gomega.Expect(err).To(gomega.Succeed())
+	gomega.Expect(string(data)).Should(gomega.HavePrefix(parquetMagic))
+	gomega.Expect(string(data)).Should(gomega.HaveSuffix(parquetMagic))
+	length := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-length : len(data)-8]
+	value, err := thriftReader{bytes.NewReader(footer)}.value(thriftStruct)
+	gomega.Expect(err).To(gomega.Succeed())
+	metadata := value.(map[int16]interface{})
+
+	content := parquetContent{rows: metadata[3].(int64), columns: make(map[string][]string)}
+	for _, element := range metadata[2].([]interface{})[1:] {
+		content.schema = append(content.schema, string(element.(map[int16]interface{})[4].([]byte)))
+	}
+	for _, rowGroup := range metadata[4].([]interface{}) {
+		content.rowGroups++
+		for _, chunk := range rowGroup.(map[int16]interface{})[1].([]interface{}) {
+			chunkMetadata := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			column := string(chunkMetadata[3].([]interface{})[0].([]byte))
+			reader := bytes.NewReader(data[chunkMetadata[9].(int64):])
+			header, err := thriftReader{reader}.value(thriftStruct)
+			gomega.Expect(err).To(gomega.Succeed())
+			start := len(data) - reader.Len()
+			compressed := data[start : start+int(header.(map[int16]interface{})[3].(int64))]
+			values, err := s2.Decode(nil, compressed)
+			gomega.Expect(err).To(gomega.Succeed())
+			for len(values) > 0 {
+				if column == parquetTimestamp {
+					content.timestamps = append(content.timestamps, int64(binary.LittleEndian.Uint64(values)))
+					values = values[8:]
+					continue
+				}
+				size := int(binary.LittleEndian.Uint32(values))
+				content.columns[column] = append(content.columns[column], string(values[4:4+size]))
+				values = values[4+size:]
+			}
+		}
+	}
+	return content
+}
+
+var _ = ginkgo.Describe("Parquet files", func() {
+
+	format := EntryFormat{Format: grpc_log_download_manager_go.OutputFormat_PARQUET}
+	responses := []*grpc_application_manager_go.LogEntryResponse{
+		{Timestamp: 1571000000123456789, AppDescriptorId: "d1", AppDescriptorName: "shop", AppInstanceId: "i1",
+			AppInstanceName: "shop-prod", ServiceGroupId: "g1", ServiceGroupName: "front", ServiceGroupInstanceId: "gi1",
+			ServiceId: "s1", ServiceName: "cart", ServiceInstanceId: "si1", Msg: "with \"quotes\"\nand lines"},
+		{Timestamp: 1571000000999999999, AppDescriptorId: "d1", AppDescriptorName: "shop", Msg: "second"},
+		{Timestamp: 1571000001000000000, ServiceName: "cart", Msg: "third"},
+	}
+	// writeSegment writes a segment with a row group for each batch of entries
+	writeSegment := func(path string, batches ...[]*grpc_application_manager_go.LogEntryResponse) {
+		f, err := os.Create(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		defer f.Close()
+		for _, batch := range batches {
+			_, err := format.WriteEntries(f, batch)
+			gomega.Expect(err).To(gomega.Succeed())
+		}
+	}
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(parquetTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(parquetTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should write the entries with their typed fields", func() {
+		first := GetSegmentPath(parquetTestDir, "id", 0)
+		second := GetSegmentPath(parquetTestDir, "id", 1)
+		writeSegment(first, responses[:2])
+		writeSegment(second, responses[2:])
+		target := GetParquetFilePath(parquetTestDir, "id", 0)
+		gomega.Expect(WriteParquetFile(target, []string{first, second})).To(gomega.Succeed())
+		gomega.Expect(GetParquetFilePaths(parquetTestDir, "id")).Should(gomega.HaveLen(1))
+
+		content := readParquet(target)
+		gomega.Expect(content.rows).Should(gomega.Equal(int64(3)))
+		gomega.Expect(content.rowGroups).Should(gomega.Equal(2))
+		gomega.Expect(content.schema).Should(gomega.Equal(parquetColumns))
+		gomega.Expect(content.timestamps).Should(gomega.Equal([]int64{1571000000123456789, 1571000000999999999, 1571000001000000000}))
+		gomega.Expect(content.columns["msg"]).Should(gomega.Equal([]string{"with \"quotes\"\nand lines", "second", "third"}))
+		gomega.Expect(content.columns["app_instance_name"]).Should(gomega.Equal([]string{"shop-prod", "", ""}))
+		gomega.Expect(content.columns["service_name"]).Should(gomega.Equal([]string{"cart", "", "cart"}))
+		gomega.Expect(content.columns["service_instance_id"]).Should(gomega.Equal([]string{"si1", "", ""}))
+	})
+
+	ginkgo.It("should write a row group each time the entries are written", func() {
+		segment := GetSegmentPath(parquetTestDir, "id", 0)
+		writeSegment(segment, responses[:1], nil, responses[1:])
+		target := GetParquetFilePath(parquetTestDir, "id", 0)
+		gomega.Expect(WriteParquetFile(target, []string{segment})).To(gomega.Succeed())
+		content := readParquet(target)
+		gomega.Expect(content.rows).Should(gomega.Equal(int64(3)))
+		gomega.Expect(content.rowGroups).Should(gomega.Equal(2))
+	})
+
+	ginkgo.It("should write a file without entries", func() {
+		segment := GetSegmentPath(parquetTestDir, "id", 0)
+		writeSegment(segment)
+		target := GetParquetFilePath(parquetTestDir, "id", 0)
+		gomega.Expect(WriteParquetFile(target, []string{segment})).To(gomega.Succeed())
+		content := readParquet(target)
+		gomega.Expect(content.rows).Should(gomega.Equal(int64(0)))
+		gomega.Expect(content.rowGroups).Should(gomega.Equal(0))
+		gomega.Expect(content.schema).Should(gomega.Equal(parquetColumns))
+	})
+
+	ginkgo.It("should fail with an incomplete row group", func() {
+		segment := GetSegmentPath(parquetTestDir, "id", 0)
+		writeSegment(segment, responses)
+		info, err := os.Stat(segment)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(os.Truncate(segment, info.Size()-1)).To(gomega.Succeed())
+		err = WriteParquetFile(GetParquetFilePath(parquetTestDir, "id", 0), []string{segment})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should fail with an entry of other format", func() {
+		segment := GetSegmentPath(parquetTestDir, "id", 0)
+		gomega.Expect(WriteFile(segment, "[2019-10-13][DESCRIPTOR-shop]:msg\n")).To(gomega.Succeed())
+		err := WriteParquetFile(GetParquetFilePath(parquetTestDir, "id", 0), []string{segment})
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should take the size of the values before compressing them", func() {
+		// the timestamp and the length of each string take 8 + 11 * 4 bytes
+		gomega.Expect(format.Size(responses[1])).Should(gomega.Equal(52 + len("d1shopsecond")))
+		gomega.Expect(format.Size(responses[2])).Should(gomega.Equal(52 + len("cartthird")))
+	})
+})
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PartLimits contains the maximum entries and bytes of each part file, 0 means unlimited
+type PartLimits struct {
+	MaxEntries int64
+	MaxBytes   int64
+}
+
+// NewPartLimits returns the part limits of a request
+func NewPartLimits(request *grpc_log_download_manager_go.DownloadLogRequest) PartLimits {
+	return PartLimits{MaxEntries: request.PartMaxEntries, MaxBytes: request.PartMaxBytes}
+}
+
+// Enabled checks if the output is split in part files
+func (l PartLimits) Enabled() bool {
+	return l.MaxEntries > 0 || l.MaxBytes > 0
+}
+
+// full checks if a part with the given content cannot hold another line. A part always holds at least one line,
+// even if it is larger than the limit.
+func (l PartLimits) full(entries int64, bytes int64, line int) bool {
+	if entries == 0 {
+		return false
+	}
+	return (l.MaxEntries > 0 && entries+1 > l.MaxEntries) || (l.MaxBytes > 0 && bytes+int64(line) > l.MaxBytes)
+}
+
+// PartPosition is the part file being written
+type PartPosition struct {
+	// Part is the index of the part, starting at 0
+	Part int
+	// Entries and Bytes are the content of the part
+	Entries int64
+	Bytes   int64
+	// PreviousBytes is the size of the parts already finished
+	PreviousBytes int64
+}
+
+// AppendPartResponses appends the responses in the given format to the part files of a segment, rolling to a new
+// part (starting with the header of the format) each time the current one reaches the limits. The entries of each
+// part are written together, a row group in the Parquet format, so the limits are checked with the size of the
+// entries not written yet. The path function returns the path of each part. Returns the part being written.
+func AppendPartResponses(responses []*grpc_application_manager_go.LogEntryResponse, path func(part int) string, position PartPosition,
+	limits PartLimits, format EntryFormat) (PartPosition, error) {
+	f, err := os.OpenFile(path(position.Part), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return position, err
+	}
+	batch := make([]*grpc_application_manager_go.LogEntryResponse, 0, len(responses))
+	pending := int64(0)
+	for _, response := range responses {
+		size := format.Size(response)
+		if limits.full(position.Entries, position.Bytes+pending, size) {
+			written, err := format.WriteEntries(f, batch)
+			if err != nil {
+				f.Close()
+				return position, err
+			}
+			if err := f.Close(); err != nil {
+				return position, err
+			}
+			position = PartPosition{Part: position.Part + 1, PreviousBytes: position.PreviousBytes + position.Bytes + written}
+			batch, pending = batch[:0], 0
+			// the part may exist if the generation was interrupted after rolling to it
+			f, err = os.Create(path(position.Part))
+			if err != nil {
+				return position, err
+			}
+			header := format.Header()
+			if _, err := f.WriteString(header); err != nil {
+				f.Close()
+				return position, err
+			}
+			position.Bytes = int64(len(header))
+		}
+		batch = append(batch, response)
+		pending += int64(size)
+		position.Entries++
+	}
+	written, err := format.WriteEntries(f, batch)
+	position.Bytes += written
+	if err != nil {
+		f.Close()
+		return position, err
+	}
+	return position, f.Close()
+}
+
+// GetSegmentPartPath returns the path of a part of the segment of a shard, the first part is the segment file
+func GetSegmentPartPath(filesDirectory string, requestId string, shard int, part int) string {
+	if part == 0 {
+		return GetSegmentPath(filesDirectory, requestId, shard)
+	}
+	return fmt.Sprintf("%s%s.%d.%d.segment", filesDirectory, requestId, shard, part)
+}
+
+// PartFileName returns the name in the archive of a part of a file, numbered from 1
+func PartFileName(name string, part int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s.part-%05d%s", strings.TrimSuffix(name, ext), part, ext)
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+const partsTestDir = "./partsTestDir/"
+
+var _ = ginkgo.Describe("Part files", func() {
+
+	partPath := func(part int) string {
+		return GetSegmentPartPath(partsTestDir, "request", 0, part)
+	}
+	// entries returns n entries of 8 bytes ("entry N\n")
+	entries := func(n int) []*grpc_application_manager_go.LogEntryResponse {
+		result := make([]*grpc_application_manager_go.LogEntryResponse, 0, n)
+		for i := 0; i < n; i++ {
+			result = append(result, &grpc_application_manager_go.LogEntryResponse{Msg: fmt.Sprintf("entry %d", i)})
+		}
+		return result
+	}
+	content := func(part int) string {
+		data, err := ioutil.ReadFile(partPath(part))
+		gomega.Expect(err).To(gomega.Succeed())
+		return string(data)
+	}
+
+	ginkgo.BeforeEach(func() {
+		gomega.Expect(os.MkdirAll(partsTestDir, os.ModePerm)).To(gomega.Succeed())
+		gomega.Expect(InitializeFile(partPath(0), false)).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		gomega.Expect(os.RemoveAll(partsTestDir)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should roll the parts by number of entries", func() {
+		limits := PartLimits{MaxEntries: 2}
+		position, err := AppendPartResponses(entries(3), partPath, PartPosition{}, limits, EntryFormat{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position).Should(gomega.Equal(PartPosition{Part: 1, Entries: 1, Bytes: 8, PreviousBytes: 16}))
+
+		// a new page continues the last part
+		position, err = AppendPartResponses(entries(2), partPath, position, limits, EntryFormat{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position.Part).Should(gomega.Equal(2))
+		gomega.Expect(content(0)).Should(gomega.Equal("entry 0\nentry 1\n"))
+		gomega.Expect(content(1)).Should(gomega.Equal("entry 2\nentry 0\n"))
+		gomega.Expect(content(2)).Should(gomega.Equal("entry 1\n"))
+	})
+
+	ginkgo.It("should roll the parts by size", func() {
+		position, err := AppendPartResponses(entries(3), partPath, PartPosition{}, PartLimits{MaxBytes: 20}, EntryFormat{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position.Part).Should(gomega.Equal(1))
+		gomega.Expect(content(0)).Should(gomega.Equal("entry 0\nentry 1\n"))
+
+		// an entry larger than the limit fills a part
+		position, err = AppendPartResponses(entries(2), partPath, position, PartLimits{MaxBytes: 4}, EntryFormat{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position.Part).Should(gomega.Equal(3))
+		gomega.Expect(content(2)).Should(gomega.Equal("entry 0\n"))
+	})
+
+	ginkgo.It("should not roll the parts without limits", func() {
+		position, err := AppendPartResponses(entries(5), partPath, PartPosition{}, PartLimits{}, EntryFormat{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position.Part).Should(gomega.Equal(0))
+		gomega.Expect(position.Entries).Should(gomega.Equal(int64(5)))
+	})
+
+	ginkgo.It("should number the names of the parts", func() {
+		gomega.Expect(PartFileName("request.file", 1)).Should(gomega.Equal("request.part-00001.file"))
+		gomega.Expect(PartFileName("shop_shop-1.log", 12)).Should(gomega.Equal("shop_shop-1.part-00012.log"))
+	})
+
+	ginkgo.It("should start the new parts with the header of the format", func() {
+		format := EntryFormat{Format: grpc_log_download_manager_go.OutputFormat_CSV, Columns: []string{"msg"}}
+		position, err := AppendPartResponses(entries(3), partPath, PartPosition{}, PartLimits{MaxEntries: 2}, format)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position).Should(gomega.Equal(PartPosition{Part: 1, Entries: 1, Bytes: 12, PreviousBytes: 16}))
+		gomega.Expect(content(1)).Should(gomega.Equal("msg\nentry 2\n"))
+	})
+
+	ginkgo.It("should write the entries of each part in a row group of the Parquet format", func() {
+		format := EntryFormat{Format: grpc_log_download_manager_go.OutputFormat_PARQUET}
+		position, err := AppendPartResponses(entries(3), partPath, PartPosition{}, PartLimits{MaxEntries: 2}, format)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(position.Part).Should(gomega.Equal(1))
+		gomega.Expect(position.Entries).Should(gomega.Equal(int64(1)))
+		gomega.Expect(position.PreviousBytes).Should(gomega.Equal(int64(len(content(0)))))
+		gomega.Expect(position.Bytes).Should(gomega.Equal(int64(len(content(1)))))
+
+		target := GetParquetFilePath(partsTestDir, "request", 0)
+		gomega.Expect(WriteParquetFile(target, []string{partPath(0)})).To(gomega.Succeed())
+		written := readParquet(target)
+		gomega.Expect(written.rowGroups).Should(gomega.Equal(1))
+		gomega.Expect(written.columns["msg"]).Should(gomega.Equal([]string{"entry 0", "entry 1"}))
+	})
+})
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"os"
+)
+
+// Quota limits the download operations of an organization or a user. A zero value means no limit.
+type Quota struct {
+	// MaxConcurrent is the maximum number of operations queued or generating
+	MaxConcurrent int
+	// MaxReady is the maximum number of zip files ready to download
+	MaxReady int
+	// MaxBytes is the maximum size of the zip files stored
+	MaxBytes int64
+}
+
+// Validate checks that the limits are not negative
+func (q Quota) Validate() derrors.Error {
+	if q.MaxConcurrent < 0 || q.MaxReady < 0 || q.MaxBytes < 0 {
+		return derrors.NewInvalidArgumentError("quota limits cannot be negative").
+			WithParams(q.MaxConcurrent, q.MaxReady, q.MaxBytes)
+	}
+	return nil
+}
+
+// QuotaUsage contains the resources held by an organization or a user
+type QuotaUsage struct {
+	Concurrent int
+	Ready      int
+	Bytes      int64
+}
+
+// NewQuotaUsage computes the usage of a set of operations. The size of the zip files is read from the directory.
+func NewQuotaUsage(operations []*DownloadOperation, directory string) QuotaUsage {
+	usage := QuotaUsage{}
+	for _, ope := range operations {
+		switch ope.State {
+		case Queue, Generating:
+			usage.Concurrent++
+		case Ready:
+			usage.Ready++
+		}
+		if ope.State == Ready || ope.State == Downloaded {
+			info, err := os.Stat(GetArchiveFilePath(directory, ope.RequestId, ope.ArchiveExtension()))
+			if err == nil {
+				usage.Bytes += info.Size()
+			}
+		}
+	}
+	return usage
+}
+
+// Check returns a ResourceExhausted error if a new operation exceeds the quota. The scope (organization or user)
+// is included in the message and its identifier in the parameters.
+func (q Quota) Check(usage QuotaUsage, scope string, id string) derrors.Error {
+	if q.MaxConcurrent > 0 && usage.Concurrent >= q.MaxConcurrent {
+		return derrors.NewResourceExhaustedError("maximum number of concurrent download operations reached for the "+scope).
+			WithParams(id, usage.Concurrent, q.MaxConcurrent)
+	}
+	if q.MaxReady > 0 && usage.Ready >= q.MaxReady {
+		return derrors.NewResourceExhaustedError("maximum number of files ready to download reached for the "+scope).
+			WithParams(id, usage.Ready, q.MaxReady)
+	}
+	if q.MaxBytes > 0 && usage.Bytes >= q.MaxBytes {
+		return derrors.NewResourceExhaustedError("maximum size of the stored files reached for the "+scope).
+			WithParams(id, usage.Bytes, q.MaxBytes)
+	}
+	return nil
+}
+
+// ToGRPC converts the usage and the limits of a quota to the gRPC structure
+func (u QuotaUsage) ToGRPC(quota Quota) *grpc_log_download_manager_go.QuotaUsage {
+	return &grpc_log_download_manager_go.QuotaUsage{
+		ConcurrentOperations:    int32(u.Concurrent),
+		MaxConcurrentOperations: int32(quota.MaxConcurrent),
+		ReadyArtifacts:          int32(u.Ready),
+		MaxReadyArtifacts:       int32(quota.MaxReady),
+		ArtifactBytes:           u.Bytes,
+		MaxArtifactBytes:        quota.MaxBytes,
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+const quotaTestDir = "./quotaTestDir/"
+
+var _ = ginkgo.Describe("Quotas", func() {
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(quotaTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(quotaTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	operations := []*DownloadOperation{
+		{RequestId: "queued", State: Queue},
+		{RequestId: "generating", State: Generating},
+		{RequestId: "ready", State: Ready},
+		{RequestId: "downloaded", State: Downloaded},
+		{RequestId: "error", State: Error},
+	}
+
+	ginkgo.It("should compute the usage of the operations", func() {
+		err := ioutil.WriteFile(GetZipFilePath(quotaTestDir, "ready"), make([]byte, 100), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = ioutil.WriteFile(GetZipFilePath(quotaTestDir, "downloaded"), make([]byte, 50), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		usage := NewQuotaUsage(operations, quotaTestDir)
+		gomega.Expect(usage).Should(gomega.Equal(QuotaUsage{Concurrent: 2, Ready: 1, Bytes: 150}))
+	})
+
+	ginkgo.It("should reject the operations exceeding the quota", func() {
+		usage := QuotaUsage{Concurrent: 2, Ready: 1, Bytes: 150}
+
+		gomega.Expect(Quota{}.Check(usage, "organization", "org1")).To(gomega.Succeed())
+		gomega.Expect(Quota{MaxConcurrent: 3, MaxReady: 2, MaxBytes: 200}.Check(usage, "organization", "org1")).To(gomega.Succeed())
+
+		for _, quota := range []Quota{{MaxConcurrent: 2}, {MaxReady: 1}, {MaxBytes: 150}} {
+			err := quota.Check(usage, "user", "user1")
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(err.Type()).Should(gomega.Equal(derrors.ResourceExhausted))
+		}
+	})
+})
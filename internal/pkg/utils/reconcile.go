@@ -0,0 +1,204 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/google/uuid"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// InterruptedMsg is the info of the operations interrupted by a restart
+	InterruptedMsg = "operation interrupted by a service restart"
+	// MissingFileMsg is the info of the ready operations whose zip file is lost
+	MissingFileMsg = "zip file not found"
+	// ResumedMsg is the info of the operations queued again after a restart
+	ResumedMsg = "operation resumed after a service restart"
+)
+
+const (
+	fileExtension      = ".file"
+	segmentExtension   = ".segment"
+	truncatedExtension = ".truncated"
+	parquetExtension   = ".parquet"
+)
+
+// ReconcileReport summarizes the changes done reconciling the download directory with the stored operations
+type ReconcileReport struct {
+	// Reattached contains the ready operations whose zip file is still available
+	Reattached []string
+	// Resumable contains the queued or generating operations that can continue from their checkpoint,
+	// they are queued again
+	Resumable []*DownloadOperation
+	// Interrupted contains the queued or generating operations marked as failed
+	Interrupted []string
+	// Missing contains the ready operations marked as failed because the zip file was lost
+	Missing []string
+	// Orphans contains the files removed because they do not belong to any operation
+	Orphans []string
+}
+
+// Print logs the report
+func (r *ReconcileReport) Print() {
+	log.Info().Int("reattached", len(r.Reattached)).Int("resumable", len(r.Resumable)).Int("interrupted", len(r.Interrupted)).
+		Int("missing", len(r.Missing)).Int("orphans", len(r.Orphans)).Msg("download directory reconciled")
+	if len(r.Interrupted) > 0 {
+		log.Info().Strs("requestIds", r.Interrupted).Msg("interrupted operations marked as failed")
+	}
+	if len(r.Missing) > 0 {
+		log.Info().Strs("requestIds", r.Missing).Msg("ready operations without zip file marked as failed")
+	}
+	if len(r.Orphans) > 0 {
+		log.Info().Strs("files", r.Orphans).Msg("orphan files removed")
+	}
+}
+
+// fileExists checks if a regular file exists
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// resume prepares an interrupted operation to continue from its checkpoint: the data appended to the file
+// and the segments (or their last parts) after the checkpoint is discarded and the operation is queued again.
+// Returns false if it cannot be resumed.
+func resume(store OperationStore, ope *DownloadOperation) (bool, derrors.Error) {
+	if ope.Checkpoint == nil {
+		return false, nil
+	}
+	sizes := map[string]int64{GetFilePath(ope.Directory, ope.RequestId): ope.Checkpoint.Bytes}
+	for i, shard := range ope.Checkpoint.Shards {
+		path := GetSegmentPartPath(ope.Directory, ope.RequestId, i, shard.Part)
+		if shard.Bytes == 0 && !fileExists(path) {
+			// the shard has not been started
+			continue
+		}
+		sizes[path] = shard.Bytes
+	}
+	for path, size := range sizes {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() || info.Size() < size {
+			return false, nil
+		}
+	}
+	for path, size := range sizes {
+		if err := os.Truncate(path, size); err != nil {
+			log.Warn().Str("requestId", ope.RequestId).Str("err", err.Error()).Msg("cannot truncate file to the checkpoint")
+			return false, nil
+		}
+	}
+	if uErr := store.Update(ope.RequestId, Queue, ResumedMsg); uErr != nil {
+		return false, uErr
+	}
+	ope.State = Queue
+	ope.Info = ResumedMsg
+	return true, nil
+}
+
+// Reconcile compares the files found in the download directory with the operations of the store:
+// ready operations are kept if their zip file exists, queued and generating operations (interrupted
+// by a restart) are queued again if they have a checkpoint or marked as failed otherwise, and the files
+// that do not belong to any live artifact or resumable operation are removed.
+func Reconcile(store OperationStore, directory string) (*ReconcileReport, derrors.Error) {
+	report := &ReconcileReport{
+		Reattached:  make([]string, 0),
+		Resumable:   make([]*DownloadOperation, 0),
+		Interrupted: make([]string, 0),
+		Missing:     make([]string, 0),
+		Orphans:     make([]string, 0),
+	}
+
+	operations, err := store.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// artifacts contains the files that must be kept
+	artifacts := make(map[string]bool, 0)
+
+	for _, ope := range operations {
+		switch ope.State {
+		case Queue, Generating:
+			resumable, uErr := resume(store, ope)
+			if uErr != nil {
+				return nil, uErr
+			}
+			if resumable {
+				artifacts[filepath.Base(GetFilePath(ope.Directory, ope.RequestId))] = true
+				for i, shard := range ope.Checkpoint.Shards {
+					// the parts started after the checkpoint are removed
+					for part := 0; part <= shard.Part; part++ {
+						artifacts[filepath.Base(GetSegmentPartPath(ope.Directory, ope.RequestId, i, part))] = true
+					}
+				}
+				report.Resumable = append(report.Resumable, ope)
+				continue
+			}
+			if uErr := store.Update(ope.RequestId, Error, InterruptedMsg); uErr != nil {
+				return nil, uErr
+			}
+			report.Interrupted = append(report.Interrupted, ope.RequestId)
+		case Ready:
+			zipPath := ope.ArchivePath()
+			if fileExists(zipPath) {
+				artifacts[filepath.Base(zipPath)] = true
+				report.Reattached = append(report.Reattached, ope.RequestId)
+			} else {
+				if uErr := store.Update(ope.RequestId, Error, MissingFileMsg); uErr != nil {
+					return nil, uErr
+				}
+				report.Missing = append(report.Missing, ope.RequestId)
+			}
+		case Downloaded:
+			// the zip file is removed when the operation expires
+			artifacts[filepath.Base(ope.ArchivePath())] = true
+		}
+	}
+
+	files, rErr := ioutil.ReadDir(directory)
+	if rErr != nil {
+		return nil, derrors.AsError(rErr, "cannot read download directory")
+	}
+	for _, file := range files {
+		if file.IsDir() || artifacts[file.Name()] {
+			continue
+		}
+		ext := filepath.Ext(file.Name())
+		if ext != fileExtension && ext != segmentExtension && ext != truncatedExtension && ext != parquetExtension &&
+			archiveExtension(file.Name()) == "" {
+			continue
+		}
+		// only the files named after an operation are managed by the service (<request_id>[.<shard>[.<part>]].<ext>)
+		requestId := strings.SplitN(strings.TrimSuffix(file.Name(), ext), ".", 2)[0]
+		if _, pErr := uuid.Parse(requestId); pErr != nil {
+			continue
+		}
+		path := filepath.Join(directory, file.Name())
+		if err := RemoveFile(path); err != nil {
+			log.Warn().Str("file", path).Msg("error deleting orphan file")
+			continue
+		}
+		report.Orphans = append(report.Orphans, file.Name())
+	}
+
+	return report, nil
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/google/uuid"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+)
+
+const reconcileTestDir = "./reconcileTestDir/"
+
+var _ = ginkgo.Describe("Reconcile download directory", func() {
+
+	var store *DownloadCache
+	var organizationID string
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(reconcileTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+		var sErr error
+		store, sErr = NewPersistentDownloadCache("/test/", "nalej.tech", reconcileTestDir, NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(sErr).To(gomega.Succeed())
+		organizationID = uuid.New().String()
+	})
+	ginkgo.AfterEach(func() {
+		gomega.Expect(store.Close()).To(gomega.Succeed())
+		err := os.RemoveAll(reconcileTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	addOperation := func(state DownloadLogState) string {
+		requestID := uuid.New().String()
+		_, err := store.Add(organizationID, requestID, 0, 0, reconcileTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = store.Update(requestID, state, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		return requestID
+	}
+	createFile := func(path string) {
+		gomega.Expect(InitializeFile(path, false)).To(gomega.Succeed())
+	}
+
+	ginkgo.It("should reattach, fail and clean the operations", func() {
+		ready := addOperation(Ready)
+		createFile(GetZipFilePath(reconcileTestDir, ready))
+
+		missing := addOperation(Ready)
+
+		generating := addOperation(Generating)
+		createFile(GetFilePath(reconcileTestDir, generating))
+
+		resumable := addOperation(Generating)
+		createFile(GetFilePath(reconcileTestDir, resumable))
+		info, sErr := os.Stat(GetFilePath(reconcileTestDir, resumable))
+		gomega.Expect(sErr).To(gomega.Succeed())
+		checkpoint := Checkpoint{Request: &grpc_log_download_manager_go.DownloadLogRequest{OrganizationId: organizationID}, Bytes: info.Size()}
+		gomega.Expect(store.SaveCheckpoint(resumable, checkpoint, GenerationProgress{})).To(gomega.Succeed())
+		gomega.Expect(AppendResponses([]*grpc_application_manager_go.LogEntryResponse{{Msg: "msg"}},
+			GetFilePath(reconcileTestDir, resumable), false)).To(gomega.Succeed())
+
+		orphan := uuid.New().String()
+		createFile(GetZipFilePath(reconcileTestDir, orphan))
+		unmanaged := filepath.Join(reconcileTestDir, "unmanaged.zip")
+		createFile(unmanaged)
+
+		report, err := Reconcile(store, reconcileTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(report.Reattached).Should(gomega.ConsistOf(ready))
+		gomega.Expect(report.Missing).Should(gomega.ConsistOf(missing))
+		gomega.Expect(report.Interrupted).Should(gomega.ConsistOf(generating))
+		gomega.Expect(report.Resumable).Should(gomega.HaveLen(1))
+		gomega.Expect(report.Resumable[0].RequestId).Should(gomega.Equal(resumable))
+		gomega.Expect(report.Orphans).Should(gomega.ConsistOf(generating+".file", orphan+".zip"))
+
+		ope, err := store.Get(generating)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Error))
+		gomega.Expect(ope.Info).Should(gomega.Equal(InterruptedMsg))
+
+		ope, err = store.Get(resumable)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Queue))
+		gomega.Expect(ope.Checkpoint).ShouldNot(gomega.BeNil())
+		info, sErr = os.Stat(GetFilePath(reconcileTestDir, resumable))
+		gomega.Expect(sErr).To(gomega.Succeed())
+		gomega.Expect(info.Size()).Should(gomega.Equal(checkpoint.Bytes))
+
+		ope, err = store.Get(missing)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Error))
+
+		gomega.Expect(fileExists(GetZipFilePath(reconcileTestDir, ready))).Should(gomega.BeTrue())
+		gomega.Expect(fileExists(GetZipFilePath(reconcileTestDir, orphan))).Should(gomega.BeFalse())
+		gomega.Expect(fileExists(unmanaged)).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should resume the operations from the last part of their segments", func() {
+		resumable := addOperation(Generating)
+		createFile(GetFilePath(reconcileTestDir, resumable))
+		for part := 0; part < 3; part++ {
+			createFile(GetSegmentPartPath(reconcileTestDir, resumable, 0, part))
+			gomega.Expect(AppendResponses([]*grpc_application_manager_go.LogEntryResponse{{Msg: "msg"}},
+				GetSegmentPartPath(reconcileTestDir, resumable, 0, part), false)).To(gomega.Succeed())
+		}
+		// the third part was started after the checkpoint, and the second one has grown since then
+		checkpoint := Checkpoint{Request: &grpc_log_download_manager_go.DownloadLogRequest{OrganizationId: organizationID},
+			Shards: []ShardCheckpoint{{Part: 1, PartEntries: 0, PartsBytes: 4}}}
+		gomega.Expect(store.SaveCheckpoint(resumable, checkpoint, GenerationProgress{})).To(gomega.Succeed())
+
+		report, err := Reconcile(store, reconcileTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(report.Resumable).Should(gomega.HaveLen(1))
+		gomega.Expect(report.Orphans).Should(gomega.ConsistOf(filepath.Base(GetSegmentPartPath(reconcileTestDir, resumable, 0, 2))))
+		info, sErr := os.Stat(GetSegmentPartPath(reconcileTestDir, resumable, 0, 1))
+		gomega.Expect(sErr).To(gomega.Succeed())
+		gomega.Expect(info.Size()).Should(gomega.Equal(int64(0)))
+		info, sErr = os.Stat(GetSegmentPartPath(reconcileTestDir, resumable, 0, 0))
+		gomega.Expect(sErr).To(gomega.Succeed())
+		gomega.Expect(info.Size()).Should(gomega.Equal(int64(4)))
+	})
+
+	ginkgo.It("should reattach the archives of any format", func() {
+		ready := addOperation(Ready)
+		gomega.Expect(store.SetArchive(ready, ".tar.gz")).To(gomega.Succeed())
+		createFile(GetArchiveFilePath(reconcileTestDir, ready, ".tar.gz"))
+		createFile(GetZipFilePath(reconcileTestDir, ready))
+
+		orphan := uuid.New().String()
+		createFile(GetArchiveFilePath(reconcileTestDir, orphan, ".tar.zst"))
+
+		report, err := Reconcile(store, reconcileTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(report.Reattached).Should(gomega.ConsistOf(ready))
+		gomega.Expect(report.Orphans).Should(gomega.ConsistOf(ready+".zip", orphan+".tar.zst"))
+		gomega.Expect(fileExists(GetArchiveFilePath(reconcileTestDir, ready, ".tar.gz"))).Should(gomega.BeTrue())
+	})
+})
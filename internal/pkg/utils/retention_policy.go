@@ -0,0 +1,130 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"time"
+)
+
+// RetentionPolicy defines how long the artifacts and the operations are kept
+type RetentionPolicy struct {
+	// ReadyWindow is the time the zip file is ready to download
+	ReadyWindow time.Duration
+	// MetadataRetention is the time the operation is stored once it cannot be downloaded
+	MetadataRetention time.Duration
+}
+
+// DefaultRetentionPolicy returns the policy used when nothing is configured
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{
+		ReadyWindow:       ExpirationTime,
+		MetadataRetention: AliveTime,
+	}
+}
+
+// Validate checks that all the durations are positive
+func (r RetentionPolicy) Validate() derrors.Error {
+	if r.ReadyWindow <= 0 || r.MetadataRetention <= 0 {
+		return derrors.NewInvalidArgumentError("retention durations must be positive").
+			WithParams(r.ReadyWindow.String(), r.MetadataRetention.String())
+	}
+	return nil
+}
+
+// retentionPolicyFile is the content of the file with the policies of the organizations. The durations
+// use the time.ParseDuration format and the missing ones are taken from the default policy, e.g.
+// {"organizations": {"<organization_id>": {"ready_window": "1h", "metadata_retention": "24h"}}}
+type retentionPolicyFile struct {
+	Organizations map[string]struct {
+		ReadyWindow       string `json:"ready_window"`
+		MetadataRetention string `json:"metadata_retention"`
+	} `json:"organizations"`
+}
+
+// RetentionPolicies contains the default policy and the overrides of the organizations
+type RetentionPolicies struct {
+	Default       RetentionPolicy
+	Organizations map[string]RetentionPolicy
+}
+
+// NewRetentionPolicies creates a set of policies without organization overrides
+func NewRetentionPolicies(defaultPolicy RetentionPolicy) *RetentionPolicies {
+	return &RetentionPolicies{
+		Default:       defaultPolicy,
+		Organizations: make(map[string]RetentionPolicy, 0),
+	}
+}
+
+// LoadRetentionPolicies reads the organization overrides from a policy file. If the path is empty
+// only the default policy is used.
+func LoadRetentionPolicies(defaultPolicy RetentionPolicy, path string) (*RetentionPolicies, derrors.Error) {
+	if err := defaultPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	policies := NewRetentionPolicies(defaultPolicy)
+	if path == "" {
+		return policies, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read retention policy file")
+	}
+	var file retentionPolicyFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, derrors.AsError(err, "cannot parse retention policy file")
+	}
+
+	for organizationID, override := range file.Organizations {
+		policy := defaultPolicy
+		durations := []struct {
+			value  string
+			target *time.Duration
+		}{
+			{override.ReadyWindow, &policy.ReadyWindow},
+			{override.MetadataRetention, &policy.MetadataRetention},
+		}
+		for _, d := range durations {
+			if d.value == "" {
+				continue
+			}
+			parsed, err := time.ParseDuration(d.value)
+			if err != nil {
+				return nil, derrors.AsErrorWithParams(err, "invalid duration in retention policy file", organizationID, d.value)
+			}
+			*d.target = parsed
+		}
+		if vErr := policy.Validate(); vErr != nil {
+			return nil, derrors.NewInvalidArgumentError("invalid retention policy", vErr).WithParams(organizationID)
+		}
+		policies.Organizations[organizationID] = policy
+	}
+
+	return policies, nil
+}
+
+// Get returns the policy of an organization
+func (r *RetentionPolicies) Get(organizationID string) RetentionPolicy {
+	policy, exists := r.Organizations[organizationID]
+	if !exists {
+		return r.Default
+	}
+	return policy
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const policyTestDir = "./policyTestDir/"
+
+var _ = ginkgo.Describe("Retention policies", func() {
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(policyTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(policyTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	writePolicyFile := func(content string) string {
+		path := filepath.Join(policyTestDir, "policies.json")
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+		return path
+	}
+
+	ginkgo.It("should apply the organization overrides", func() {
+		path := writePolicyFile(`{"organizations": {"org1": {"ready_window": "1h"}}}`)
+		policies, err := LoadRetentionPolicies(DefaultRetentionPolicy(), path)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		policy := policies.Get("org1")
+		gomega.Expect(policy.ReadyWindow).Should(gomega.Equal(time.Hour))
+		gomega.Expect(policy.MetadataRetention).Should(gomega.Equal(AliveTime))
+		gomega.Expect(policies.Get("org2")).Should(gomega.Equal(DefaultRetentionPolicy()))
+	})
+
+	ginkgo.It("should reject invalid durations", func() {
+		path := writePolicyFile(`{"organizations": {"org1": {"ready_window": "-1h"}}}`)
+		_, err := LoadRetentionPolicies(DefaultRetentionPolicy(), path)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		path = writePolicyFile(`{"organizations": {"org1": {"ready_window": "one hour"}}}`)
+		_, err = LoadRetentionPolicies(DefaultRetentionPolicy(), path)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should set the expiration and retention of the operation", func() {
+		policies := NewRetentionPolicies(DefaultRetentionPolicy())
+		policies.Organizations["org1"] = RetentionPolicy{ReadyWindow: time.Hour, MetadataRetention: time.Minute}
+		cache := NewDownloadCache("/test/", "nalej.tech")
+		cache.policies = policies
+
+		_, err := cache.Add("org1", "request", 0, 0, "", "")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = cache.Update("request", Ready, "")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		ope, err := cache.Get("request")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Expiration).Should(gomega.BeNumerically("~", time.Now().Add(time.Hour).UnixNano(), int64(time.Second)))
+		gomega.Expect(ope.Retention).Should(gomega.Equal(ope.Expiration + int64(time.Minute)))
+
+		gomega.Expect(ope.IsArtifactExpired(time.Now().Add(2 * time.Hour))).Should(gomega.BeTrue())
+		gomega.Expect(ope.IsExpired(time.Now().Add(time.Hour+30*time.Second), policies.Get("org1"))).Should(gomega.BeFalse())
+		gomega.Expect(ope.IsExpired(time.Now().Add(2*time.Hour), policies.Get("org1"))).Should(gomega.BeTrue())
+	})
+})
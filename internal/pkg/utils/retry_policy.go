@@ -0,0 +1,100 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/derrors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy defines how the failed Search calls are retried
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of calls for a page, including the first one
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait between two attempts
+	MaxBackoff time.Duration
+	// Multiplier is the factor applied to the wait after each retry
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) of the wait that is randomized
+	Jitter float64
+	// RetryableCodes contains the names of the gRPC codes that are retried (e.g. Unavailable)
+	RetryableCodes []string
+}
+
+// DefaultRetryableCodes are the gRPC codes of the transient errors
+var DefaultRetryableCodes = []string{codes.Unavailable.String(), codes.DeadlineExceeded.String(),
+	codes.ResourceExhausted.String(), codes.Aborted.String()}
+
+// Validate checks the values of the policy
+func (r RetryPolicy) Validate() derrors.Error {
+	if r.MaxAttempts <= 0 {
+		return derrors.NewInvalidArgumentError("the maximum number of attempts must be positive").WithParams(r.MaxAttempts)
+	}
+	if r.InitialBackoff <= 0 || r.MaxBackoff < r.InitialBackoff {
+		return derrors.NewInvalidArgumentError("the backoff must be positive and below the maximum backoff").
+			WithParams(r.InitialBackoff.String(), r.MaxBackoff.String())
+	}
+	if r.Multiplier < 1 {
+		return derrors.NewInvalidArgumentError("the backoff multiplier cannot be less than 1").WithParams(r.Multiplier)
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return derrors.NewInvalidArgumentError("the backoff jitter must be between 0 and 1").WithParams(r.Jitter)
+	}
+	for _, name := range r.RetryableCodes {
+		if _, exists := codeByName(name); !exists {
+			return derrors.NewInvalidArgumentError("unknown gRPC code").WithParams(name)
+		}
+	}
+	return nil
+}
+
+// codeByName returns the gRPC code with the given name
+func codeByName(name string) (codes.Code, bool) {
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if code.String() == name {
+			return code, true
+		}
+	}
+	return codes.Unknown, false
+}
+
+// IsRetryable checks if the gRPC code of an error is one of the retryable codes
+func (r RetryPolicy) IsRetryable(err error) bool {
+	code := status.Code(err).String()
+	for _, name := range r.RetryableCodes {
+		if name == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the wait before the given retry (starting at 1)
+func (r RetryPolicy) Backoff(retry int) time.Duration {
+	wait := float64(r.InitialBackoff) * math.Pow(r.Multiplier, float64(retry-1))
+	if wait > float64(r.MaxBackoff) {
+		wait = float64(r.MaxBackoff)
+	}
+	wait -= wait * r.Jitter * rand.Float64()
+	return time.Duration(wait)
+}
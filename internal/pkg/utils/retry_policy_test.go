@@ -0,0 +1,65 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+var _ = ginkgo.Describe("Retry policy", func() {
+
+	policy := RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+		RetryableCodes: DefaultRetryableCodes,
+	}
+
+	ginkgo.It("should validate the policy", func() {
+		gomega.Expect(policy.Validate()).To(gomega.Succeed())
+
+		invalid := policy
+		invalid.RetryableCodes = []string{"NotACode"}
+		gomega.Expect(invalid.Validate()).NotTo(gomega.Succeed())
+
+		invalid = policy
+		invalid.MaxBackoff = time.Millisecond
+		gomega.Expect(invalid.Validate()).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should only retry the transient errors", func() {
+		gomega.Expect(policy.IsRetryable(status.Error(codes.Unavailable, "unavailable"))).Should(gomega.BeTrue())
+		gomega.Expect(policy.IsRetryable(status.Error(codes.DeadlineExceeded, "timeout"))).Should(gomega.BeTrue())
+		gomega.Expect(policy.IsRetryable(status.Error(codes.InvalidArgument, "invalid"))).Should(gomega.BeFalse())
+		gomega.Expect(policy.IsRetryable(fmt.Errorf("not a gRPC error"))).Should(gomega.BeFalse())
+	})
+
+	ginkgo.It("should increase the backoff up to the maximum", func() {
+		for retry, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+			backoff := policy.Backoff(retry)
+			gomega.Expect(backoff).Should(gomega.BeNumerically("<=", expected))
+			gomega.Expect(backoff).Should(gomega.BeNumerically(">=", expected/2))
+		}
+	})
+})
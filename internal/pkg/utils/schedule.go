@@ -0,0 +1,154 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"time"
+)
+
+// Schedule is the definition of a recurring export. Each run creates a download operation of the owner with
+// the request template and the window relative to the time of the run.
+type Schedule struct {
+	OrganizationId string
+	ScheduleId     string
+	// Owner is the user of the operations created by the schedule
+	Owner string
+	// Cron is the expression with the times of the runs
+	Cron string
+	// TimeZone is the location (IANA name) of the cron expression and the relative windows, UTC if it is empty
+	TimeZone string
+	// Request is the template of the requests, its window is replaced in each run
+	Request *grpc_log_download_manager_go.DownloadLogRequest
+	// Window is the window of each run relative to the time of the run
+	Window grpc_log_download_manager_go.ScheduleWindow
+	// Period is the length of the LAST_PERIOD windows
+	Period time.Duration
+	Paused bool
+	// Created, NextRun and LastRun are times in ns, NextRun is 0 while the schedule is paused
+	Created int64
+	NextRun int64
+	LastRun int64
+	// LastRequestId and LastError are the operation created by the last run and the error, if it failed
+	LastRequestId string
+	LastError     string
+}
+
+// Location returns the location of the schedule
+func (s *Schedule) Location() (*time.Location, derrors.Error) {
+	if s.TimeZone == "" {
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(s.TimeZone)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("unknown time zone", err).WithParams(s.TimeZone)
+	}
+	return location, nil
+}
+
+// Next returns the time (ns) of the first run after the given time, 0 if there are no more runs
+func (s *Schedule) Next(after time.Time) (int64, derrors.Error) {
+	expression, err := ParseCron(s.Cron)
+	if err != nil {
+		return 0, err
+	}
+	location, err := s.Location()
+	if err != nil {
+		return 0, err
+	}
+	next := expression.Next(after.In(location))
+	if next.IsZero() {
+		return 0, nil
+	}
+	return next.UnixNano(), nil
+}
+
+// WindowLength returns the maximum length of the windows of the runs
+func (s *Schedule) WindowLength() time.Duration {
+	switch s.Window {
+	case grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_HOUR:
+		return time.Hour
+	case grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_DAY:
+		// days with a daylight saving change are one hour longer
+		return 25 * time.Hour
+	case grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_WEEK:
+		return 7*24*time.Hour + time.Hour
+	}
+	return s.Period
+}
+
+// WindowAt returns the window [from, to] (ns) of a run at the given time. The previous hour, day and week are
+// the complete periods before the one containing the run, the weeks start on Monday.
+func (s *Schedule) WindowAt(at time.Time) (int64, int64, derrors.Error) {
+	location, err := s.Location()
+	if err != nil {
+		return 0, 0, err
+	}
+	local := at.In(location)
+	var start, end time.Time
+	switch s.Window {
+	case grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_HOUR:
+		end = time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, location)
+		start = end.Add(-time.Hour)
+	case grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_DAY:
+		end = time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+		start = end.AddDate(0, 0, -1)
+	case grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_WEEK:
+		sinceMonday := (int(local.Weekday()) + 6) % 7
+		end = time.Date(local.Year(), local.Month(), local.Day()-sinceMonday, 0, 0, 0, 0, location)
+		start = end.AddDate(0, 0, -7)
+	case grpc_log_download_manager_go.ScheduleWindow_LAST_PERIOD:
+		return at.Add(-s.Period).UnixNano(), at.UnixNano(), nil
+	default:
+		return 0, 0, derrors.NewInvalidArgumentError("unknown schedule window").WithParams(s.Window)
+	}
+	// the windows are inclusive, the end belongs to the next one
+	return start.UnixNano(), end.UnixNano() - 1, nil
+}
+
+// NewRequest returns the request of a run at the given time
+func (s *Schedule) NewRequest(at time.Time) (*grpc_log_download_manager_go.DownloadLogRequest, derrors.Error) {
+	from, to, err := s.WindowAt(at)
+	if err != nil {
+		return nil, err
+	}
+	request := *s.Request
+	request.OrganizationId = s.OrganizationId
+	request.From, request.To = from, to
+	return &request, nil
+}
+
+// ToGRPC converts the schedule to its gRPC message
+func (s *Schedule) ToGRPC() *grpc_log_download_manager_go.Schedule {
+	return &grpc_log_download_manager_go.Schedule{
+		OrganizationId: s.OrganizationId,
+		ScheduleId:     s.ScheduleId,
+		Owner:          s.Owner,
+		Cron:           s.Cron,
+		TimeZone:       s.TimeZone,
+		Request:        s.Request,
+		Window:         s.Window,
+		Period:         int64(s.Period),
+		Paused:         s.Paused,
+		Created:        s.Created,
+		NextRun:        s.NextRun,
+		LastRun:        s.LastRun,
+		LastRequestId:  s.LastRequestId,
+		LastError:      s.LastError,
+	}
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// SchedulesFileName is the name of the file where the schedules are stored inside the download directory
+const SchedulesFileName = "schedules.json"
+
+// ScheduleStore keeps the definitions of the recurring exports. The stores return copies of the schedules.
+type ScheduleStore interface {
+	// Add a new schedule
+	Add(schedule *Schedule) derrors.Error
+	// Get a schedule
+	Get(scheduleId string) (*Schedule, derrors.Error)
+	// Update replaces a schedule
+	Update(schedule *Schedule) derrors.Error
+	// Remove a schedule
+	Remove(scheduleId string) derrors.Error
+	// List the schedules of an organization
+	List(organizationId string) ([]*Schedule, derrors.Error)
+	// ListAll returns the schedules of all the organizations
+	ListAll() ([]*Schedule, derrors.Error)
+}
+
+// GetSchedulesPath returns the path of the schedules file inside the download directory
+func GetSchedulesPath(filesDirectory string) string {
+	return filepath.Join(filesDirectory, SchedulesFileName)
+}
+
+// FileScheduleStore is the ScheduleStore that keeps the schedules in memory and in a JSON file, which is
+// replaced after each change. There are few schedules and they rarely change.
+type FileScheduleStore struct {
+	sync.Mutex
+	path      string
+	schedules map[string]*Schedule
+}
+
+// NewFileScheduleStore creates a store loading the schedules stored in path, if it exists
+func NewFileScheduleStore(path string) (*FileScheduleStore, derrors.Error) {
+	store := &FileScheduleStore{path: path, schedules: make(map[string]*Schedule, 0)}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, derrors.AsError(err, "cannot read schedules file")
+	}
+	list := make([]*Schedule, 0)
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, derrors.AsError(err, "cannot decode schedules file")
+	}
+	for _, schedule := range list {
+		store.schedules[schedule.ScheduleId] = schedule
+	}
+	log.Info().Str("path", path).Int("schedules", len(list)).Msg("schedules loaded")
+	return store, nil
+}
+
+// copySchedule returns a copy of a schedule, including its request template
+func copySchedule(schedule *Schedule) *Schedule {
+	result := *schedule
+	if schedule.Request != nil {
+		request := *schedule.Request
+		result.Request = &request
+	}
+	return &result
+}
+
+// save writes the schedules replacing the file, the lock must be held
+func (f *FileScheduleStore) save(schedules map[string]*Schedule) derrors.Error {
+	list := make([]*Schedule, 0, len(schedules))
+	for _, schedule := range schedules {
+		list = append(list, schedule)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ScheduleId < list[j].ScheduleId
+	})
+	content, err := json.Marshal(list)
+	if err != nil {
+		return derrors.AsError(err, "cannot encode schedules")
+	}
+	tmpPath := f.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return derrors.AsError(err, "cannot write schedules file")
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return derrors.AsError(err, "cannot replace schedules file")
+	}
+	return nil
+}
+
+// apply saves the schedules with a change and applies it in memory if they are stored, the lock must be held
+func (f *FileScheduleStore) apply(scheduleId string, schedule *Schedule) derrors.Error {
+	changed := make(map[string]*Schedule, len(f.schedules)+1)
+	for id, current := range f.schedules {
+		changed[id] = current
+	}
+	if schedule == nil {
+		delete(changed, scheduleId)
+	} else {
+		changed[scheduleId] = copySchedule(schedule)
+	}
+	if err := f.save(changed); err != nil {
+		return err
+	}
+	f.schedules = changed
+	return nil
+}
+
+func (f *FileScheduleStore) Add(schedule *Schedule) derrors.Error {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, exists := f.schedules[schedule.ScheduleId]; exists {
+		return derrors.NewAlreadyExistsError("schedule").WithParams(schedule.ScheduleId)
+	}
+	return f.apply(schedule.ScheduleId, schedule)
+}
+
+func (f *FileScheduleStore) Get(scheduleId string) (*Schedule, derrors.Error) {
+	f.Lock()
+	defer f.Unlock()
+
+	schedule, exists := f.schedules[scheduleId]
+	if !exists {
+		return nil, derrors.NewNotFoundError("schedule").WithParams(scheduleId)
+	}
+	return copySchedule(schedule), nil
+}
+
+func (f *FileScheduleStore) Update(schedule *Schedule) derrors.Error {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, exists := f.schedules[schedule.ScheduleId]; !exists {
+		return derrors.NewNotFoundError("schedule").WithParams(schedule.ScheduleId)
+	}
+	return f.apply(schedule.ScheduleId, schedule)
+}
+
+func (f *FileScheduleStore) Remove(scheduleId string) derrors.Error {
+	f.Lock()
+	defer f.Unlock()
+
+	if _, exists := f.schedules[scheduleId]; !exists {
+		return derrors.NewNotFoundError("schedule").WithParams(scheduleId)
+	}
+	return f.apply(scheduleId, nil)
+}
+
+func (f *FileScheduleStore) List(organizationId string) ([]*Schedule, derrors.Error) {
+	f.Lock()
+	defer f.Unlock()
+
+	result := make([]*Schedule, 0)
+	for _, schedule := range f.schedules {
+		if schedule.OrganizationId == organizationId {
+			result = append(result, copySchedule(schedule))
+		}
+	}
+	return result, nil
+}
+
+func (f *FileScheduleStore) ListAll() ([]*Schedule, derrors.Error) {
+	f.Lock()
+	defer f.Unlock()
+
+	result := make([]*Schedule, 0, len(f.schedules))
+	for _, schedule := range f.schedules {
+		result = append(result, copySchedule(schedule))
+	}
+	return result, nil
+}
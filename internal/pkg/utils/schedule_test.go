@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"time"
+)
+
+const scheduleTestDir = "./scheduleTestDir/"
+
+var _ = ginkgo.Describe("Schedules", func() {
+
+	// 2019-03-14 is Thursday
+	at := time.Date(2019, time.March, 14, 10, 30, 0, 0, time.UTC)
+
+	window := func(schedule *Schedule) (time.Time, time.Time) {
+		from, to, err := schedule.WindowAt(at)
+		gomega.Expect(err).To(gomega.Succeed())
+		return time.Unix(0, from).UTC(), time.Unix(0, to+1).UTC()
+	}
+
+	ginkgo.It("should resolve the relative windows", func() {
+		from, to := window(&Schedule{Window: grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_HOUR})
+		gomega.Expect(from).Should(gomega.Equal(time.Date(2019, time.March, 14, 9, 0, 0, 0, time.UTC)))
+		gomega.Expect(to).Should(gomega.Equal(time.Date(2019, time.March, 14, 10, 0, 0, 0, time.UTC)))
+
+		from, to = window(&Schedule{Window: grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_DAY})
+		gomega.Expect(from).Should(gomega.Equal(time.Date(2019, time.March, 13, 0, 0, 0, 0, time.UTC)))
+		gomega.Expect(to).Should(gomega.Equal(time.Date(2019, time.March, 14, 0, 0, 0, 0, time.UTC)))
+
+		from, to = window(&Schedule{Window: grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_WEEK})
+		gomega.Expect(from).Should(gomega.Equal(time.Date(2019, time.March, 4, 0, 0, 0, 0, time.UTC)))
+		gomega.Expect(to).Should(gomega.Equal(time.Date(2019, time.March, 11, 0, 0, 0, 0, time.UTC)))
+
+		schedule := &Schedule{Window: grpc_log_download_manager_go.ScheduleWindow_LAST_PERIOD, Period: 30 * time.Minute}
+		fromNs, toNs, err := schedule.WindowAt(at)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(toNs - fromNs).Should(gomega.Equal(int64(30 * time.Minute)))
+	})
+
+	ginkgo.It("should resolve the windows in the time zone of the schedule", func() {
+		from, _ := window(&Schedule{Window: grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_DAY, TimeZone: "Etc/GMT-2"})
+		gomega.Expect(from).Should(gomega.Equal(time.Date(2019, time.March, 12, 22, 0, 0, 0, time.UTC)))
+
+		_, _, err := (&Schedule{TimeZone: "Unknown/Zone"}).WindowAt(at)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should create the request of a run from the template", func() {
+		template := &grpc_log_download_manager_go.DownloadLogRequest{AppInstanceId: "app", From: 1, To: 2}
+		schedule := &Schedule{OrganizationId: "org", Request: template, Window: grpc_log_download_manager_go.ScheduleWindow_PREVIOUS_HOUR}
+		request, err := schedule.NewRequest(at)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(request.OrganizationId).Should(gomega.Equal("org"))
+		gomega.Expect(request.AppInstanceId).Should(gomega.Equal("app"))
+		gomega.Expect(request.To).Should(gomega.Equal(at.Truncate(time.Hour).UnixNano() - 1))
+		gomega.Expect(template.To).Should(gomega.Equal(int64(2)))
+	})
+
+	ginkgo.Context("File store", func() {
+
+		ginkgo.BeforeEach(func() {
+			gomega.Expect(os.MkdirAll(scheduleTestDir, os.ModePerm)).To(gomega.Succeed())
+		})
+		ginkgo.AfterEach(func() {
+			gomega.Expect(os.RemoveAll(scheduleTestDir)).To(gomega.Succeed())
+		})
+
+		ginkgo.It("should store the schedules after a restart", func() {
+			store, err := NewFileScheduleStore(GetSchedulesPath(scheduleTestDir))
+			gomega.Expect(err).To(gomega.Succeed())
+			schedule := &Schedule{OrganizationId: "org", ScheduleId: "s1", Cron: "@daily",
+				Request: &grpc_log_download_manager_go.DownloadLogRequest{AppInstanceId: "app"}}
+			gomega.Expect(store.Add(schedule)).To(gomega.Succeed())
+			gomega.Expect(store.Add(schedule)).NotTo(gomega.Succeed())
+			gomega.Expect(store.Add(&Schedule{OrganizationId: "other", ScheduleId: "s2"})).To(gomega.Succeed())
+
+			schedule.Paused = true
+			gomega.Expect(store.Update(schedule)).To(gomega.Succeed())
+			gomega.Expect(store.Remove("s2")).To(gomega.Succeed())
+			gomega.Expect(store.Remove("s2")).NotTo(gomega.Succeed())
+
+			reloaded, err := NewFileScheduleStore(GetSchedulesPath(scheduleTestDir))
+			gomega.Expect(err).To(gomega.Succeed())
+			list, err := reloaded.ListAll()
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).Should(gomega.HaveLen(1))
+			gomega.Expect(list[0]).Should(gomega.Equal(schedule))
+
+			list, err = reloaded.List("other")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).Should(gomega.BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+)
+
+// DefaultSchedulingWeight is the weight of the organizations without an override
+const DefaultSchedulingWeight = 1
+
+// schedulingWeightsFile is the content of the file with the weights of the organizations, e.g.
+// {"organizations": {"<organization_id>": 4}}
+type schedulingWeightsFile struct {
+	Organizations map[string]int `json:"organizations"`
+}
+
+// SchedulingWeights defines the share of the generation workers of each organization. An organization
+// with weight 2 gets twice the generations of an organization with weight 1 when both are waiting.
+type SchedulingWeights struct {
+	Default       int
+	Organizations map[string]int
+}
+
+// NewSchedulingWeights creates a set of weights without organization overrides
+func NewSchedulingWeights(defaultWeight int) *SchedulingWeights {
+	return &SchedulingWeights{
+		Default:       defaultWeight,
+		Organizations: make(map[string]int, 0),
+	}
+}
+
+// LoadSchedulingWeights reads the organization overrides from a weights file. If the path is empty
+// all the organizations have the default weight.
+func LoadSchedulingWeights(path string) (*SchedulingWeights, derrors.Error) {
+	weights := NewSchedulingWeights(DefaultSchedulingWeight)
+	if path == "" {
+		return weights, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read scheduling weights file")
+	}
+	var file schedulingWeightsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return nil, derrors.AsError(err, "cannot parse scheduling weights file")
+	}
+	for organizationID, weight := range file.Organizations {
+		if weight <= 0 {
+			return nil, derrors.NewInvalidArgumentError("scheduling weights must be positive").WithParams(organizationID, weight)
+		}
+		weights.Organizations[organizationID] = weight
+	}
+
+	return weights, nil
+}
+
+// Get returns the weight of an organization
+func (s *SchedulingWeights) Get(organizationID string) int {
+	weight, exists := s.Organizations[organizationID]
+	if !exists {
+		return s.Default
+	}
+	return weight
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const weightsTestDir = "./weightsTestDir/"
+
+var _ = ginkgo.Describe("Scheduling weights", func() {
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(weightsTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	ginkgo.AfterEach(func() {
+		err := os.RemoveAll(weightsTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	writeWeightsFile := func(content string) string {
+		path := filepath.Join(weightsTestDir, "weights.json")
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+		return path
+	}
+
+	ginkgo.It("should use the default weight without file", func() {
+		weights, err := LoadSchedulingWeights("")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(weights.Get("org1")).Should(gomega.Equal(DefaultSchedulingWeight))
+	})
+
+	ginkgo.It("should apply the organization overrides", func() {
+		path := writeWeightsFile(`{"organizations": {"org1": 4}}`)
+		weights, err := LoadSchedulingWeights(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(weights.Get("org1")).Should(gomega.Equal(4))
+		gomega.Expect(weights.Get("org2")).Should(gomega.Equal(DefaultSchedulingWeight))
+	})
+
+	ginkgo.It("should reject invalid weights", func() {
+		path := writeWeightsFile(`{"organizations": {"org1": 0}}`)
+		_, err := LoadSchedulingWeights(path)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+})
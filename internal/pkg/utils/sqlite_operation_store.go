@@ -0,0 +1,502 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	// SQLite driver (pure Go, no cgo required)
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteFileName is the default name of the database inside the download directory
+const SQLiteFileName = "operations.db"
+
+// sqliteMigrations contains the statements to create and upgrade the schema. The index of each
+// statement plus one is the schema version (stored in user_version) reached after applying it.
+var sqliteMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS operations (
+		request_id      TEXT PRIMARY KEY,
+		organization_id TEXT NOT NULL,
+		user_id         TEXT NOT NULL,
+		state           INTEGER NOT NULL,
+		started         INTEGER NOT NULL,
+		from_ts         INTEGER NOT NULL,
+		to_ts           INTEGER NOT NULL,
+		expiration      INTEGER NOT NULL,
+		info            TEXT NOT NULL,
+		url             TEXT NOT NULL,
+		directory       TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS operations_organization ON operations (organization_id)`,
+	`ALTER TABLE operations ADD COLUMN retention INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE operations ADD COLUMN progress_entries INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE operations ADD COLUMN progress_bytes INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE operations ADD COLUMN progress_pages INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE operations ADD COLUMN progress_covered REAL NOT NULL DEFAULT 0`,
+	`ALTER TABLE operations ADD COLUMN progress_retries INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE operations ADD COLUMN checkpoint TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE operations ADD COLUMN fingerprint TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS operations_fingerprint ON operations (organization_id, fingerprint)`,
+	`ALTER TABLE operations ADD COLUMN progress_targets TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE operations ADD COLUMN archive TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE operations ADD COLUMN generated INTEGER NOT NULL DEFAULT 0`,
+}
+
+const sqliteOperationColumns = `request_id, organization_id, user_id, state, started, from_ts, to_ts, expiration, info, url, directory, retention,
+	progress_entries, progress_bytes, progress_pages, progress_covered, progress_retries, checkpoint,
+	fingerprint, progress_targets, archive, generated`
+
+// SQLiteOperationStore is the OperationStore that keeps the operations in a SQLite database.
+type SQLiteOperationStore struct {
+	// Mutex serializes read-modify-write operations
+	sync.Mutex
+	db       *sql.DB
+	url      string
+	policies *RetentionPolicies
+	// scheduler removes the artifacts and the operations when they expire
+	scheduler *ExpiryScheduler
+}
+
+// GetSQLitePath returns the default path of the database inside the download directory
+func GetSQLitePath(filesDirectory string) string {
+	return filepath.Join(filesDirectory, SQLiteFileName)
+}
+
+// NewSQLiteOperationStore opens (or creates) the database stored in path
+func NewSQLiteOperationStore(url string, publicHost string, path string, policies *RetentionPolicies) (*SQLiteOperationStore, derrors.Error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot open operations database")
+	}
+	// SQLite only supports one writer
+	db.SetMaxOpenConns(1)
+
+	res := &SQLiteOperationStore{
+		db:       db,
+		url:      getDownloadURL(url, publicHost),
+		policies: policies,
+	}
+	if mErr := res.migrate(); mErr != nil {
+		db.Close()
+		return nil, mErr
+	}
+	log.Info().Str("path", path).Msg("operations database loaded")
+
+	res.scheduler = NewExpiryScheduler(res.onDeadline)
+	// schedule the stored operations, the deadlines reached while the service was down are removed now
+	if eErr := res.Expire(); eErr != nil {
+		res.Close()
+		return nil, eErr
+	}
+	return res, nil
+}
+
+// migrate applies the pending schema migrations
+func (s *SQLiteOperationStore) migrate() derrors.Error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return derrors.AsError(err, "cannot read operations database version")
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		if err := s.applyMigration(version+1, sqliteMigrations[version]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration executes the statement of a migration and the update of the version in a single transaction,
+// so a failed migration leaves the database in the previous version
+func (s *SQLiteOperationStore) applyMigration(version int, statement string) derrors.Error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return derrors.AsError(err, "cannot begin operations database migration")
+	}
+	if _, err := tx.Exec(statement); err != nil {
+		tx.Rollback()
+		return derrors.AsErrorWithParams(err, "cannot migrate operations database", version)
+	}
+	// PRAGMA does not support parameters
+	if _, err := tx.Exec("PRAGMA user_version = " + strconv.Itoa(version)); err != nil {
+		tx.Rollback()
+		return derrors.AsError(err, "cannot update operations database version")
+	}
+	if err := tx.Commit(); err != nil {
+		return derrors.AsErrorWithParams(err, "cannot commit operations database migration", version)
+	}
+	return nil
+}
+
+// scanner is implemented by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOperation(row scanner) (*DownloadOperation, error) {
+	ope := &DownloadOperation{}
+	var checkpoint, targets string
+	err := row.Scan(&ope.RequestId, &ope.OrganizationId, &ope.UserId, &ope.State, &ope.Started, &ope.From, &ope.To,
+		&ope.Expiration, &ope.Info, &ope.Url, &ope.Directory, &ope.Retention,
+		&ope.Progress.Entries, &ope.Progress.Bytes, &ope.Progress.Pages, &ope.Progress.Covered, &ope.Progress.Retries,
+		&checkpoint, &ope.Fingerprint, &targets, &ope.Archive, &ope.Generated)
+	if err != nil {
+		return nil, err
+	}
+	if targets != "" {
+		if err := json.Unmarshal([]byte(targets), &ope.Progress.Targets); err != nil {
+			return nil, err
+		}
+	}
+	if checkpoint != "" {
+		ope.Checkpoint = &Checkpoint{}
+		if err := json.Unmarshal([]byte(checkpoint), ope.Checkpoint); err != nil {
+			return nil, err
+		}
+	}
+	return ope, nil
+}
+
+// encodeCheckpoint returns the JSON of a checkpoint, an empty string if there is none
+func encodeCheckpoint(checkpoint *Checkpoint) (string, derrors.Error) {
+	if checkpoint == nil {
+		return "", nil
+	}
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return "", derrors.AsError(err, "cannot encode checkpoint")
+	}
+	return string(data), nil
+}
+
+// encodeTargets returns the JSON of the progress of the targets, an empty string if there are none
+func encodeTargets(targets []TargetProgress) (string, derrors.Error) {
+	if len(targets) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(targets)
+	if err != nil {
+		return "", derrors.AsError(err, "cannot encode targets progress")
+	}
+	return string(data), nil
+}
+
+// get retrieves an operation, the lock must be held
+func (s *SQLiteOperationStore) get(requestId string) (*DownloadOperation, derrors.Error) {
+	row := s.db.QueryRow("SELECT "+sqliteOperationColumns+" FROM operations WHERE request_id = ?", requestId)
+	ope, err := scanOperation(row)
+	if err == sql.ErrNoRows {
+		return nil, derrors.NewNotFoundError("download operation").WithParams(requestId)
+	}
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot retrieve download operation")
+	}
+	return ope, nil
+}
+
+// save inserts or replaces an operation, the lock must be held
+func (s *SQLiteOperationStore) save(ope *DownloadOperation) derrors.Error {
+	checkpoint, cErr := encodeCheckpoint(ope.Checkpoint)
+	if cErr != nil {
+		return cErr
+	}
+	targets, tErr := encodeTargets(ope.Progress.Targets)
+	if tErr != nil {
+		return tErr
+	}
+	_, err := s.db.Exec("INSERT OR REPLACE INTO operations ("+sqliteOperationColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		ope.RequestId, ope.OrganizationId, ope.UserId, ope.State, ope.Started, ope.From, ope.To, ope.Expiration,
+		ope.Info, ope.Url, ope.Directory, ope.Retention,
+		ope.Progress.Entries, ope.Progress.Bytes, ope.Progress.Pages, ope.Progress.Covered, ope.Progress.Retries,
+		checkpoint, ope.Fingerprint, targets, ope.Archive, ope.Generated)
+	if err != nil {
+		return derrors.AsError(err, "cannot store download operation")
+	}
+	return nil
+}
+
+func (s *SQLiteOperationStore) Add(organizationId string, requestId string, from int64, to int64, directory string, userID string) (*DownloadOperation, derrors.Error) {
+	s.Lock()
+	defer s.Unlock()
+
+	_, err := s.get(requestId)
+	if err == nil {
+		return nil, derrors.NewAlreadyExistsError("operation").WithParams(requestId)
+	}
+	if err.Type() != derrors.NotFound {
+		return nil, err
+	}
+
+	op := &DownloadOperation{
+		OrganizationId: organizationId,
+		RequestId:      requestId,
+		Started:        time.Now().UnixNano(),
+		State:          Queue,
+		From:           from,
+		To:             to,
+		Directory:      directory,
+		UserId:         userID,
+	}
+	if err := s.save(op); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (s *SQLiteOperationStore) Get(requestId string) (*DownloadOperation, derrors.Error) {
+	s.Lock()
+	defer s.Unlock()
+
+	operation, err := s.get(requestId)
+	if err != nil {
+		return nil, err
+	}
+	if operation.State == Ready && operation.Expiration < time.Now().UnixNano() {
+		operation.Info = ExpiredMsg
+	}
+	return operation, nil
+}
+
+func (s *SQLiteOperationStore) Update(requestId string, state DownloadLogState, info string) derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	log.Debug().Str("requestId", requestId).Interface("state", state).Str("info", info).Msg("updating operation state")
+
+	operation, err := s.get(requestId)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return derrors.NewNotFoundError("operation").WithParams(requestId)
+		}
+		return err
+	}
+	applyUpdate(operation, state, info, s.url, s.policies.Get(operation.OrganizationId))
+	if err := s.save(operation); err != nil {
+		return err
+	}
+	s.schedule(operation, time.Now())
+	return nil
+}
+
+func (s *SQLiteOperationStore) UpdateProgress(requestId string, progress GenerationProgress) derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	targets, tErr := encodeTargets(progress.Targets)
+	if tErr != nil {
+		return tErr
+	}
+	result, err := s.db.Exec("UPDATE operations SET progress_entries = ?, progress_bytes = ?, progress_pages = ?, progress_covered = ?, progress_retries = ?, progress_targets = ? WHERE request_id = ?",
+		progress.Entries, progress.Bytes, progress.Pages, progress.Covered, progress.Retries, targets, requestId)
+	if err != nil {
+		return derrors.AsError(err, "cannot update download operation progress")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return derrors.AsError(err, "cannot update download operation progress")
+	}
+	if affected == 0 {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	return nil
+}
+
+func (s *SQLiteOperationStore) SaveCheckpoint(requestId string, checkpoint Checkpoint, progress GenerationProgress) derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	operation, err := s.get(requestId)
+	if err != nil {
+		return err
+	}
+	operation.Checkpoint = &checkpoint
+	operation.Progress = progress
+	return s.save(operation)
+}
+
+func (s *SQLiteOperationStore) SetFingerprint(requestId string, fingerprint string, generated int64) derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	result, err := s.db.Exec("UPDATE operations SET fingerprint = ?, generated = ? WHERE request_id = ?", fingerprint, generated, requestId)
+	if err != nil {
+		return derrors.AsError(err, "cannot update download operation fingerprint")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return derrors.AsError(err, "cannot update download operation fingerprint")
+	}
+	if affected == 0 {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	return nil
+}
+
+func (s *SQLiteOperationStore) SetArchive(requestId string, extension string) derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	result, err := s.db.Exec("UPDATE operations SET archive = ? WHERE request_id = ?", extension, requestId)
+	if err != nil {
+		return derrors.AsError(err, "cannot update download operation archive")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return derrors.AsError(err, "cannot update download operation archive")
+	}
+	if affected == 0 {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	return nil
+}
+
+func (s *SQLiteOperationStore) Remove(requestId string) derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	result, err := s.db.Exec("DELETE FROM operations WHERE request_id = ?", requestId)
+	if err != nil {
+		return derrors.AsError(err, "cannot remove download operation")
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return derrors.AsError(err, "cannot remove download operation")
+	}
+	if affected == 0 {
+		return derrors.NewNotFoundError("operation").WithParams(requestId)
+	}
+	s.scheduler.Cancel(requestId)
+	return nil
+}
+
+func (s *SQLiteOperationStore) List(organizationID string) ([]*DownloadOperation, derrors.Error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.query("SELECT "+sqliteOperationColumns+" FROM operations WHERE organization_id = ?", organizationID)
+}
+
+func (s *SQLiteOperationStore) ListAll() ([]*DownloadOperation, derrors.Error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.query("SELECT " + sqliteOperationColumns + " FROM operations")
+}
+
+// query retrieves the operations returned by a select statement, the lock must be held
+func (s *SQLiteOperationStore) query(statement string, args ...interface{}) ([]*DownloadOperation, derrors.Error) {
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot list download operations")
+	}
+	defer rows.Close()
+
+	now := time.Now().UnixNano()
+	list := make([]*DownloadOperation, 0)
+	for rows.Next() {
+		ope, err := scanOperation(rows)
+		if err != nil {
+			return nil, derrors.AsError(err, "cannot read download operation")
+		}
+		if ope.State == Ready && ope.Expiration < now {
+			ope.Info = ExpiredMsg
+		}
+		list = append(list, ope)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, derrors.AsError(err, "cannot list download operations")
+	}
+	return list, nil
+}
+
+func (s *SQLiteOperationStore) Expire() derrors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	candidates, err := s.query("SELECT "+sqliteOperationColumns+" FROM operations WHERE state IN (?, ?, ?, ?)", Ready, Error, Downloaded, Cancelled)
+	if err != nil {
+		return err
+	}
+	for _, ope := range candidates {
+		if err := s.review(ope, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// onDeadline is called by the scheduler when the deadline of an operation is reached
+func (s *SQLiteOperationStore) onDeadline(requestId string) {
+	s.Lock()
+	defer s.Unlock()
+
+	ope, err := s.get(requestId)
+	if err != nil {
+		if err.Type() != derrors.NotFound {
+			log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("cannot review operation")
+		}
+		return
+	}
+	if err := s.review(ope, time.Now()); err != nil {
+		log.Warn().Str("requestId", requestId).Str("trace", err.DebugReport()).Msg("cannot review operation")
+	}
+}
+
+// review removes the expired zip file or operation and schedules its next deadline, the lock must be held
+func (s *SQLiteOperationStore) review(ope *DownloadOperation, now time.Time) derrors.Error {
+	if ope.IsExpired(now, s.policies.Get(ope.OrganizationId)) {
+		removeArtifacts(ope)
+		if _, err := s.db.Exec("DELETE FROM operations WHERE request_id = ?", ope.RequestId); err != nil {
+			return derrors.AsError(err, "cannot remove expired operation")
+		}
+		s.scheduler.Cancel(ope.RequestId)
+		log.Debug().Str("requestId", ope.RequestId).Msg("deleted")
+		return nil
+	}
+	if ope.IsArtifactExpired(now) {
+		removeArtifacts(ope)
+	}
+	s.schedule(ope, now)
+	return nil
+}
+
+// schedule sets the next deadline of an operation, the lock must be held
+func (s *SQLiteOperationStore) schedule(ope *DownloadOperation, now time.Time) {
+	at, scheduled := ope.NextDeadline(now, s.policies.Get(ope.OrganizationId))
+	if scheduled {
+		s.scheduler.Schedule(ope.RequestId, at)
+	} else {
+		s.scheduler.Cancel(ope.RequestId)
+	}
+}
+
+func (s *SQLiteOperationStore) Close() error {
+	// the scheduler is stopped before taking the lock as it may be waiting for it
+	s.scheduler.Stop()
+
+	s.Lock()
+	defer s.Unlock()
+	return s.db.Close()
+}
@@ -0,0 +1,233 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/google/uuid"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"os"
+	"time"
+)
+
+const sqliteTestDir = "./sqliteTestDir/"
+
+var _ = ginkgo.Describe("SQLite operation store", func() {
+
+	var store *SQLiteOperationStore
+	var organizationID string
+
+	ginkgo.BeforeEach(func() {
+		err := os.MkdirAll(sqliteTestDir, os.ModePerm)
+		gomega.Expect(err).To(gomega.Succeed())
+		var sErr error
+		store, sErr = NewSQLiteOperationStore("/test/", "nalej.tech", GetSQLitePath(sqliteTestDir), NewRetentionPolicies(DefaultRetentionPolicy()))
+		gomega.Expect(sErr).To(gomega.Succeed())
+		organizationID = uuid.New().String()
+	})
+	ginkgo.AfterEach(func() {
+		gomega.Expect(store.Close()).To(gomega.Succeed())
+		err := os.RemoveAll(sqliteTestDir)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should be able to add and get an operation", func() {
+		requestID := uuid.New().String()
+		_, err := store.Add(organizationID, requestID, 1, 2, sqliteTestDir, "user")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		ope, err := store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.RequestId).Should(gomega.Equal(requestID))
+		gomega.Expect(ope.State).Should(gomega.Equal(Queue))
+		gomega.Expect(ope.From).Should(gomega.Equal(int64(1)))
+		gomega.Expect(ope.UserId).Should(gomega.Equal("user"))
+
+		_, err = store.Add(organizationID, requestID, 1, 2, sqliteTestDir, "user")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should be able to update an operation", func() {
+		requestID := uuid.New().String()
+		_, err := store.Add(organizationID, requestID, 0, 0, sqliteTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = store.Update(requestID, Ready, "file generated")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		ope, err := store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.State).Should(gomega.Equal(Ready))
+		gomega.Expect(ope.Url).Should(gomega.Equal("https://web.nalej.tech/test/" + requestID + ".zip"))
+
+		err = store.Update(uuid.New().String(), Ready, "")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should be able to update the progress of an operation", func() {
+		requestID := uuid.New().String()
+		_, err := store.Add(organizationID, requestID, 0, 0, sqliteTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		progress := GenerationProgress{Entries: 10, Bytes: 200, Pages: 2, Covered: 0.5, Retries: 1,
+			Targets: []TargetProgress{{Target: &grpc_log_download_manager_go.LogTarget{AppInstanceId: "app"}, Name: "name", Entries: 10}}}
+		err = store.UpdateProgress(requestID, progress)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		ope, err := store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Progress).Should(gomega.Equal(progress))
+
+		err = store.UpdateProgress(uuid.New().String(), progress)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		checkpoint := Checkpoint{Request: &grpc_log_download_manager_go.DownloadLogRequest{OrganizationId: organizationID}, Bytes: 200,
+			Shards: []ShardCheckpoint{{Start: 0, End: 10, From: 5, To: 10, Bytes: 100, Seen: []string{"key"}}}}
+		err = store.SaveCheckpoint(requestID, checkpoint, progress)
+		gomega.Expect(err).To(gomega.Succeed())
+		ope, err = store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*ope.Checkpoint).Should(gomega.Equal(checkpoint))
+
+		err = store.Update(requestID, Ready, "file generated")
+		gomega.Expect(err).To(gomega.Succeed())
+		ope, err = store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Checkpoint).Should(gomega.BeNil())
+	})
+
+	ginkgo.It("should be able to set the fingerprint of an operation", func() {
+		requestID := uuid.New().String()
+		_, err := store.Add(organizationID, requestID, 0, 0, sqliteTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = store.SetFingerprint(requestID, "fingerprint", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		ope, err := store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Fingerprint).Should(gomega.Equal("fingerprint"))
+		gomega.Expect(ope.Generated).Should(gomega.BeZero())
+
+		// the generation time is set when the generation starts
+		gomega.Expect(store.Update(requestID, Generating, "")).To(gomega.Succeed())
+		ope, err = store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		generated := ope.Generated
+		gomega.Expect(generated).ShouldNot(gomega.BeZero())
+		gomega.Expect(store.Update(requestID, Generating, "")).To(gomega.Succeed())
+		ope, err = store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Generated).Should(gomega.Equal(generated))
+
+		err = store.SetFingerprint(requestID, "reused", 42)
+		gomega.Expect(err).To(gomega.Succeed())
+		ope, err = store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Generated).Should(gomega.Equal(int64(42)))
+
+		err = store.SetFingerprint(uuid.New().String(), "fingerprint", 0)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should be able to set the archive of an operation", func() {
+		requestID := uuid.New().String()
+		_, err := store.Add(organizationID, requestID, 0, 0, sqliteTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = store.SetArchive(requestID, ".tar.zst")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = store.Update(requestID, Ready, "file generated")
+		gomega.Expect(err).To(gomega.Succeed())
+		ope, err := store.Get(requestID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(ope.Archive).Should(gomega.Equal(".tar.zst"))
+		gomega.Expect(ope.Url).Should(gomega.Equal("https://web.nalej.tech/test/" + requestID + ".tar.zst"))
+
+		err = store.SetArchive(uuid.New().String(), ".tar.zst")
+		gomega.Expect(err).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("should be able to remove and list operations", func() {
+		num := 5
+		for i := 0; i < num; i++ {
+			_, err := store.Add(organizationID, uuid.New().String(), 0, 0, sqliteTestDir, "")
+			gomega.Expect(err).To(gomega.Succeed())
+		}
+		list, err := store.List(organizationID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list)).Should(gomega.Equal(num))
+
+		err = store.Remove(list[0].RequestId)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = store.Remove(list[0].RequestId)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		list, err = store.List(organizationID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(len(list)).Should(gomega.Equal(num - 1))
+	})
+
+	ginkgo.It("should expire old operations", func() {
+		expired := uuid.New().String()
+		_, err := store.Add(organizationID, expired, 0, 0, sqliteTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = store.Update(expired, Error, "failed")
+		gomega.Expect(err).To(gomega.Succeed())
+		_, dbErr := store.db.Exec("UPDATE operations SET retention = ? WHERE request_id = ?",
+			time.Now().Add(-time.Minute).UnixNano(), expired)
+		gomega.Expect(dbErr).To(gomega.Succeed())
+
+		alive := uuid.New().String()
+		_, err = store.Add(organizationID, alive, 0, 0, sqliteTestDir, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = store.Update(alive, Error, "failed")
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gomega.Expect(store.Expire()).To(gomega.Succeed())
+
+		_, err = store.Get(expired)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+		_, err = store.Get(alive)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should not apply part of a failed migration", func() {
+		gomega.Expect(store.Close()).To(gomega.Succeed())
+		migrations := sqliteMigrations
+		defer func() {
+			sqliteMigrations = migrations
+		}()
+		path := GetSQLitePath(sqliteTestDir)
+		policies := NewRetentionPolicies(DefaultRetentionPolicy())
+
+		// the second statement fails, the first one is rolled back
+		sqliteMigrations = append(migrations[:len(migrations):len(migrations)],
+			`ALTER TABLE operations ADD COLUMN extra INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE missing ADD COLUMN extra INTEGER NOT NULL DEFAULT 0`)
+		_, err := NewSQLiteOperationStore("/test/", "nalej.tech", path, policies)
+		gomega.Expect(err).NotTo(gomega.Succeed())
+
+		sqliteMigrations = append(migrations[:len(migrations):len(migrations)],
+			`ALTER TABLE operations ADD COLUMN extra INTEGER NOT NULL DEFAULT 0`)
+		store, err = NewSQLiteOperationStore("/test/", "nalej.tech", path, policies)
+		gomega.Expect(err).To(gomega.Succeed())
+		var version int
+		gomega.Expect(store.db.QueryRow("PRAGMA user_version").Scan(&version)).To(gomega.Succeed())
+		gomega.Expect(version).Should(gomega.Equal(len(sqliteMigrations)))
+	})
+})
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"fmt"
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"regexp"
+	"strings"
+)
+
+// targetFileExtension is the extension of the files of the targets inside the archive
+const targetFileExtension = ".log"
+
+// unsafeFileNameChars matches the characters replaced in the names of the files of the targets
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// TargetProgress contains the entries written for a target of a request
+type TargetProgress struct {
+	// Target identifies the descriptor, instance, service group and service of the entries
+	Target *grpc_log_download_manager_go.LogTarget
+	// Name is built from the names of the first entry of the target, empty until an entry is written
+	Name string
+	// Entries is the number of log entries written
+	Entries int64
+	// Extension of the file of the target, it depends on the output format
+	Extension string
+}
+
+// NewTargetsProgress creates the progress of the targets of a request
+func NewTargetsProgress(request *grpc_log_download_manager_go.DownloadLogRequest) []TargetProgress {
+	if len(request.Targets) == 0 {
+		return nil
+	}
+	targets := make([]TargetProgress, 0, len(request.Targets))
+	extension := NewEntryFormat(request).Extension(targetFileExtension)
+	for _, target := range request.Targets {
+		targets = append(targets, TargetProgress{Target: target, Extension: extension})
+	}
+	return targets
+}
+
+// TargetName returns the name of a target using the descriptor, instance and, if the target selects them,
+// service group and service names of one of its entries
+func TargetName(target *grpc_log_download_manager_go.LogTarget, entry *grpc_application_manager_go.LogEntryResponse) string {
+	parts := []string{entry.AppDescriptorName, entry.AppInstanceName}
+	if target.ServiceGroupId != "" {
+		parts = append(parts, entry.ServiceGroupName)
+	}
+	if target.ServiceId != "" {
+		parts = append(parts, entry.ServiceName)
+	}
+	return strings.Join(parts, "_")
+}
+
+// targetIdName returns the name of a target without entries, built from its identifiers
+func targetIdName(target *grpc_log_download_manager_go.LogTarget) string {
+	parts := make([]string, 0)
+	for _, id := range []string{target.AppDescriptorId, target.AppInstanceId, target.ServiceGroupId, target.ServiceId} {
+		if id != "" {
+			parts = append(parts, id)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+// TargetFileNames returns the names of the files of the targets inside the archive. The names are made safe
+// for a file system and unique, the targets without entries are named by their identifiers.
+func TargetFileNames(targets []TargetProgress) []string {
+	names := make([]string, 0, len(targets))
+	used := make(map[string]bool, 0)
+	for i, target := range targets {
+		name := target.Name
+		if name == "" && target.Target != nil {
+			name = targetIdName(target.Target)
+		}
+		name = strings.Trim(unsafeFileNameChars.ReplaceAllString(name, "-"), "-.")
+		if name == "" {
+			name = fmt.Sprintf("target-%d", i)
+		}
+		if used[name] {
+			name = fmt.Sprintf("%s-%d", name, i)
+		}
+		used[name] = true
+		extension := target.Extension
+		if extension == "" {
+			extension = targetFileExtension
+		}
+		names = append(names, name+extension)
+	}
+	return names
+}
+
+// TargetsToGRPC converts the progress of the targets, including the names of their files
+func TargetsToGRPC(targets []TargetProgress) []*grpc_log_download_manager_go.TargetEntries {
+	if len(targets) == 0 {
+		return nil
+	}
+	names := TargetFileNames(targets)
+	result := make([]*grpc_log_download_manager_go.TargetEntries, 0, len(targets))
+	for i, target := range targets {
+		result = append(result, &grpc_log_download_manager_go.TargetEntries{
+			Target:   target.Target,
+			FileName: names[i],
+			Entries:  target.Entries,
+		})
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package utils
+
+import (
+	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("Targets", func() {
+
+	entry := &grpc_application_manager_go.LogEntryResponse{
+		AppDescriptorName: "shop",
+		AppInstanceName:   "shop-prod",
+		ServiceGroupName:  "backend",
+		ServiceName:       "cart api",
+	}
+
+	ginkgo.It("should name a target after the names it selects", func() {
+		gomega.Expect(TargetName(&grpc_log_download_manager_go.LogTarget{AppInstanceId: "i"}, entry)).Should(gomega.Equal("shop_shop-prod"))
+		gomega.Expect(TargetName(&grpc_log_download_manager_go.LogTarget{AppInstanceId: "i", ServiceGroupId: "g", ServiceId: "s"}, entry)).
+			Should(gomega.Equal("shop_shop-prod_backend_cart api"))
+	})
+
+	ginkgo.It("should return safe and unique file names", func() {
+		names := TargetFileNames([]TargetProgress{
+			{Target: &grpc_log_download_manager_go.LogTarget{AppInstanceId: "i1"}, Name: "shop_shop-prod_cart api"},
+			{Target: &grpc_log_download_manager_go.LogTarget{AppInstanceId: "i2"}, Name: "shop_shop-prod_cart api"},
+			{Target: &grpc_log_download_manager_go.LogTarget{AppDescriptorId: "d3", AppInstanceId: "i3"}},
+			{Target: &grpc_log_download_manager_go.LogTarget{AppInstanceId: "i4"}, Name: "../.."},
+		})
+		gomega.Expect(names).Should(gomega.Equal([]string{
+			"shop_shop-prod_cart-api.log",
+			"shop_shop-prod_cart-api-1.log",
+			"d3_i3.log",
+			"target-3.log",
+		}))
+	})
+
+	ginkgo.It("should report the entries of each target", func() {
+		request := &grpc_log_download_manager_go.DownloadLogRequest{
+			Targets: []*grpc_log_download_manager_go.LogTarget{{AppInstanceId: "i1"}, {AppInstanceId: "i2"}},
+		}
+		targets := NewTargetsProgress(request)
+		gomega.Expect(targets).Should(gomega.HaveLen(2))
+		targets[1].Entries = 5
+		result := TargetsToGRPC(targets)
+		gomega.Expect(result).Should(gomega.HaveLen(2))
+		gomega.Expect(result[1].Target).Should(gomega.Equal(request.Targets[1]))
+		gomega.Expect(result[1].FileName).Should(gomega.Equal("i2.log"))
+		gomega.Expect(result[1].Entries).Should(gomega.Equal(int64(5)))
+
+		gomega.Expect(NewTargetsProgress(&grpc_log_download_manager_go.DownloadLogRequest{})).Should(gomega.BeNil())
+	})
+})
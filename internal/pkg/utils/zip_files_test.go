@@ -21,6 +21,7 @@ import (
 	"github.com/nalej/grpc-application-manager-go"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
+	"io/ioutil"
 	"os"
 	"time"
 )
@@ -73,5 +74,23 @@ var _ = ginkgo.Describe("Zip Files", func() {
 			gomega.Expect(err).To(gomega.Succeed())
 		})
 	})
+	ginkgo.Context("Merging segments", func() {
+
+		ginkgo.It("should append the segments in order", func() {
+			target := GetFilePath(testDir, "id")
+			gomega.Expect(InitializeFile(target, false)).To(gomega.Succeed())
+			for i, content := range []string{"first\n", "second\n", "third\n"} {
+				gomega.Expect(ioutil.WriteFile(GetSegmentPath(testDir, "id", i), []byte(content), 0644)).To(gomega.Succeed())
+			}
+			gomega.Expect(GetSegmentPaths(testDir, "id")).Should(gomega.HaveLen(3))
+
+			err := MergeFiles(target, []string{GetSegmentPath(testDir, "id", 2), GetSegmentPath(testDir, "id", 0),
+				GetSegmentPath(testDir, "id", 1)})
+			gomega.Expect(err).To(gomega.Succeed())
+			content, err := ioutil.ReadFile(target)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(string(content)).Should(gomega.Equal("third\nfirst\nsecond\n"))
+		})
+	})
 
 })
@@ -20,9 +20,12 @@ import (
 	"archive/zip"
 	"fmt"
 	"github.com/nalej/grpc-application-manager-go"
+	"github.com/nalej/grpc-log-download-manager-go"
 	"github.com/rs/zerolog/log"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -44,6 +47,30 @@ func InitializeFile(target string, includeMetadata bool) error {
 	return nil
 }
 
+// InitializeFormattedFile creates the file and writes the header of the format, if any
+func InitializeFormattedFile(target string, format EntryFormat) error {
+	if err := InitializeFile(target, format.IncludeMetadata); err != nil {
+		return err
+	}
+	if header := format.Header(); header != "" {
+		return AppendFile(target, header)
+	}
+	return nil
+}
+
+// AppendFile appends the content to the file
+func AppendFile(target string, content string) error {
+	f, err := os.OpenFile(target, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func AppendResponses(responses []*grpc_application_manager_go.LogEntryResponse, target string, includeMetadata bool) error {
 	log.Debug().Bool("includeMedatada", includeMetadata).Msg("AppendResponse")
 	f, err := os.OpenFile(target, os.O_APPEND|os.O_WRONLY, 0644)
@@ -52,12 +79,7 @@ func AppendResponses(responses []*grpc_application_manager_go.LogEntryResponse,
 	}
 	var writeErr error
 	for _, response := range responses {
-		if includeMetadata{
-			_, writeErr = f.WriteString(fmt.Sprintf(logWithMetadata, time.Unix(0, response.Timestamp), response.AppDescriptorName, response.AppInstanceName,
-				response.ServiceGroupName, response.ServiceName, response.Msg))
-		}else {
-			_, writeErr = f.WriteString(fmt.Sprintf("%s\n", response.Msg))
-		}
+		_, writeErr = f.WriteString(FormatResponse(response, includeMetadata))
 		if writeErr != nil {
 			f.Close()
 			return writeErr
@@ -72,31 +94,60 @@ func AppendResponses(responses []*grpc_application_manager_go.LogEntryResponse,
 	return nil
 }
 
-func RemoveFile (path string) error {
-	return os.Remove(path)
+// FormatResponse returns the line written in the file for a log entry
+func FormatResponse(response *grpc_application_manager_go.LogEntryResponse, includeMetadata bool) string {
+	if includeMetadata {
+		return fmt.Sprintf(logWithMetadata, time.Unix(0, response.Timestamp), response.AppDescriptorName, response.AppInstanceName,
+			response.ServiceGroupName, response.ServiceName, response.Msg)
+	}
+	return fmt.Sprintf("%s\n", response.Msg)
 }
-// ZipFiles compresses one or many files into a single zip archive file.
-func ZipFiles(filename string, files []string) error {
 
-	newZipFile, err := os.Create(filename)
-	if err != nil {
+// WriteFile creates a file with the given content
+func WriteFile(target string, content string) error {
+	return ioutil.WriteFile(target, []byte(content), 0644)
+}
+
+// LinkFile makes the target share the content of the source, copying it if the file system does not support links
+func LinkFile(source string, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	if err := InitializeFile(target, false); err != nil {
 		return err
 	}
-	defer newZipFile.Close()
+	return MergeFiles(target, []string{source})
+}
 
-	zipWriter := zip.NewWriter(newZipFile)
-	defer zipWriter.Close()
+func RemoveFile (path string) error {
+	return os.Remove(path)
+}
+
+// ArchiveFile is a file added to an archive with the given name
+type ArchiveFile struct {
+	Path string
+	Name string
+}
 
-	// Add files to zip
+// ZipFiles compresses one or many files into a single zip archive file.
+func ZipFiles(filename string, files []string) error {
+	archiveFiles := make([]ArchiveFile, 0, len(files))
 	for _, file := range files {
-		if err = AddFileToZip(zipWriter, file); err != nil {
-			return err
-		}
+		archiveFiles = append(archiveFiles, ArchiveFile{Path: file, Name: filepath.Base(file)})
 	}
-	return nil
+	return ZipNamedFiles(filename, archiveFiles)
+}
+
+// ZipNamedFiles compresses the files into a single zip archive file, naming each one as requested
+func ZipNamedFiles(filename string, files []ArchiveFile) error {
+	return WriteArchive(filename, grpc_log_download_manager_go.ArchiveFormat_ZIP, files)
 }
 
 func AddFileToZip(zipWriter *zip.Writer, filename string) error {
+	return addNamedFileToZip(zipWriter, filename, filepath.Base(filename))
+}
+
+func addNamedFileToZip(zipWriter *zip.Writer, filename string, name string) error {
 
 	fileToZip, err := os.Open(filename)
 	if err != nil {
@@ -117,7 +168,7 @@ func AddFileToZip(zipWriter *zip.Writer, filename string) error {
 
 	// Using FileInfoHeader() above only uses the basename of the file. If we want
 	// to preserve the folder structure we can overwrite this with the full path.
-	//header.Name = filename
+	header.Name = name
 
 	// Change to deflate to gain better compression
 	// see http://golang.org/pkg/archive/zip/#pkg-constants
@@ -135,5 +186,63 @@ func GetFilePath(filesDirectory string, requestId string) string {
 	return fmt.Sprintf("%s%s.file", filesDirectory, requestId)
 }
 func GetZipFilePath(filesDirectory string, requestId string) string {
-	return fmt.Sprintf("%s%s.zip", filesDirectory, requestId)
+	return GetArchiveFilePath(filesDirectory, requestId, ZipExtension)
+}
+
+// GetTruncatedFilePath returns the path of the file added to the archive of a truncated operation
+func GetTruncatedFilePath(filesDirectory string, requestId string) string {
+	return fmt.Sprintf("%s%s.truncated", filesDirectory, requestId)
+}
+
+// GetTargetFilePath returns the path of the file with the entries of a target
+func GetTargetFilePath(filesDirectory string, requestId string, target int) string {
+	return fmt.Sprintf("%s%s.%d.file", filesDirectory, requestId, target)
+}
+
+// GetTargetFilePaths returns the paths of the files of the targets of an operation found in the directory
+func GetTargetFilePaths(filesDirectory string, requestId string) []string {
+	paths, err := filepath.Glob(fmt.Sprintf("%s%s.*.file", filesDirectory, requestId))
+	if err != nil {
+		return []string{}
+	}
+	return paths
+}
+
+// GetSegmentPath returns the path of the file with the entries of a shard
+func GetSegmentPath(filesDirectory string, requestId string, shard int) string {
+	return fmt.Sprintf("%s%s.%d.segment", filesDirectory, requestId, shard)
+}
+
+// GetSegmentPaths returns the paths of the segment files of an operation found in the directory
+func GetSegmentPaths(filesDirectory string, requestId string) []string {
+	paths, err := filepath.Glob(fmt.Sprintf("%s%s.*.segment", filesDirectory, requestId))
+	if err != nil {
+		return []string{}
+	}
+	return paths
+}
+
+// MergeFiles appends the content of the sources to the target, in order
+func MergeFiles(target string, sources []string) error {
+	f, err := os.OpenFile(target, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		if err := appendFile(f, source); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func appendFile(target io.Writer, source string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(target, f)
+	return err
 }
\ No newline at end of file